@@ -1,14 +1,99 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+
 	"vox/internal/ipc"
 )
 
+const usage = `usage: vox-ctl [command] [args...]
+
+commands:
+  trigger      toggle listening (default)
+  start        start a capture session
+  stop         stop recording and process what was heard
+  cancel       abort the active session
+  status       show daemon state
+  last         show the result of the last session
+  say <text>   speak text aloud
+  hush         stop speaking
+  reload       re-read the daemon config
+  wake [on|off]
+               show or toggle hands-free wake phrase listening
+  watch [type...]
+               stream daemon events as JSON lines
+`
+
 func main() {
-	err := ipc.SendCommand("trigger")
+	cmd := "trigger"
+	var args []string
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+		args = os.Args[2:]
+	}
+
+	switch cmd {
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+		return
+	case "say":
+		if len(args) == 0 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		args = []string{strings.Join(args, " ")}
+	case "watch":
+		watch(args)
+		return
+	}
+
+	reply, err := ipc.Call(cmd, args...)
+	if err != nil {
+		fail(err)
+	}
+
+	printResult(reply.Result)
+}
+
+func fail(err error) {
+	if errors.Is(err, ipc.ErrNotRunning) {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		fmt.Fprintln(os.Stderr, "vox-daemon:", err)
+	}
+	os.Exit(1)
+}
+
+func watch(types []string) {
+	enc := json.NewEncoder(os.Stdout)
+	err := ipc.Watch(func(ev ipc.Event) error {
+		return enc.Encode(ev)
+	}, types...)
 	if err != nil {
-		fmt.Println("vox-daemon not running:", err)
+		fail(err)
+	}
+}
+
+func printResult(raw json.RawMessage) {
+	if len(raw) == 0 {
+		return
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		fmt.Println(s)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		fmt.Println(string(raw))
 		return
 	}
+	fmt.Println(buf.String())
 }
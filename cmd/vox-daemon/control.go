@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "log/slog"
+
+	"vox/internal/ipc"
+)
+
+type statusReport struct {
+	State   sessionState `json:"state"`
+	Session int          `json:"session,omitempty"`
+	Wake    bool         `json:"wake"`
+	Hub     string       `json:"hub"`
+	Uptime  string       `json:"uptime"`
+}
+
+func (d *daemon) handleControl(msg ipc.ControlMessage) (any, error) {
+	log.Debug("Control command", "id", msg.ID, "cmd", msg.Cmd, "args", msg.Args)
+
+	switch msg.Cmd {
+	case "trigger":
+		state, err := d.toggle()
+		if err != nil {
+			return nil, err
+		}
+		return d.status(state), nil
+
+	case "start":
+		if err := d.startSession(sessionSource{}); err != nil {
+			return nil, err
+		}
+		return d.status(stateListening), nil
+
+	case "stop":
+		if err := d.stopListening(); err != nil {
+			return nil, err
+		}
+		return d.status(stateProcessing), nil
+
+	case "cancel":
+		if err := d.cancelSession(); err != nil {
+			return nil, err
+		}
+		return "cancelled", nil
+
+	case "status":
+		return d.status(d.currentState()), nil
+
+	case "last":
+		d.mu.Lock()
+		last := d.last
+		d.mu.Unlock()
+		if last == nil {
+			return nil, errors.New("no finished sessions yet")
+		}
+		return last, nil
+
+	case "wake":
+		if len(msg.Args) > 0 {
+			var on bool
+			switch msg.Args[0] {
+			case "on":
+				on = true
+			case "off":
+			default:
+				return nil, fmt.Errorf("wake: want on or off, got %q", msg.Args[0])
+			}
+			if err := d.setWake(on); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]bool{"wake": d.wakeRunning()}, nil
+
+	case "reload":
+		return d.reload()
+
+	case "hush":
+		if err := d.hush(); err != nil {
+			return nil, err
+		}
+		return "hushed", nil
+
+	case "say":
+		text := strings.TrimSpace(strings.Join(msg.Args, " "))
+		if text == "" {
+			return nil, errors.New("say: empty text")
+		}
+		if err := d.say(text); err != nil {
+			return nil, err
+		}
+		return "spoken", nil
+
+	default:
+		log.Warn("Unknown command", "cmd", msg.Cmd)
+		return nil, fmt.Errorf("unknown command %q", msg.Cmd)
+	}
+}
+
+func (d *daemon) status(state sessionState) statusReport {
+	wake := d.wakeRunning()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rep := statusReport{
+		State:  state,
+		Wake:   wake,
+		Hub:    string(d.hub),
+		Uptime: time.Since(d.booted).Round(time.Second).String(),
+	}
+	if state != stateIdle {
+		rep.Session = d.session
+	}
+
+	return rep
+}
+
+func (d *daemon) say(text string) error {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+
+	err := d.speak(context.Background(), text, "")
+	if err != nil {
+		log.Error("Failed to voice out", "err", err)
+	}
+	return err
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "log/slog"
+
+	openai "github.com/openai/openai-go/v3"
+
+	"vox/internal/answer"
+	"vox/internal/audio"
+	"vox/internal/config"
+	"vox/internal/dialogue"
+	"vox/internal/ipc"
+	"vox/internal/nlu"
+	"vox/internal/notify"
+	"vox/internal/tts"
+	"vox/internal/wake"
+	"vox/pkg/protocol"
+	"vox/pkg/stt"
+)
+
+type sessionState string
+
+const (
+	stateIdle       sessionState = "idle"
+	stateListening  sessionState = "listening"
+	stateProcessing sessionState = "processing"
+)
+
+// frameSize is the capture frame, 20ms at 16 kHz, shared with the VAD.
+const frameSize = 320
+
+// restoreTimeout bounds putting ducked audio back at startup and exit.
+const restoreTimeout = 3 * time.Second
+
+var (
+	errSessionActive = errors.New("session already active")
+	errNotListening  = errors.New("not listening")
+	errNoSession     = errors.New("no active session")
+	errNotSpeaking   = errors.New("not speaking")
+)
+
+// sessionReport is what a single capture session ended up doing; the most
+// recent one is served by the "last" command.
+type sessionReport struct {
+	ID         int                 `json:"id"`
+	Started    time.Time           `json:"started"`
+	Finished   time.Time           `json:"finished,omitzero"`
+	Transcript string              `json:"transcript,omitempty"`
+	Language   string              `json:"language,omitempty"`
+	Intent     string              `json:"intent,omitempty"`
+	Entities   map[string]string   `json:"entities,omitempty"`
+	Wake       *wake.Match         `json:"wake,omitempty"`
+	Reply      string              `json:"reply,omitempty"`
+	Answer     string              `json:"answer,omitempty"`
+	Question   string              `json:"question,omitempty"`
+	FollowUp   bool                `json:"follow_up,omitempty"`
+	VAD        []audio.VADDecision `json:"vad,omitempty"`
+	Canceled   bool                `json:"canceled,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+type daemon struct {
+	// swap guards the reloadable parts below; sessions hold it for reading
+	// so that a reload lands between them.
+	swap    sync.RWMutex
+	cfg     config.Config
+	tr      *stt.Transcriber // local model, nil with stt.backend = openai
+	backend stt.Backend
+	api     openai.Client
+	ptcl    *protocol.Protocol
+	reg     *nlu.Registry
+	tts     tts.Engine
+
+	rec    *audio.Recorder
+	player *audio.Player
+	apiKey string
+
+	load     func() (config.Config, error)
+	reloadMu sync.Mutex
+
+	wakeMu    sync.Mutex
+	wake      *wakeListener
+	wakeTr    *stt.Transcriber
+	wakeModel string
+
+	events *ipc.Broker
+	booted time.Time
+	dialog *dialogue.Manager
+
+	mu      sync.Mutex
+	state   sessionState
+	session int
+	stop    chan struct{}
+	cancel  context.CancelFunc
+	last    *sessionReport
+	hub     protocol.ConnState
+	barge   bool // start listening once the session ends
+
+	// ducking is shared by sessions and speech: the first to come ducks,
+	// the last to go restores
+	duckMu  sync.Mutex
+	ducks   int
+	ducker  *audio.Ducker
+	closing bool // shut down, nothing is ducked any more
+}
+
+func newDaemon(cfg config.Config, load func() (config.Config, error), apiKey string, rec *audio.Recorder, tr *stt.Transcriber, backend stt.Backend, api openai.Client, ptcl *protocol.Protocol, reg *nlu.Registry, speech tts.Engine) *daemon {
+	return &daemon{
+		cfg:     cfg,
+		load:    load,
+		apiKey:  apiKey,
+		rec:     rec,
+		player:  audio.NewPlayer(),
+		tr:      tr,
+		backend: backend,
+		api:     api,
+		ptcl:    ptcl,
+		reg:     reg,
+		tts:     speech,
+		events:  ipc.NewBroker(),
+		booted:  time.Now(),
+		dialog:  dialogue.New(dialogueConfig(cfg)),
+		state:   stateIdle,
+	}
+}
+
+// sessionSource is where a session's audio comes from. Without a capture
+// the microphone is opened when recording starts. A follow-up session
+// records the answer to a question VOX just asked.
+type sessionSource struct {
+	capture  *audio.Capture
+	wake     *wake.Match
+	followUp bool
+}
+
+func dialogueConfig(cfg config.Config) dialogue.Config {
+	return dialogue.Config{
+		Turns:        cfg.Dialogue.Turns,
+		TTL:          cfg.Dialogue.TTL.Duration,
+		MaxQuestions: cfg.Dialogue.MaxQuestions,
+	}
+}
+
+func (d *daemon) currentState() sessionState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// toggle starts or stops listening. Pressed while VOX is talking, it
+// cuts the speech off and listens right away, once the session that
+// spoke is done.
+func (d *daemon) toggle() (sessionState, error) {
+	state := d.currentState()
+
+	if d.player.Hush() {
+		log.Info("Barge-in, speech stopped")
+		switch state {
+		case stateListening:
+			return state, nil
+		case stateProcessing:
+			d.mu.Lock()
+			d.barge = true
+			d.mu.Unlock()
+			return stateListening, nil
+		}
+	}
+
+	switch state {
+	case stateIdle:
+		return stateListening, d.startSession(sessionSource{})
+	case stateListening:
+		return stateProcessing, d.stopListening()
+	default:
+		return state, nil
+	}
+}
+
+func (d *daemon) startSession(src sessionSource) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != stateIdle {
+		return errSessionActive
+	}
+
+	log.Info("Warming up records")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d.session++
+	d.state = stateListening
+	d.stop = make(chan struct{})
+	d.cancel = cancel
+
+	rep := &sessionReport{
+		ID:       d.session,
+		Started:  time.Now(),
+		Wake:     src.wake,
+		FollowUp: src.followUp,
+	}
+
+	go func(stop <-chan struct{}) {
+		var followUp bool
+		defer func() {
+			cancel()
+			rep.Finished = time.Now()
+
+			d.mu.Lock()
+			d.state = stateIdle
+			d.stop = nil
+			d.cancel = nil
+			d.last = rep
+			barge := d.barge
+			d.barge = false
+			d.mu.Unlock()
+
+			d.emit(ipc.EventSessionFinished, rep, rep)
+
+			log.Info("Listening finished")
+
+			if rep.Canceled {
+				d.dialog.Forget()
+			}
+			switch {
+			case followUp:
+				log.Info("Listening for the answer")
+				if err := d.startSession(sessionSource{followUp: true}); err != nil {
+					log.Warn("Failed to start follow-up session", "err", err)
+				}
+			case barge:
+				log.Info("Listening after barge-in")
+				if err := d.startSession(sessionSource{}); err != nil {
+					log.Warn("Failed to start session", "err", err)
+				}
+			}
+		}()
+
+		d.swap.RLock()
+		defer d.swap.RUnlock()
+
+		d.handleSession(ctx, stop, src, rep)
+		followUp = rep.Question != "" && !rep.Canceled && d.cfg.Dialogue.FollowUp
+	}(d.stop)
+
+	return nil
+}
+
+func (d *daemon) stopListening() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != stateListening || d.stop == nil {
+		return errNotListening
+	}
+
+	log.Info("Stopping listening by trigger")
+	close(d.stop)
+	d.stop = nil
+
+	return nil
+}
+
+func (d *daemon) cancelSession() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state == stateIdle {
+		return errNoSession
+	}
+
+	log.Info("Cancelling session", "session", d.session)
+	if d.cancel != nil {
+		d.cancel()
+	}
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+
+	return nil
+}
+
+func (d *daemon) setState(s sessionState) {
+	d.mu.Lock()
+	d.state = s
+	d.mu.Unlock()
+}
+
+func (d *daemon) emit(kind string, rep *sessionReport, data any) {
+	d.events.Publish(ipc.Event{
+		Type:    kind,
+		Session: rep.ID,
+		Data:    data,
+	})
+}
+
+func (d *daemon) fail(rep *sessionReport, stage string, err error) {
+	rep.Error = err.Error()
+	d.emit(ipc.EventError, rep, map[string]string{
+		"stage": stage,
+		"error": err.Error(),
+	})
+}
+
+// record captures one utterance, ending on the VAD when it is enabled and
+// otherwise only on a stop trigger or the length cap.
+func (d *daemon) record(stop <-chan struct{}, src sessionSource, sink audio.Sink, rep *sessionReport) ([]float32, error) {
+	cfg := d.cfg
+
+	c := src.capture
+	if c == nil {
+		var err error
+		c, err = d.rec.Capture(frameSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer c.Close()
+
+	if sink != nil {
+		c.SetSink(sink)
+	}
+
+	if !cfg.VAD.Enabled {
+		return c.RecordUntil(stop, cfg.Record.MaxDuration.Duration)
+	}
+
+	vad := audio.NewVAD(audio.VADConfig{
+		SampleRate:      audio.SampleRate,
+		FrameSize:       frameSize,
+		ThresholdRatio:  cfg.VAD.ThresholdRatio,
+		MinThreshold:    cfg.VAD.MinThreshold,
+		ReleaseRatio:    cfg.VAD.ReleaseRatio,
+		FloorAdapt:      cfg.VAD.FloorAdapt,
+		Hangover:        cfg.VAD.Hangover.Duration,
+		PreRoll:         cfg.VAD.PreRoll.Duration,
+		MinSpeech:       cfg.VAD.MinSpeech.Duration,
+		NoSpeechTimeout: cfg.VAD.NoSpeechTimeout.Duration,
+	})
+
+	pcm, err := c.RecordVAD(stop, cfg.Record.MaxDuration.Duration, vad)
+
+	rep.VAD = vad.Timeline()
+	for _, dec := range rep.VAD {
+		log.Debug("VAD", "at", dec.At, "event", dec.Event, "rms", dec.RMS, "floor", dec.Floor, "threshold", dec.Threshold)
+	}
+
+	return pcm, err
+}
+
+// transcribe finishes a streaming run, or hands the whole recording to the
+// configured backend. A failed stream gets the same fallback as a plain
+// local run.
+func (d *daemon) transcribe(ctx context.Context, stream *stt.Stream, pcm []float32, opts stt.Options) (stt.Result, error) {
+	if stream == nil {
+		return d.backend.TranscribePCM(ctx, pcm, opts)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, d.cfg.STT.Timeout.Duration)
+	defer cancel()
+
+	res, err := stream.Finish(tctx, pcm)
+	if err == nil {
+		return res, nil
+	}
+	if tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = fmt.Errorf("timed out after %s: %w", d.cfg.STT.Timeout, err)
+	}
+	if fb, ok := d.backend.(*stt.Fallback); ok {
+		return fb.Recover(ctx, pcm, opts, err)
+	}
+	return res, err
+}
+
+// newDucker builds the ducker for cfg; all of them share the state file.
+func newDucker(cfg config.Config) *audio.Ducker {
+	// validated with the config
+	rules, _ := config.DuckRules(cfg.Duck.Rules)
+	duckRules := make([]audio.DuckRule, len(rules))
+	for i, r := range rules {
+		duckRules[i] = audio.DuckRule(r)
+	}
+
+	return audio.NewDucker(audio.DuckerConfig{
+		Self:      cfg.Duck.Self,
+		MinVolume: cfg.Duck.MinVolume,
+		Backend:   cfg.Duck.Backend,
+		StatePath: cfg.Duck.State,
+		Rules:     duckRules,
+	})
+}
+
+// duck lowers other audio unless it is lowered already. Callers hold swap
+// and pair it with unduck.
+func (d *daemon) duck() {
+	d.duckMu.Lock()
+	defer d.duckMu.Unlock()
+
+	d.ducks++
+	if d.ducks > 1 || d.closing {
+		return
+	}
+
+	cfg := d.cfg
+	d.ducker = newDucker(cfg)
+	if err := d.ducker.DuckOthers(context.Background(), cfg.Duck.Factor, cfg.Duck.Fade.Duration); err != nil {
+		log.Error("Failed to duck outputs", "err", err)
+	}
+	log.Debug("Ducked audio")
+}
+
+func (d *daemon) unduck() {
+	d.duckMu.Lock()
+	defer d.duckMu.Unlock()
+
+	d.ducks--
+	if d.ducks > 0 || d.ducker == nil {
+		return
+	}
+
+	if err := d.ducker.UnduckOthers(context.Background(), d.cfg.Duck.Fade.Duration); err != nil {
+		log.Error("Failed to unduck outputs", "err", err)
+	}
+	d.ducker = nil
+	log.Debug("Unducked audio")
+}
+
+// shutdown cancels the session and puts ducked audio back right away,
+// whoever holds it, before the process exits.
+func (d *daemon) shutdown() {
+	d.cancelSession()
+	d.player.Close()
+
+	d.swap.RLock()
+	cfg := d.cfg
+	d.swap.RUnlock()
+
+	d.duckMu.Lock()
+	defer d.duckMu.Unlock()
+
+	d.closing = true
+	ducker := d.ducker
+	if ducker == nil {
+		ducker = newDucker(cfg)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+	defer cancel()
+	if err := ducker.Restore(ctx); err != nil {
+		log.Error("Failed to restore ducked audio", "err", err)
+	}
+	d.ducker = nil
+}
+
+func (d *daemon) handleSession(ctx context.Context, stop <-chan struct{}, src sessionSource, rep *sessionReport) {
+	cfg := d.cfg
+
+	d.duck()
+	// whichever way the session ends
+	unduck := sync.OnceFunc(d.unduck)
+	defer unduck()
+
+	// a wake session is already capturing, the beep would end up in it
+	if src.capture == nil {
+		notify.Beep()
+	}
+	notify.SwayNotify("Listening...")
+	log.Debug("Sent notification")
+
+	log.Info("Starting listening")
+	d.emit(ipc.EventSessionStarted, rep, nil)
+
+	opts := stt.Options{
+		Language: cfg.STT.Language,
+		Threads:  cfg.STT.Threads,
+	}
+
+	// with streaming, whisper decodes while we record and only the last
+	// bit is left once the utterance ends
+	var (
+		stream *stt.Stream
+		sink   audio.Sink
+	)
+	if cfg.STT.Streaming && d.tr != nil {
+		stream = d.tr.NewStream(ctx, stt.StreamOptions{
+			Options:  opts,
+			Interval: cfg.STT.StreamInterval.Duration,
+			OnPartial: func(p stt.Partial) {
+				d.emit(ipc.EventPartialTranscript, rep, p)
+			},
+		})
+		defer stream.Close()
+		sink = stream
+	}
+
+	pcm, err := d.record(stop, src, sink, rep)
+	if err != nil {
+		log.Error("record failed", "err", err)
+		d.fail(rep, "record", err)
+		return
+	}
+	log.Debug("Recored audio", "samples", len(pcm))
+	d.emit(ipc.EventRecordingStopped, rep, map[string]any{
+		"samples":  len(pcm),
+		"duration": (time.Duration(len(pcm)) * time.Second / audio.SampleRate).String(),
+	})
+
+	d.setState(stateProcessing)
+
+	unduck()
+
+	if ctx.Err() != nil {
+		log.Info("Session cancelled")
+		rep.Canceled = true
+		return
+	}
+
+	log.Debug("Start transcripting")
+	res, err := d.transcribe(ctx, stream, pcm, opts)
+	if err != nil {
+		log.Error("whisper transcribe failed", "err", err)
+		d.fail(rep, "transcribe", err)
+		rep.Canceled = ctx.Err() != nil
+		return
+	}
+
+	rep.Transcript = res.Text
+	rep.Language = res.Language
+	d.emit(ipc.EventTranscript, rep, map[string]string{
+		"text":     res.Text,
+		"language": res.Language,
+	})
+
+	log.Info("Transcribed", "text", res.Text, "lang", res.Language)
+	log.Debug("Starting analyzing")
+
+	ncfg := nlu.Config{
+		Model:         cfg.NLU.Model,
+		Mode:          cfg.NLU.Mode,
+		MinConfidence: cfg.NLU.MinConfidence,
+		Registry:      d.reg,
+	}
+	if cfg.Dialogue.Enabled {
+		ncfg.History, ncfg.LastDevice = d.dialog.Context(time.Now())
+	}
+
+	out, err := nlu.Analyze(ctx, d.api, ncfg, res.Text)
+	if err != nil {
+		log.Error("nlu failed", "err", err)
+		d.fail(rep, "nlu", err)
+		rep.Canceled = ctx.Err() != nil
+		return
+	}
+
+	if cfg.Dialogue.Enabled {
+		out = d.dialog.Resolve(out, d.reg, time.Now())
+	}
+
+	rep.Intent = out.Intent
+	rep.Entities = out.Entities
+	d.emit(ipc.EventNLUResult, rep, out)
+
+	log.Info("──────── VOX ────────")
+	log.Info("intent: ", "i", out.Intent)
+	log.Info("entities:   ", "e", out.Entities)
+	log.Info("──────────────────────")
+
+	if ctx.Err() != nil {
+		log.Info("Session cancelled")
+		rep.Canceled = true
+		return
+	}
+
+	resp, err := nlu.Dispatch(ctx, out, d.reg, d.ptcl)
+	if q := d.clarify(out, err, res.Language); q != "" {
+		rep.Question = q
+		d.emit(ipc.EventQuestion, rep, map[string]string{"question": q})
+		log.Info("Asking back", "question", q, "err", err)
+
+		if err := d.speak(ctx, q, res.Language); err != nil {
+			log.Error("Failed to voice out", "err", err)
+		}
+		return
+	}
+	if err != nil {
+		log.Error("Failed to dispatch", "err", err)
+		d.fail(rep, "dispatch", err)
+	}
+	rep.Reply = resp
+	rep.Answer = answer.Compose(ctx, d.api, answer.Config{
+		Mode:     cfg.Answer.Mode,
+		Model:    cfg.Answer.Model,
+		Timeout:  cfg.Answer.Timeout.Duration,
+		Registry: d.reg,
+	}, answer.Outcome{
+		Command: out,
+		Reply:   resp,
+		Err:     err,
+		Lang:    res.Language,
+	})
+	d.emit(ipc.EventDispatchResult, rep, map[string]string{
+		"reply":  resp,
+		"error":  rep.Error,
+		"answer": rep.Answer,
+	})
+	log.Debug("Dispatched request", "resp", resp, "answer", rep.Answer)
+
+	if rep.Answer != "" && ctx.Err() == nil {
+		log.Debug("Speaking out")
+		if err := d.speak(ctx, rep.Answer, res.Language); err != nil {
+			log.Error("Failed to voice out", "err", err)
+		}
+	}
+
+	log.Debug("Request handled")
+}
+
+// clarify decides whether the outcome of a command calls for a question:
+// it was not understood, or an entity its action needs is missing. It
+// returns the question, or "" after recording the turn as done.
+func (d *daemon) clarify(out nlu.Result, err error, lang string) string {
+	if !d.cfg.Dialogue.Enabled {
+		return ""
+	}
+	now := time.Now()
+
+	var (
+		entity string
+		ee     *nlu.EntityError
+	)
+	switch {
+	case out.Intent == "unknown" && strings.TrimSpace(out.Query) != "":
+	case errors.As(err, &ee) && errors.Is(err, nlu.ErrMissingEntity):
+		entity = ee.Entity
+	default:
+		d.dialog.Done(out, now)
+		return ""
+	}
+
+	q, ok := d.dialog.Ask(out, entity, lang, now)
+	if !ok {
+		log.Info("Giving up asking back")
+		return ""
+	}
+	return q
+}
+
+// speak says text with other audio ducked, in the voice for lang ("" for
+// the default one), after whatever is being said already. Speech cut off
+// by ctx or a hush is not an error. Callers hold swap.
+func (d *daemon) speak(ctx context.Context, text, lang string) error {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	log.Debug("Speaking out", "text", text, "lang", lang)
+	sp, err := d.tts.Synthesize(ctx, text, lang)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer sp.Close()
+
+	d.duck()
+	defer d.unduck()
+
+	err = d.player.Play(ctx, sp, sp.Rate)
+	if errors.Is(err, audio.ErrHushed) || ctx.Err() != nil {
+		log.Info("Speech cut off", "text", text)
+		return nil
+	}
+	return err
+}
+
+// hush stops whatever VOX is saying.
+func (d *daemon) hush() error {
+	if !d.player.Hush() {
+		return errNotSpeaking
+	}
+	log.Info("Speech hushed")
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "log/slog"
+
+	"vox/internal/ipc"
+	"vox/pkg/protocol"
+)
+
+// serveHub starts reading ptcl, handing commands other shards address to
+// VOX over to handleHub and publishing connection changes.
+func (d *daemon) serveHub(ptcl *protocol.Protocol) {
+	d.setHubState(ptcl.State(), nil)
+	ptcl.OnState(d.setHubState)
+	ptcl.EmitOut(func(msg *protocol.Message) {
+		// the reader also delivers replies to our own requests, so it must
+		// not wait for a command to finish
+		go d.handleHub(ptcl, msg)
+	})
+	go ptcl.Run()
+}
+
+// handleHub runs one hub command and answers the sender through ptcl with
+// OK or ERR:
+//
+//	VOX:SAY:TEXT:<word>...:FROM   speak the words, '_' for spaces
+//	VOX:SAY:B64:<text>:FROM       speak base64url (unpadded) UTF-8 text
+//	VOX:LISTEN:START|STOP|CANCEL:FROM
+//	VOX:STATUS:GET:FROM           OK:STATUS:<state>:<wake ON|OFF>
+//
+// The reply's noun is the command verb for OK, the reason (UNKNOWN, ARGS,
+// BUSY, IDLE, FAILED) followed by the verb for ERR.
+func (d *daemon) handleHub(ptcl *protocol.Protocol, msg *protocol.Message) {
+	if msg.Verb == "OK" || msg.Verb == "ERR" {
+		// a reply that came too late; answering it could ping-pong forever
+		log.Debug("Dropping uncorrelated reply", "msg", msg.String())
+		return
+	}
+	log.Debug("Hub command", "msg", msg.String())
+
+	reply := protocol.Message{To: msg.From}
+	args, err := d.hubCommand(msg)
+	if msg.To == "ALL" && errors.Is(err, errHubUnknown) {
+		// a broadcast for other shards
+		return
+	}
+	if err != nil {
+		log.Warn("Hub command failed", "msg", msg.String(), "err", err)
+		reply.Error(hubReason(err), msg.Verb)
+	} else {
+		reply.Ok(msg.Verb, args...)
+	}
+
+	d.events.Publish(ipc.Event{
+		Type: ipc.EventHubCommand,
+		Data: map[string]string{
+			"command": msg.String(),
+			"reply":   reply.String(),
+		},
+	})
+
+	if err := ptcl.Transmit(reply); err != nil {
+		log.Error("Failed to answer hub command", "msg", msg.String(), "err", err)
+	}
+}
+
+var (
+	errHubUnknown = errors.New("unknown command")
+	errHubArgs    = errors.New("bad arguments")
+)
+
+func (d *daemon) hubCommand(msg *protocol.Message) ([]string, error) {
+	switch msg.Verb {
+	case "SAY":
+		text, err := hubText(msg)
+		if err != nil {
+			return nil, err
+		}
+		return nil, d.say(text)
+
+	case "LISTEN":
+		var err error
+		switch msg.Noun {
+		case "START":
+			err = d.startSession(sessionSource{})
+		case "STOP":
+			err = d.stopListening()
+		case "CANCEL":
+			err = d.cancelSession()
+		default:
+			return nil, fmt.Errorf("%w: LISTEN %s", errHubArgs, msg.Noun)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []string{msg.Noun}, nil
+
+	case "STATUS":
+		if msg.Noun != "GET" {
+			return nil, fmt.Errorf("%w: STATUS %s", errHubArgs, msg.Noun)
+		}
+		rep := d.status(d.currentState())
+		wake := "OFF"
+		if rep.Wake {
+			wake = "ON"
+		}
+		return []string{strings.ToUpper(string(rep.State)), wake}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", errHubUnknown, msg.Verb)
+}
+
+// hubText reads the text of a SAY command; frames are single tokens, so
+// anything beyond [A-Za-z0-9_.-] has to come base64 encoded.
+func hubText(msg *protocol.Message) (string, error) {
+	var text string
+	switch msg.Noun {
+	case "TEXT":
+		text = strings.ReplaceAll(strings.Join(msg.Args, " "), "_", " ")
+	case "B64":
+		if len(msg.Args) != 1 {
+			return "", fmt.Errorf("%w: SAY B64 takes one arg", errHubArgs)
+		}
+		b, err := base64.RawURLEncoding.DecodeString(msg.Args[0])
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errHubArgs, err)
+		}
+		text = string(b)
+	default:
+		return "", fmt.Errorf("%w: SAY %s", errHubArgs, msg.Noun)
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("%w: empty text", errHubArgs)
+	}
+	return text, nil
+}
+
+// hubReason is the ERR reason token for err.
+func hubReason(err error) string {
+	switch {
+	case errors.Is(err, errHubUnknown):
+		return "UNKNOWN"
+	case errors.Is(err, errHubArgs):
+		return "ARGS"
+	case errors.Is(err, errSessionActive):
+		return "BUSY"
+	case errors.Is(err, errNotListening), errors.Is(err, errNoSession):
+		return "IDLE"
+	}
+	return "FAILED"
+}
+
+func (d *daemon) setHubState(state protocol.ConnState, err error) {
+	d.mu.Lock()
+	changed := d.hub != state
+	d.hub = state
+	d.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	data := map[string]string{"state": string(state)}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	d.events.Publish(ipc.Event{
+		Type: ipc.EventHubState,
+		Data: data,
+	})
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
-	"sync"
-	"time"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/joho/godotenv"
 	cli "github.com/spf13/pflag"
@@ -12,16 +16,9 @@ import (
 	"github.com/lmittmann/tint"
 	log "log/slog"
 
-	openai "github.com/openai/openai-go/v3"
-	"github.com/openai/openai-go/v3/option"
-
 	"vox/internal/audio"
+	"vox/internal/config"
 	"vox/internal/ipc"
-	"vox/internal/nlu"
-	"vox/internal/notify"
-	"vox/internal/proxy"
-	_ "vox/internal/tts"
-	"vox/pkg/protocol"
 	"vox/pkg/stt"
 )
 
@@ -32,27 +29,47 @@ var logLevelMap = map[string]log.Level{
 	"error": log.LevelError,
 }
 
-var (
-	sttMu     sync.Mutex
-	recMu     sync.Mutex
-	recording bool
-	stopChan  chan struct{}
-)
+// logLevel is shared with the handler so a reload can change it in place.
+var logLevel = new(log.LevelVar)
+
+const defaultConfigPath = "vox.toml"
+
+// flagKeys maps shorthand flags onto the config keys they override.
+var flagKeys = map[string]string{
+	"url":   "hub.url",
+	"proxy": "proxy.addr",
+	"log":   "log.level",
+}
 
 func main() {
 	envFile := cli.StringP("env", "e", ".env", "Env file path")
-	url := cli.StringP("url", "u", "ws://192.168.0.69:8092", "Url of hub")
-	proxy_addr := cli.StringP("proxy", "p", "127.0.0.1:8888", "Socks Proxy Address")
-	logLevel := cli.StringP("log", "l", "info", "Log level")
+	cfgPath := cli.StringP("config", "c", defaultConfigPath, "Config file path (.toml, .json or .yaml)")
+	cli.StringP("url", "u", "", "Url of hub (hub.url)")
+	cli.StringP("proxy", "p", "", "Socks Proxy Address (proxy.addr)")
+	cli.StringP("log", "l", "", "Log level (log.level)")
+	cli.StringArrayP("set", "s", nil, "Override a config key, e.g. --set duck.factor=0.5")
 	cli.Parse()
 
+	// env file first, so VOX_* overrides may live there too
+	godotenv.Load(*envFile)
+
+	load := func() (config.Config, error) {
+		return loadConfig(*cfgPath, cli.CommandLine.Changed("config"))
+	}
+
+	cfg, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(2)
+	}
+
+	logLevel.Set(logLevelMap[cfg.Log.Level])
 	log.SetDefault(log.New(tint.NewHandler(os.Stdout, &tint.Options{
-		Level: logLevelMap[*logLevel],
+		Level: logLevel,
 	})))
 
-	log.Info("Booting up")
+	log.Info("Booting up", "config", *cfgPath)
 
-	godotenv.Load(*envFile)
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
 		log.Error("OPENAI_API_KEY not set")
@@ -61,19 +78,21 @@ func main() {
 
 	log.Debug("Loaded API Key")
 
-	httpClient, err := proxy.NewSocksClient(*proxy_addr)
+	// audio a previous run left ducked when it crashed
+	rctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+	if err := newDucker(cfg).Restore(rctx); err != nil {
+		log.Warn("Failed to restore ducked audio", "err", err)
+	}
+	cancel()
+
+	client, err := newOpenAI(cfg, apiKey)
 	if err != nil {
-		log.Error("Failed to dial socks proxy", "proxy", *proxy_addr, "err", err)
+		log.Error("Failed to dial socks proxy", "proxy", cfg.Proxy.Addr, "err", err)
 		os.Exit(1)
 	}
 
 	log.Debug("Loaded proxy")
 
-	client := openai.NewClient(
-		option.WithAPIKey(apiKey),
-		option.WithHTTPClient(httpClient),
-	)
-
 	rec := audio.NewRecorder()
 	err = rec.Init()
 	if err != nil {
@@ -84,159 +103,117 @@ func main() {
 
 	log.Debug("Loaded recorder")
 
-	whisper, err := stt.NewTranscriber("third_party/whisper.cpp/models/ggml-medium.bin")
-	if err != nil {
-		log.Error("Failed to ini whisper", "err", err)
-		os.Exit(1)
-	}
-	defer whisper.Close()
+	var whisper *stt.Transcriber
+	if cfg.STT.Backend == "local" {
+		whisper, err = stt.NewTranscriber(cfg.STT.Model)
+		if err != nil {
+			log.Error("Failed to ini whisper", "err", err)
+			os.Exit(1)
+		}
 
-	log.Debug("Loaded whisper")
+		log.Debug("Loaded whisper")
+	}
 
-	ptcl_cfg := protocol.PtclConfig{
-		Shard:   "VOX",
-		Url:     *url,
-		Reconn:  5,
-		Timeout: 3 * time.Second,
+	backend, err := newSTT(cfg, apiKey, whisper)
+	if err != nil {
+		log.Error("Failed to init transcription", "backend", cfg.STT.Backend, "err", err)
+		os.Exit(1)
 	}
 
-	ptcl, err := protocol.NewProtocol(ptcl_cfg)
+	ptcl, err := dialHub(cfg)
 	if err != nil {
 		log.Error("Failed to init protocol", "err", err)
 		os.Exit(1)
 	}
-	go ptcl.Run()
 
 	log.Debug("Loaded protocol")
 
-	log.Info("Boot up - successful")
-
-	if err := ipc.StartServer(func(msg ipc.ControlMessage) {
-		switch msg.Cmd {
-		case "trigger":
-			handleToggleTrigger(rec, whisper, client, ptcl)
-		default:
-			log.Warn("Unknown command", "cmd", msg.Cmd)
-		}
-	}); err != nil {
-		log.Error("Failed ipc server", "err", err)
+	reg, err := loadRegistry(cfg)
+	if err != nil {
+		log.Error("Failed to load device registry", "path", cfg.NLU.Devices, "err", err)
 		os.Exit(1)
 	}
 
-	select {}
-}
-
-func handleToggleTrigger(rec *audio.Recorder, tr *stt.Transcriber, api openai.Client, ptcl *protocol.Protocol) {
-	recMu.Lock()
-	defer recMu.Unlock()
-
-	if !recording {
-		log.Info("Warming up records")
-
-		recording = true
-		stopChan = make(chan struct{})
-
-		go func(stop <-chan struct{}) {
-			defer func() {
-				recMu.Lock()
-				recording = false
-				stopChan = nil
-				recMu.Unlock()
-
-				log.Info("Listening finished")
-			}()
+	speech, err := newTTS(cfg, apiKey)
+	if err != nil {
+		log.Error("Failed to init speech", "backend", cfg.TTS.Backend, "err", err)
+		os.Exit(1)
+	}
 
-			handleSession(stop, rec, tr, api, ptcl)
-		}(stopChan)
+	d := newDaemon(cfg, load, apiKey, rec, whisper, backend, client, ptcl, reg, speech)
+	d.serveHub(ptcl)
 
-	} else {
-		if stopChan != nil {
-			log.Info("Stopping listening by trigger")
-			close(stopChan)
-			stopChan = nil
+	if cfg.Wake.Enabled {
+		if err := d.setWake(true); err != nil {
+			log.Error("Failed to start wake listening", "err", err)
 		}
 	}
-}
 
-func handleSession(stop <-chan struct{}, rec *audio.Recorder, tr *stt.Transcriber, api openai.Client, ptcl *protocol.Protocol) {
-	ctx_bg := context.Background()
+	log.Info("Boot up - successful")
 
-	d := audio.NewDucker([]string{"MonolithVox"}, 5)
-	err := d.DuckOthers(ctx_bg, 0.3, 400*time.Millisecond)
-	if err != nil {
-		log.Error("Failed to duck outputs", "err", err)
+	if err := ipc.StartServer(d.handleControl, d.events); err != nil {
+		log.Error("Failed ipc server", "err", err)
+		os.Exit(1)
 	}
-	log.Debug("Ducked audio")
 
-	notify.Beep()
-	notify.SwayNotify("Listening...")
-	log.Debug("Sent notification")
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 
-	log.Info("Starting listening")
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			log.Info("SIGHUP - reloading config")
+			if _, err := d.reload(); err != nil {
+				log.Error("Failed to reload config", "err", err)
+			}
+			continue
+		}
 
-	pcm, err := rec.RecordUntil(stop, 20*time.Second)
-	if err != nil {
-		log.Error("record failed", "err", err)
+		log.Info("Shutting down", "signal", sig)
+		d.shutdown()
 		return
 	}
-	log.Debug("Recored audio", "samples", len(pcm))
-
-	err = d.UnduckOthers(ctx_bg, 400*time.Millisecond)
-	if err != nil {
-		log.Error("Failed to unduck outputs", "err", err)
-	}
-	log.Debug("Unducked audio")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+}
 
-	log.Debug("Start transcripting")
-	res, err := tr.TranscribePCM(ctx, pcm, stt.Options{
-		Language: "auto",
-		Threads:  0,
-	})
+// loadConfig layers defaults, the config file, VOX_* environment variables
+// and command line flags, in that order. A missing file is only an error
+// when its path was given explicitly.
+func loadConfig(path string, explicit bool) (config.Config, error) {
+	cfg, err := config.Load(path)
 	if err != nil {
-		log.Error("whisper transcribe failed", "err", err)
-		return
+		if !errors.Is(err, fs.ErrNotExist) || explicit {
+			return cfg, err
+		}
+		cfg = config.Default()
 	}
 
-	log.Info("Transcribed", "text", res.Text, "lang", res.Language)
-	log.Debug("Starting analyzing")
-
-	out, err := nlu.Analyze(api, res.Text)
-	if err != nil {
-		log.Error("nlu failed", "err", err)
-		return
+	if err := cfg.ApplyEnv(); err != nil {
+		return cfg, err
 	}
 
-	log.Info("──────── VOX ────────")
-	log.Info("intent: ", "i", out.Intent)
-	log.Info("entities:   ", "e", out.Entities)
-	log.Info("──────────────────────")
-
-	resp, err := nlu.Dispatch(out, ptcl)
-	if err != nil {
-		log.Error("Failed to dispatch", "err", err)
+	for flag, key := range flagKeys {
+		if !cli.CommandLine.Changed(flag) {
+			continue
+		}
+		value, _ := cli.CommandLine.GetString(flag)
+		if err := cfg.Set(key, value); err != nil {
+			return cfg, fmt.Errorf("--%s: %w", flag, err)
+		}
 	}
-	log.Debug("Dispatched request", "resp", resp)
 
-	err = d.DuckOthers(ctx_bg, 0.3, 400*time.Millisecond)
-	if err != nil {
-		log.Error("Failed to duck outputs", "err", err)
+	sets, _ := cli.CommandLine.GetStringArray("set")
+	for _, kv := range sets {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return cfg, fmt.Errorf("--set %q: want key=value", kv)
+		}
+		if err := cfg.Set(strings.TrimSpace(key), value); err != nil {
+			return cfg, fmt.Errorf("--set: %w", err)
+		}
 	}
-	log.Debug("Ducked audio")
-
-	log.Debug("Speaking out")
-	// err = tts.Speak(out.Answer)
-	// if err != nil {
-	// 	log.Error("Failed to voice out", "err", err)
-	// }
 
-	err = d.UnduckOthers(ctx_bg, 400*time.Millisecond)
-	if err != nil {
-		log.Error("Failed to unduck outputs", "err", err)
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
 	}
-	log.Debug("Unducked audio")
 
-	log.Debug("Request handled")
+	return cfg, nil
 }
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	log "log/slog"
+
+	openai "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+
+	"vox/internal/config"
+	"vox/internal/ipc"
+	"vox/internal/nlu"
+	"vox/internal/proxy"
+	"vox/internal/tts"
+	"vox/pkg/protocol"
+	"vox/pkg/stt"
+)
+
+type reloadReport struct {
+	Changed   []string `json:"changed"`
+	Restarted []string `json:"restarted,omitempty"`
+}
+
+func dialHub(cfg config.Config) (*protocol.Protocol, error) {
+	addrs, err := cfg.Hub.AddressMap()
+	if err != nil {
+		return nil, err
+	}
+
+	ptcl, err := protocol.NewProtocol(protocol.PtclConfig{
+		Shard:     cfg.Hub.Shard,
+		Url:       cfg.Hub.Url,
+		Reconn:    cfg.Hub.Reconn.Duration,
+		ReconnMax: cfg.Hub.ReconnMax.Duration,
+		Ping:      cfg.Hub.Ping.Duration,
+		Timeout:   cfg.Hub.Timeout.Duration,
+		Queue:     cfg.Hub.Queue,
+
+		Sequence:     cfg.Hub.Sequence,
+		Addresses:    addrs,
+		HexAddresses: cfg.Hub.HexAddresses,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ptcl, nil
+}
+
+func newOpenAI(cfg config.Config, apiKey string, opts ...option.RequestOption) (openai.Client, error) {
+	httpClient, err := proxy.NewSocksClient(cfg.Proxy.Addr)
+	if err != nil {
+		return openai.Client{}, err
+	}
+
+	return openai.NewClient(append([]option.RequestOption{
+		option.WithAPIKey(apiKey),
+		option.WithHTTPClient(httpClient),
+	}, opts...)...), nil
+}
+
+// newSTT puts the configured transcription backend together around the
+// local model, which is nil with stt.backend = openai.
+func newSTT(cfg config.Config, apiKey string, local *stt.Transcriber) (stt.Backend, error) {
+	var remote stt.Backend
+	if cfg.STT.Backend == "openai" || cfg.STT.Fallback {
+		client, err := newOpenAI(cfg, apiKey, option.WithBaseURL(cfg.STT.OpenAI.Url))
+		if err != nil {
+			return nil, err
+		}
+		remote, err = stt.NewOpenAI(client, stt.OpenAIConfig{
+			Model:   cfg.STT.OpenAI.Model,
+			Timeout: cfg.STT.OpenAI.Timeout.Duration,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if local == nil {
+		return remote, nil
+	}
+
+	return &stt.Fallback{
+		Primary:   local,
+		Secondary: remote,
+		Timeout:   cfg.STT.Timeout.Duration,
+		OnFallback: func(err error) {
+			log.Warn("Local transcription failed, falling back", "url", cfg.STT.OpenAI.Url, "err", err)
+		},
+	}, nil
+}
+
+// newTTS builds the configured speech backend.
+func newTTS(cfg config.Config, apiKey string) (tts.Engine, error) {
+	switch cfg.TTS.Backend {
+	case "piper":
+		voices, err := ttsVoices(cfg.TTS.Piper.Voice, cfg.TTS.Piper.Voices)
+		if err != nil {
+			return nil, err
+		}
+		return tts.NewPiper(tts.PiperConfig{
+			Binary: cfg.TTS.Piper.Binary,
+			Voices: voices,
+		})
+
+	case "openai":
+		voices, err := ttsVoices(cfg.TTS.OpenAI.Voice, cfg.TTS.OpenAI.Voices)
+		if err != nil {
+			return nil, err
+		}
+		client, err := newOpenAI(cfg, apiKey, option.WithBaseURL(cfg.TTS.OpenAI.Url))
+		if err != nil {
+			return nil, err
+		}
+		return tts.NewOpenAI(client, tts.OpenAIConfig{
+			Model:   cfg.TTS.OpenAI.Model,
+			Voices:  voices,
+			Timeout: cfg.TTS.OpenAI.Timeout.Duration,
+		})
+	}
+
+	voices, err := ttsVoices(cfg.TTS.ESpeak.Voice, cfg.TTS.ESpeak.Voices)
+	if err != nil {
+		return nil, err
+	}
+	return tts.NewESpeak(tts.ESpeakConfig{
+		Voices: voices,
+		Rate:   cfg.TTS.ESpeak.Rate,
+		Pitch:  cfg.TTS.ESpeak.Pitch,
+	})
+}
+
+func ttsVoices(voice string, pairs []string) (tts.Voices, error) {
+	byLang, err := config.VoiceMap(pairs)
+	if err != nil {
+		return tts.Voices{}, err
+	}
+	return tts.Voices{Default: voice, ByLang: byLang}, nil
+}
+
+// loadRegistry reads the device registry named by nlu.devices, or returns
+// the built-in one.
+func loadRegistry(cfg config.Config) (*nlu.Registry, error) {
+	if cfg.NLU.Devices == "" {
+		return nlu.DefaultRegistry(), nil
+	}
+	return nlu.LoadRegistry(cfg.NLU.Devices)
+}
+
+// reload re-reads the configuration and swaps in whatever changed once no
+// session is running. Components whose settings did not change are kept,
+// so the Whisper model is only reloaded when stt.model differs or the local
+// backend gets switched on.
+func (d *daemon) reload() (reloadReport, error) {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	var rep reloadReport
+
+	cfg, err := d.load()
+	if err != nil {
+		return reloadReport{}, err
+	}
+
+	// the registry file may change on its own, so it is read every time
+	reg, err := loadRegistry(cfg)
+	if err != nil {
+		return reloadReport{}, err
+	}
+
+	d.swap.RLock()
+	old, oldReg := d.cfg, d.reg
+	d.swap.RUnlock()
+
+	rep.Changed = config.Diff(old, cfg)
+	regChanged := !reflect.DeepEqual(oldReg, reg)
+	if regChanged {
+		rep.Restarted = append(rep.Restarted, "registry")
+	}
+	if len(rep.Changed) == 0 && !regChanged {
+		log.Info("Config unchanged")
+		return rep, nil
+	}
+
+	changed := func(prefix string) bool {
+		return slices.ContainsFunc(rep.Changed, func(key string) bool {
+			return strings.HasPrefix(key, prefix)
+		})
+	}
+
+	var (
+		ptcl    *protocol.Protocol
+		tr      *stt.Transcriber
+		backend stt.Backend
+		api     *openai.Client
+		speech  tts.Engine
+	)
+
+	if changed("hub.") {
+		log.Info("Reconnecting to hub", "url", cfg.Hub.Url)
+		ptcl, err = dialHub(cfg)
+		if err != nil {
+			return reloadReport{}, err
+		}
+		rep.Restarted = append(rep.Restarted, "hub")
+	}
+
+	d.swap.RLock()
+	local := d.tr
+	d.swap.RUnlock()
+
+	wantLocal := cfg.STT.Backend == "local"
+	if wantLocal && (local == nil || changed("stt.model")) {
+		log.Info("Loading whisper model", "model", cfg.STT.Model)
+		tr, err = stt.NewTranscriber(cfg.STT.Model)
+		if err != nil {
+			if ptcl != nil {
+				ptcl.Close()
+			}
+			return reloadReport{}, err
+		}
+		local = tr
+		rep.Restarted = append(rep.Restarted, "stt")
+	}
+	if !wantLocal {
+		local = nil
+	}
+
+	if changed("stt.") || changed("proxy.") {
+		backend, err = newSTT(cfg, d.apiKey, local)
+		if err != nil {
+			if ptcl != nil {
+				ptcl.Close()
+			}
+			if tr != nil {
+				tr.Close()
+			}
+			return reloadReport{}, err
+		}
+	}
+
+	if changed("proxy.") {
+		client, err := newOpenAI(cfg, d.apiKey)
+		if err != nil {
+			if ptcl != nil {
+				ptcl.Close()
+			}
+			if tr != nil {
+				tr.Close()
+			}
+			return reloadReport{}, err
+		}
+		api = &client
+		rep.Restarted = append(rep.Restarted, "openai")
+	}
+
+	if changed("tts.") || (cfg.TTS.Backend == "openai" && changed("proxy.")) {
+		speech, err = newTTS(cfg, d.apiKey)
+		if err != nil {
+			if ptcl != nil {
+				ptcl.Close()
+			}
+			if tr != nil {
+				tr.Close()
+			}
+			return reloadReport{}, err
+		}
+		rep.Restarted = append(rep.Restarted, "tts")
+	}
+
+	// waits for a running session to finish
+	d.swap.Lock()
+	oldPtcl, oldTr := d.ptcl, d.tr
+	d.cfg = cfg
+	d.reg = reg
+	if ptcl != nil {
+		d.ptcl = ptcl
+	}
+	if backend != nil {
+		d.tr = local
+		d.backend = backend
+	}
+	if api != nil {
+		d.api = *api
+	}
+	if speech != nil {
+		d.tts = speech
+	}
+	d.swap.Unlock()
+
+	logLevel.Set(logLevelMap[cfg.Log.Level])
+	d.dialog.Configure(dialogueConfig(cfg))
+
+	if ptcl != nil {
+		d.serveHub(ptcl)
+		oldPtcl.Close()
+	}
+	if oldTr != nil && oldTr != local {
+		oldTr.Close()
+	}
+
+	if changed("wake.") {
+		on := d.wakeRunning()
+		if slices.Contains(rep.Changed, "wake.enabled") {
+			on = cfg.Wake.Enabled
+		}
+		d.setWake(false)
+		if on {
+			if err := d.setWake(true); err != nil {
+				log.Error("Failed to restart wake listening", "err", err)
+			}
+		}
+		rep.Restarted = append(rep.Restarted, "wake")
+	}
+
+	log.Info("Config reloaded", "changed", rep.Changed, "restarted", rep.Restarted)
+	d.events.Publish(ipc.Event{
+		Type: ipc.EventConfigReloaded,
+		Data: rep,
+	})
+
+	return rep, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "log/slog"
+
+	"vox/internal/audio"
+	"vox/internal/config"
+	"vox/internal/ipc"
+	"vox/internal/wake"
+	"vox/pkg/stt"
+)
+
+type wakeListener struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (d *daemon) wakeRunning() bool {
+	d.wakeMu.Lock()
+	defer d.wakeMu.Unlock()
+	return d.wake != nil
+}
+
+// setWake turns hands-free listening on or off. Turning it off while a
+// wake session is still recording ends that recording early.
+func (d *daemon) setWake(on bool) error {
+	d.wakeMu.Lock()
+	defer d.wakeMu.Unlock()
+
+	if on == (d.wake != nil) {
+		return nil
+	}
+
+	if !on {
+		d.wake.cancel()
+		<-d.wake.done
+		d.wake = nil
+		d.rec.StopMonitor()
+		log.Info("Wake listening off")
+		return nil
+	}
+
+	d.swap.RLock()
+	cfg := d.cfg.Wake
+	d.swap.RUnlock()
+
+	if d.wakeTr == nil || d.wakeModel != cfg.Model {
+		log.Info("Loading wake model", "model", cfg.Model)
+		tr, err := stt.NewTranscriber(cfg.Model)
+		if err != nil {
+			return err
+		}
+		if d.wakeTr != nil {
+			d.wakeTr.Close()
+		}
+		d.wakeTr = tr
+		d.wakeModel = cfg.Model
+	}
+
+	det, err := wake.NewDetector(d.wakeTr, wake.Config{
+		Phrases:  cfg.Phrases,
+		Language: cfg.Language,
+		Threads:  cfg.Threads,
+		MinRMS:   cfg.MinRMS,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the ring must outlive a window plus the time it takes to transcribe it
+	if err := d.rec.StartMonitor(cfg.Window.Duration + 8*time.Second); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.wake = &wakeListener{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go d.listenWake(ctx, det, cfg, d.wake.done)
+
+	log.Info("Wake listening on", "phrases", cfg.Phrases)
+	return nil
+}
+
+func (d *daemon) listenWake(ctx context.Context, det *wake.Detector, cfg config.WakeConfig, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(cfg.Hop.Duration)
+	defer ticker.Stop()
+
+	// audio before floor was either a hit already or part of a session
+	var floor int64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if d.currentState() != stateIdle {
+			floor = d.rec.Pos()
+			continue
+		}
+
+		pcm, start, err := d.rec.Recent(cfg.Window.Duration)
+		if err != nil {
+			log.Error("Wake monitor stopped", "err", err)
+			return
+		}
+		if start < floor {
+			skip := int(floor - start)
+			if skip >= len(pcm) {
+				continue
+			}
+			pcm, start = pcm[skip:], floor
+		}
+		if len(pcm) < int(cfg.Hop.Duration*audio.SampleRate/time.Second) {
+			continue
+		}
+
+		m, ok, err := det.Detect(ctx, pcm)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn("Wake detection failed", "err", err)
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		pos := start + int64(m.End)
+		floor = pos
+
+		log.Info("Wake phrase heard", "phrase", m.Phrase, "text", m.Text)
+		d.events.Publish(ipc.Event{
+			Type: ipc.EventWake,
+			Data: m,
+		})
+
+		c, err := d.rec.CaptureFrom(pos, frameSize)
+		if err != nil {
+			log.Error("Failed to capture after wake phrase", "err", err)
+			continue
+		}
+		if err := d.startSession(sessionSource{capture: c, wake: &m}); err != nil {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// vox-hub-mock stands in for the Monolith hub during development: it routes
+// frames between the shards that connect to it, answers for the devices a
+// script describes and records the traffic. Frames typed on stdin are sent
+// as if a shard had sent them, e.g. VOX:SAY:TEXT:hello:DEV.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/BurntSushi/toml"
+	"github.com/lmittmann/tint"
+	cli "github.com/spf13/pflag"
+
+	log "log/slog"
+
+	"vox/pkg/hubmock"
+)
+
+// script is the --script file:
+//
+//	[[rule]]
+//	to    = "VERTEX"
+//	verb  = "ON"
+//	noun  = "LAMP"
+//	reply = "ERR:BUSY" # OK[:ARG...], ERR:REASON[:ARG...] or "" for silence
+//	delay = "500ms"
+//	times = 1
+type script struct {
+	Rules []hubmock.Rule `toml:"rule"`
+}
+
+var logLevels = map[string]log.Level{
+	"debug": log.LevelDebug,
+	"info":  log.LevelInfo,
+	"warn":  log.LevelWarn,
+	"error": log.LevelError,
+}
+
+func main() {
+	addr := cli.StringP("addr", "a", "127.0.0.1:8092", "WebSocket listen address")
+	tcp := cli.StringP("tcp", "t", "", "Also serve line-oriented TCP on this address")
+	scriptPath := cli.StringP("script", "s", "", "TOML file with scripted device rules")
+	recordPath := cli.StringP("record", "r", "", "Append traffic as JSON lines to this file (- for stdout)")
+	level := cli.StringP("log", "l", "info", "Log level")
+	cli.Parse()
+
+	log.SetDefault(log.New(tint.NewHandler(os.Stderr, &tint.Options{
+		Level: logLevels[*level],
+	})))
+
+	hub := hubmock.New()
+
+	if *scriptPath != "" {
+		var sc script
+		if _, err := toml.DecodeFile(*scriptPath, &sc); err != nil {
+			log.Error("Failed to read script", "path", *scriptPath, "err", err)
+			os.Exit(1)
+		}
+		hub.Script(sc.Rules...)
+		log.Info("Loaded script", "rules", len(sc.Rules))
+	}
+
+	if *recordPath != "" {
+		var w io.Writer = os.Stdout
+		if *recordPath != "-" {
+			f, err := os.OpenFile(*recordPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				log.Error("Failed to open record file", "path", *recordPath, "err", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			w = f
+		}
+		var mu sync.Mutex
+		enc := json.NewEncoder(w)
+		hub.OnRecord = func(r hubmock.Record) {
+			mu.Lock()
+			defer mu.Unlock()
+			enc.Encode(r)
+		}
+	}
+
+	url, err := hub.Listen(*addr)
+	if err != nil {
+		log.Error("Failed to listen", "addr", *addr, "err", err)
+		os.Exit(1)
+	}
+	log.Info("Serving WebSocket", "url", url)
+
+	if *tcp != "" {
+		url, err := hub.ListenTCP(*tcp)
+		if err != nil {
+			log.Error("Failed to listen", "addr", *tcp, "err", err)
+			os.Exit(1)
+		}
+		log.Info("Serving TCP", "url", url)
+	}
+
+	go func() {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			frame := strings.TrimSpace(sc.Text())
+			if frame == "" {
+				continue
+			}
+			if err := hub.Send(frame); err != nil {
+				fmt.Fprintln(os.Stderr, "send:", err)
+			}
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	hub.Close()
+}
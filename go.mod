@@ -3,8 +3,10 @@ module vox
 go 1.25.3
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/ggerganov/whisper.cpp/bindings/go v0.0.0-20251109213803-a1867e0dad0b
 	github.com/go-audio/wav v1.1.0
+	github.com/godbus/dbus/v5 v5.2.2
 	github.com/gordonklaus/portaudio v0.0.0-20250206071425-98a94950218b
 	github.com/gorilla/websocket v1.5.3
 	github.com/hajimehoshi/go-mp3 v0.3.4
@@ -15,6 +17,8 @@ require (
 	github.com/pekim/opus v0.0.0-20240310090728-3f1075ec68e8
 	github.com/spf13/pflag v1.0.10
 	golang.org/x/net v0.34.0
+	golang.org/x/sys v0.29.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,284 @@
+// Package answer turns what became of a command into the short phrase VOX
+// says back: "ночник: выключено", "The desk lamp is not responding".
+package answer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	openai "github.com/openai/openai-go/v3"
+
+	"vox/internal/nlu"
+	"vox/pkg/protocol"
+)
+
+// Modes pick how the phrase is made.
+const (
+	ModeTemplate = "template" // fixed phrases per outcome and language
+	ModeLLM      = "llm"      // the chat model, templates when it fails
+	ModeOff      = "off"      // say nothing
+)
+
+type Config struct {
+	Mode     string        // ModeTemplate when empty
+	Model    string        // chat model for ModeLLM
+	Timeout  time.Duration // for the LLM, 0 = no limit
+	Registry *nlu.Registry // names the devices; DefaultRegistry() when nil
+}
+
+// Outcome is a dispatched command: what was understood, the hub's reply
+// and the error Dispatch returned.
+type Outcome struct {
+	Command nlu.Result
+	Reply   string
+	Err     error
+	Lang    string // language the user spoke
+}
+
+// Compose phrases o in o.Lang. It returns "" in ModeOff or when the
+// session was cancelled.
+func Compose(ctx context.Context, client openai.Client, cfg Config, o Outcome) string {
+	if cfg.Registry == nil {
+		cfg.Registry = nlu.DefaultRegistry()
+	}
+	if ctx.Err() != nil {
+		return ""
+	}
+
+	switch cfg.Mode {
+	case ModeOff:
+		return ""
+	case ModeLLM:
+		text, err := composeLLM(ctx, client, cfg, o)
+		if err == nil {
+			return text
+		}
+		if ctx.Err() != nil {
+			return ""
+		}
+		log.Warn("Failed to compose answer, using template", "err", err)
+	}
+
+	return Template(cfg.Registry, o)
+}
+
+// kinds of outcome, the keys of phrases
+const (
+	kindDone        = "done"
+	kindUnknown     = "unknown"
+	kindMissing     = "missing"
+	kindRefused     = "refused"
+	kindNoReply     = "no_reply"
+	kindGroupSome   = "group_some"
+	kindGroupFailed = "group_failed"
+	kindFailed      = "failed"
+)
+
+var phrases = map[string]map[string]string{
+	"ru": {
+		"turn_on":        "{device}: включено.",
+		"turn_off":       "{device}: выключено.",
+		"set_brightness": "{device}: яркость {brightness}.",
+		"set_mode":       "{device}: режим {mode}.",
+		"set_time":       "{device}: время {time}.",
+
+		kindDone:        "Готово.",
+		kindUnknown:     "Не поняла.",
+		kindMissing:     "Не указано: {entity}.",
+		kindRefused:     "{device}: ошибка, {reason}.",
+		kindNoReply:     "{device} не отвечает.",
+		kindGroupSome:   "Готово, кроме: {devices}.",
+		kindGroupFailed: "Не получилось: {devices}.",
+		kindFailed:      "Не получилось.",
+	},
+	"en": {
+		"turn_on":        "{device}: on.",
+		"turn_off":       "{device}: off.",
+		"set_brightness": "{device}: brightness {brightness}.",
+		"set_mode":       "{device}: {mode} mode.",
+		"set_time":       "{device}: set for {time}.",
+
+		kindDone:        "Done.",
+		kindUnknown:     "Sorry, I didn't get that.",
+		kindMissing:     "Missing the {entity}.",
+		kindRefused:     "{device}: error, {reason}.",
+		kindNoReply:     "The {device} is not responding.",
+		kindGroupSome:   "Done, except: {devices}.",
+		kindGroupFailed: "That didn't work: {devices}.",
+		kindFailed:      "That didn't work.",
+	},
+}
+
+var entityNames = map[string]map[string]string{
+	"ru": {
+		"device":     "устройство",
+		"brightness": "яркость",
+		"mode":       "режим",
+		"time":       "время",
+		"date":       "дата",
+	},
+}
+
+// Template phrases o from the built-in templates; Russian unless the user
+// spoke English.
+func Template(reg *nlu.Registry, o Outcome) string {
+	lang := o.Lang
+	if _, ok := phrases[lang]; !ok {
+		lang = "ru"
+	}
+
+	kind, vars := classify(reg, o, lang)
+	text, ok := phrases[lang][kind]
+	if !ok {
+		text = phrases[lang][kindDone]
+	}
+
+	for name, v := range vars {
+		text = strings.ReplaceAll(text, "{"+name+"}", v)
+	}
+	// a template still missing a value would read out the braces
+	if strings.Contains(text, "{") {
+		text = phrases[lang][kindDone]
+		if o.Err != nil {
+			text = phrases[lang][kindFailed]
+		}
+	}
+	return capitalize(text)
+}
+
+// classify picks the phrase for o and the values to fill in.
+func classify(reg *nlu.Registry, o Outcome, lang string) (string, map[string]string) {
+	cmd := o.Command
+	vars := map[string]string{}
+	for k, v := range cmd.Entities {
+		if v != "" {
+			vars[k] = v
+		}
+	}
+	if dev := cmd.Entities["device"]; dev != "" {
+		vars["device"] = reg.Name(dev, lang)
+	}
+
+	var (
+		ge *nlu.GroupError
+		ee *nlu.EntityError
+		re *protocol.ReplyError
+	)
+	switch {
+	case cmd.Intent == "" || cmd.Intent == "unknown":
+		return kindUnknown, vars
+
+	case errors.As(o.Err, &ge):
+		names := make([]string, 0, len(ge.Failed))
+		for id := range ge.Failed {
+			names = append(names, reg.Name(id, lang))
+		}
+		slices.Sort(names)
+		vars["devices"] = strings.Join(names, ", ")
+		if len(ge.Failed) == ge.Total {
+			return kindGroupFailed, vars
+		}
+		return kindGroupSome, vars
+
+	case errors.As(o.Err, &ee) && errors.Is(o.Err, nlu.ErrMissingEntity):
+		vars["entity"] = ee.Entity
+		if name, ok := entityNames[lang][ee.Entity]; ok {
+			vars["entity"] = name
+		}
+		return kindMissing, vars
+
+	case errors.As(o.Err, &re):
+		vars["reason"] = strings.ToLower(strings.ReplaceAll(re.Reply.Noun, "_", " "))
+		return kindRefused, vars
+
+	case errors.Is(o.Err, protocol.ErrNoReply):
+		return kindNoReply, vars
+
+	case o.Err != nil:
+		return kindFailed, vars
+	}
+
+	return cmd.Intent, vars
+}
+
+func capitalize(s string) string {
+	for i, r := range s {
+		return strings.ToUpper(string(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
+
+const systemPrompt = `You are VOX, the voice of the Monolith smart home.
+The user gave a spoken command; you get what it was understood as and what
+happened when it was sent to the device. Tell the user the result in ONE
+short sentence (at most 12 words) in the language with ISO code %q.
+Name devices the way the user would. On failure say briefly what went
+wrong. No greetings, no questions, no markdown, no quotes.`
+
+// composeLLM asks the chat model for the phrase.
+func composeLLM(ctx context.Context, client openai.Client, cfg Config, o Outcome) (string, error) {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	model := openai.ChatModelGPT5Nano
+	if cfg.Model != "" {
+		model = cfg.Model
+	}
+	lang := o.Lang
+	if lang == "" || lang == "auto" {
+		lang = "ru"
+	}
+
+	facts := struct {
+		Query    string            `json:"query"`
+		Intent   string            `json:"intent"`
+		Entities map[string]string `json:"entities,omitempty"`
+		Device   string            `json:"device,omitempty"`
+		Reply    string            `json:"device_reply,omitempty"`
+		Error    string            `json:"error,omitempty"`
+	}{
+		Query:    o.Command.Query,
+		Intent:   o.Command.Intent,
+		Entities: o.Command.Entities,
+		Reply:    o.Reply,
+	}
+	if dev := o.Command.Entities["device"]; dev != "" {
+		facts.Device = cfg.Registry.Name(dev, lang)
+	}
+	if o.Err != nil {
+		facts.Error = o.Err.Error()
+	}
+	data, err := json.Marshal(facts)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Model: model,
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(fmt.Sprintf(systemPrompt, lang)),
+			openai.UserMessage(string(data)),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("chat completion: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no choices in response")
+	}
+
+	text := strings.TrimSpace(resp.Choices[0].Message.Content)
+	if text == "" {
+		return "", errors.New("empty message content")
+	}
+	return text, nil
+}
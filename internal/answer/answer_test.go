@@ -0,0 +1,152 @@
+package answer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	openai "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+
+	"vox/internal/nlu"
+	"vox/pkg/protocol"
+)
+
+func TestTemplate(t *testing.T) {
+	lamp := func(intent string, entities ...string) nlu.Result {
+		e := map[string]string{"device": "lamp"}
+		for i := 0; i+1 < len(entities); i += 2 {
+			e[entities[i]] = entities[i+1]
+		}
+		return nlu.Result{Intent: intent, Entities: e}
+	}
+	refused := &protocol.ReplyError{Reply: &protocol.Message{To: "VOX", Verb: "ERR", Noun: "OUT_OF_RANGE", Args: []string{"2"}, From: "VERTEX"}}
+	noReply := fmt.Errorf("%w from VERTEX to ON:LAMP: %w", protocol.ErrNoReply, context.DeadlineExceeded)
+	missing := &nlu.EntityError{Intent: "set_brightness", Device: "lamp", Entity: "brightness", Err: nlu.ErrMissingEntity}
+	some := &nlu.GroupError{Group: "all", Total: 3, Failed: map[string]error{"timer": refused, "alarm": noReply}}
+	every := &nlu.GroupError{Group: "all", Total: 2, Failed: map[string]error{"timer": refused, "alarm": noReply}}
+
+	tests := []struct {
+		name    string
+		outcome Outcome
+		ru, en  string
+	}{
+		{
+			name:    "turn on",
+			outcome: Outcome{Command: lamp("turn_on"), Reply: "VOX:OK:LAMP:VERTEX"},
+			ru:      "Ночник: включено.",
+			en:      "Night lamp: on.",
+		},
+		{
+			name:    "brightness",
+			outcome: Outcome{Command: lamp("set_brightness", "brightness", "128")},
+			ru:      "Ночник: яркость 128.",
+			en:      "Night lamp: brightness 128.",
+		},
+		{
+			name:    "intent without a phrase",
+			outcome: Outcome{Command: lamp("stop")},
+			ru:      "Готово.",
+			en:      "Done.",
+		},
+		{
+			name:    "not understood",
+			outcome: Outcome{Command: nlu.Result{Intent: "unknown"}},
+			ru:      "Не поняла.",
+			en:      "Sorry, I didn't get that.",
+		},
+		{
+			name:    "missing entity",
+			outcome: Outcome{Command: lamp("set_brightness"), Err: missing},
+			ru:      "Не указано: яркость.",
+			en:      "Missing the brightness.",
+		},
+		{
+			name:    "ERR reply",
+			outcome: Outcome{Command: lamp("set_brightness", "brightness", "300"), Err: refused},
+			ru:      "Ночник: ошибка, out of range.",
+			en:      "Night lamp: error, out of range.",
+		},
+		{
+			name:    "no reply",
+			outcome: Outcome{Command: lamp("turn_on"), Err: noReply},
+			ru:      "Ночник не отвечает.",
+			en:      "The night lamp is not responding.",
+		},
+		{
+			name:    "any other error",
+			outcome: Outcome{Command: lamp("turn_on"), Err: errors.New("protocol closed")},
+			ru:      "Не получилось.",
+			en:      "That didn't work.",
+		},
+		{
+			// the group error wraps ErrNoReply, but is about the group
+			name:    "group partly failed",
+			outcome: Outcome{Command: nlu.Result{Intent: "turn_off", Entities: map[string]string{"device": "all"}}, Err: some},
+			ru:      "Готово, кроме: колонки, термометр.",
+			en:      "Done, except: sound, weather display.",
+		},
+		{
+			name:    "group failed",
+			outcome: Outcome{Command: nlu.Result{Intent: "turn_off", Entities: map[string]string{"device": "all"}}, Err: every},
+			ru:      "Не получилось: колонки, термометр.",
+			en:      "That didn't work: sound, weather display.",
+		},
+		{
+			// no device to fill in: a plain phrase instead of the braces
+			name:    "unfilled template",
+			outcome: Outcome{Command: nlu.Result{Intent: "turn_on"}},
+			ru:      "Готово.",
+			en:      "Done.",
+		},
+		{
+			name:    "unfilled template after an error",
+			outcome: Outcome{Command: nlu.Result{Intent: "turn_on"}, Err: refused},
+			ru:      "Не получилось.",
+			en:      "That didn't work.",
+		},
+	}
+
+	reg := nlu.DefaultRegistry()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for lang, want := range map[string]string{
+				"ru": tt.ru,
+				"en": tt.en,
+				// Russian for whatever else whisper heard
+				"de": tt.ru,
+				"":   tt.ru,
+			} {
+				o := tt.outcome
+				o.Lang = lang
+				if got := Template(reg, o); got != want {
+					t.Errorf("%q: %q, want %q", lang, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompose(t *testing.T) {
+	// nothing listens there: the LLM mode falls back to the template
+	client := openai.NewClient(option.WithBaseURL("http://127.0.0.1:1/v1/"), option.WithAPIKey("test"), option.WithMaxRetries(0))
+	o := Outcome{Command: nlu.Result{Intent: "turn_off", Entities: map[string]string{"device": "lamp"}}, Lang: "en"}
+
+	for mode, want := range map[string]string{
+		"":           "Night lamp: off.",
+		ModeTemplate: "Night lamp: off.",
+		ModeLLM:      "Night lamp: off.",
+		ModeOff:      "",
+	} {
+		if got := Compose(context.Background(), client, Config{Mode: mode}, o); got != want {
+			t.Errorf("mode %q: %q, want %q", mode, got, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := Compose(ctx, client, Config{}, o); got != "" {
+		t.Errorf("cancelled: %q", got)
+	}
+}
@@ -3,54 +3,180 @@ package audio
 import (
 	"context"
 	"fmt"
+	log "log/slog"
 	"math"
-	"os/exec"
-	"regexp"
-	"strconv"
+	"path"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 )
 
-var (
-	percentRe = regexp.MustCompile(`(\d+)\s*%`)
+// Backends the Ducker talks to the sound server with.
+const (
+	BackendAuto   = "auto"   // native, pactl when the socket is unreachable
+	BackendNative = "native" // the PulseAudio protocol, also pipewire-pulse
+	BackendPactl  = "pactl"  // shell out to pactl
+)
+
+// Actions a DuckRule takes on the streams it matches.
+const (
+	ActionDuck   = "duck"   // lower the volume
+	ActionMute   = "mute"   // mute, keeping the volume
+	ActionIgnore = "ignore" // leave alone
+	ActionPause  = "pause"  // pause the MPRIS player, duck without one
 )
 
+// VolumeNorm is the raw volume of 100%.
+const VolumeNorm = 0x10000
+
+// Volume is the raw volume of every channel of a stream.
+type Volume []uint32
+
+// Max is the loudest channel.
+func (v Volume) Max() uint32 {
+	if len(v) == 0 {
+		return 0
+	}
+	return slices.Max(v)
+}
+
+// Scale multiplies every channel by f, which keeps the balance.
+func (v Volume) Scale(f float64) Volume {
+	res := make(Volume, len(v))
+	for i, c := range v {
+		res[i] = uint32(math.Round(float64(c) * f))
+	}
+	return res
+}
+
 type streamInfo struct {
 	ID      int
-	Volume  int
+	Sink    int
+	Volume  Volume
+	Muted   bool
 	AppName string
+	Props   map[string]string
+	Fixed   bool // the volume cannot be set
 }
 
+// mixer lists the playback streams and changes them.
+type mixer interface {
+	sinkInputs(ctx context.Context) ([]streamInfo, error)
+	setSinkInputVolume(ctx context.Context, id int, vol Volume) error
+	setSinkInputMute(ctx context.Context, id int, mute bool) error
+	sinkName(ctx context.Context, index int) (string, error)
+	newStreams(ctx context.Context) (<-chan int, error)
+	Close() error
+}
+
+var (
+	_ mixer = (*pulseClient)(nil)
+	_ mixer = pactl{}
+)
+
 type fadeTarget struct {
 	id   int
-	from int
-	to   int
+	from Volume
+	to   Volume
+}
+
+// DuckRule picks what happens to the streams it matches. Field is "app",
+// "binary", "role" or "sink", Pattern a case-insensitive glob of the
+// application name, process binary, media role or sink name.
+type DuckRule struct {
+	Field   string
+	Pattern string
+	Action  string
+	Factor  float64 // for ActionDuck, 0 = the factor given to DuckOthers
+}
+
+type DuckerConfig struct {
+	Self      []string   // application names of our own streams
+	MinVolume int        // percent the loudest channel is not ducked below
+	Backend   string     // BackendAuto when empty
+	StatePath string     // keeps the ducked volumes, DefaultStatePath() when empty
+	Rules     []DuckRule // the first match wins, unmatched streams are ducked
 }
 
 type Ducker struct {
 	mu          sync.Mutex
 	active      bool
-	selfNames   []string
-	originalVol map[int]int
-	minVolume   int
+	cfg         DuckerConfig
+	originalVol map[int]duckedStream
+	paused      []string // MPRIS players to resume
+
+	// for streams that start while ducked
+	factor    float64
+	fade      time.Duration
+	stopWatch context.CancelFunc
 }
 
-func NewDucker(selfNames []string, minVolume int) *Ducker {
-	if minVolume < 0 {
-		minVolume = 0
+func NewDucker(cfg DuckerConfig) *Ducker {
+	cfg.Self = append([]string(nil), cfg.Self...)
+	cfg.Rules = append([]DuckRule(nil), cfg.Rules...)
+	cfg.MinVolume = max(0, min(cfg.MinVolume, 150))
+	if cfg.Backend == "" {
+		cfg.Backend = BackendAuto
 	}
-	if minVolume > 150 {
-		minVolume = 150
+	if cfg.StatePath == "" {
+		cfg.StatePath = DefaultStatePath()
 	}
 
-	d := &Ducker{
-		selfNames:   append([]string(nil), selfNames...),
-		originalVol: make(map[int]int),
-		minVolume:   minVolume,
+	return &Ducker{
+		cfg:         cfg,
+		originalVol: make(map[int]duckedStream),
 	}
+}
 
-	return d
+// duckOp is one pass over the streams. What is to be done is gathered
+// first, so that it reaches the state file before anything changes.
+type duckOp struct {
+	m       mixer
+	sinks   map[int]string
+	bus     *mprisBus
+	busErr  error
+	players []mprisPlayer
+
+	fades  []fadeTarget
+	mutes  []int
+	pauses []string
+}
+
+func (op *duckOp) empty() bool {
+	return len(op.fades) == 0 && len(op.mutes) == 0 && len(op.pauses) == 0
+}
+
+func (op *duckOp) Close() {
+	op.m.Close()
+	if op.bus != nil {
+		op.bus.Close()
+	}
+}
+
+// sinkName is "" when the sink cannot be looked up.
+func (op *duckOp) sinkName(ctx context.Context, index int) string {
+	name, ok := op.sinks[index]
+	if !ok {
+		var err error
+		name, err = op.m.sinkName(ctx, index)
+		if err != nil {
+			log.Debug("Failed to look up sink", "sink", index, "err", err)
+		}
+		op.sinks[index] = name
+	}
+	return name
+}
+
+// mpris connects to the session bus on first use.
+func (op *duckOp) mpris(ctx context.Context) (*mprisBus, error) {
+	if op.bus == nil && op.busErr == nil {
+		op.bus, op.busErr = dialMPRIS(ctx)
+		if op.busErr == nil {
+			op.players, op.busErr = op.bus.players(ctx)
+		}
+	}
+	return op.bus, op.busErr
 }
 
 func (d *Ducker) DuckOthers(ctx context.Context, factor float64, duration time.Duration) error {
@@ -61,106 +187,416 @@ func (d *Ducker) DuckOthers(ctx context.Context, factor float64, duration time.D
 		return nil
 	}
 
-	streams, err := listStreams(ctx)
+	op, err := d.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer op.Close()
+
+	streams, err := op.m.sinkInputs(ctx)
 	if err != nil {
-		return fmt.Errorf("listStreams: %w", err)
+		return fmt.Errorf("list sink inputs: %w", err)
 	}
 
-	d.originalVol = make(map[int]int)
+	// streams an earlier run left ducked go back to where they were
+	// before that, not to their lowered volume
+	saved, err := loadDuckState(d.cfg.StatePath)
+	if err != nil {
+		log.Warn("Failed to load duck state", "path", d.cfg.StatePath, "err", err)
+	}
 
-	var targets []fadeTarget
+	d.originalVol = make(map[int]duckedStream)
+	d.paused = append([]string(nil), saved.Paused...)
+	d.factor, d.fade = factor, duration
 
 	for _, s := range streams {
-		if d.isSelfStream(s) {
-			continue
-		}
+		d.plan(ctx, op, s, saved.Streams)
+	}
+
+	// on disk before the first stream changes, and active from then on
+	// so that a fade failing halfway is undone as well
+	d.save()
+	d.active = true
+	d.watch()
+
+	return d.apply(ctx, op)
+}
+
+func (d *Ducker) UnduckOthers(ctx context.Context, duration time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.active {
+		return nil
+	}
 
-		from := s.Volume
+	return d.restore(ctx, duration)
+}
+
+// Restore puts back at once what this Ducker changed or, going by the
+// state file, what an earlier one left changed when it crashed or failed
+// to unduck.
+func (d *Ducker) Restore(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-		targetFloat := float64(from) * factor
-		if targetFloat < float64(d.minVolume) {
-			targetFloat = float64(d.minVolume)
+	if !d.active {
+		saved, err := loadDuckState(d.cfg.StatePath)
+		if err != nil {
+			return fmt.Errorf("load duck state: %w", err)
 		}
-		if targetFloat > 150.0 {
-			targetFloat = 150.0
+		if len(saved.Streams) == 0 && len(saved.Paused) == 0 {
+			return nil
 		}
+		d.originalVol = saved.Streams
+		d.paused = saved.Paused
+	}
 
-		to := int(math.Round(targetFloat))
+	return d.restore(ctx, 0)
+}
 
-		d.originalVol[s.ID] = from
+// restore fades the streams back, unmutes them and resumes the paused
+// players. On failure the Ducker stays active and the state file stays,
+// for the next attempt.
+func (d *Ducker) restore(ctx context.Context, duration time.Duration) error {
+	d.stopWatching()
 
-		targets = append(targets, fadeTarget{
-			id:   s.ID,
-			from: from,
-			to:   to,
-		})
+	op, err := d.begin(ctx)
+	if err != nil {
+		d.active = true
+		return err
 	}
+	defer op.Close()
 
-	if len(targets) == 0 {
+	streams, err := op.m.sinkInputs(ctx)
+	if err != nil {
 		d.active = true
-		return nil
+		return fmt.Errorf("list sink inputs: %w", err)
 	}
 
-	if err := fadeInputs(ctx, targets, duration); err != nil {
-		return err
+	var (
+		targets []fadeTarget
+		unmutes []int
+	)
+
+	for _, s := range streams {
+		orig, ok := d.originalVol[s.ID]
+		if !ok || orig.App != s.AppName {
+			continue
+		}
+
+		switch {
+		case orig.Muted:
+			if s.Muted {
+				unmutes = append(unmutes, s.ID)
+			}
+		case !s.Fixed:
+			targets = append(targets, fadeTarget{
+				id:   s.ID,
+				from: s.Volume,
+				to:   orig.Volume,
+			})
+		}
 	}
 
-	d.active = true
+	if len(targets) > 0 {
+		if err := fadeInputs(ctx, op.m, targets, duration); err != nil {
+			d.active = true
+			return err
+		}
+	}
+	for _, id := range unmutes {
+		if err := op.m.setSinkInputMute(ctx, id, false); err != nil {
+			d.active = true
+			return fmt.Errorf("unmute id=%d: %w", id, err)
+		}
+	}
+	d.resume(ctx, op)
+
+	d.originalVol = make(map[int]duckedStream)
+	d.paused = nil
+	d.active = false
+	d.save()
 
 	return nil
 }
 
-func (d *Ducker) UnduckOthers(ctx context.Context, duration time.Duration) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// begin connects to the sound server for one pass over the streams.
+func (d *Ducker) begin(ctx context.Context) (*duckOp, error) {
+	m, err := d.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &duckOp{m: m, sinks: make(map[int]string)}, nil
+}
 
-	if !d.active {
-		return nil
+// plan decides by the first matching rule what becomes of s and records
+// how to put it back. saved holds the streams an earlier run left ducked.
+func (d *Ducker) plan(ctx context.Context, op *duckOp, s streamInfo, saved map[int]duckedStream) {
+	if d.isSelfStream(s) {
+		return
 	}
+	rule := d.rule(ctx, op, s)
 
-	streams, err := listStreams(ctx)
-	if err != nil {
-		return fmt.Errorf("listStreams: %w", err)
+	orig := duckedStream{App: s.AppName, Volume: s.Volume}
+	if prev, ok := saved[s.ID]; ok && prev.App == s.AppName && len(prev.Volume) == len(s.Volume) {
+		orig = prev
 	}
 
-	curVol := make(map[int]int)
+	switch rule.Action {
+	case ActionIgnore:
+		return
 
-	for _, s := range streams {
-		if d.isSelfStream(s) {
-			continue
+	case ActionMute:
+		// muted by the user already, theirs to unmute
+		if s.Muted && !orig.Muted {
+			return
+		}
+		orig.Muted = true
+		d.originalVol[s.ID] = orig
+		op.mutes = append(op.mutes, s.ID)
+		return
+
+	case ActionPause:
+		if name := d.player(ctx, op, s); name != "" {
+			if slices.Contains(d.paused, name) {
+				return
+			}
+			// a player that is not playing is not resumed either
+			if status, err := op.bus.status(ctx, name); err == nil && status != "Playing" {
+				return
+			}
+			d.paused = append(d.paused, name)
+			op.pauses = append(op.pauses, name)
+			return
 		}
-		curVol[s.ID] = s.Volume
+		// nothing to pause, duck it
 	}
 
-	var targets []fadeTarget
+	if s.Fixed || len(s.Volume) == 0 {
+		return
+	}
+
+	factor := rule.Factor
+	if factor == 0 {
+		factor = d.factor
+	}
+	d.originalVol[s.ID] = orig
+	op.fades = append(op.fades, fadeTarget{
+		id:   s.ID,
+		from: s.Volume,
+		to:   d.ducked(orig.Volume, factor),
+	})
+}
+
+// ducked scales vol by factor; the floor holds for the loudest channel,
+// the others keep their share of it.
+func (d *Ducker) ducked(vol Volume, factor float64) Volume {
+	floor := uint32(d.cfg.MinVolume * VolumeNorm / 100)
 
-	for id, from := range curVol {
-		orig, ok := d.originalVol[id]
-		if !ok {
+	to := vol.Scale(factor)
+	if peak := vol.Max(); to.Max() < floor {
+		to = vol
+		if peak > floor {
+			to = vol.Scale(float64(floor) / float64(peak))
+		}
+	}
+	return to
+}
+
+// rule is the first rule matching s, ActionDuck without one.
+func (d *Ducker) rule(ctx context.Context, op *duckOp, s streamInfo) DuckRule {
+	for _, r := range d.cfg.Rules {
+		var value string
+		switch r.Field {
+		case "app":
+			value = s.AppName
+		case "binary":
+			value = s.Props["application.process.binary"]
+		case "role":
+			value = s.Props["media.role"]
+		case "sink":
+			value = op.sinkName(ctx, s.Sink)
+		}
+		if value == "" {
 			continue
 		}
 
-		targets = append(targets, fadeTarget{
-			id:   id,
-			from: from,
-			to:   orig,
-		})
+		if ok, _ := path.Match(strings.ToLower(r.Pattern), strings.ToLower(value)); ok {
+			return r
+		}
 	}
 
-	if len(targets) > 0 {
-		if err := fadeInputs(ctx, targets, duration); err != nil {
-			return err
+	return DuckRule{Action: ActionDuck}
+}
+
+// player is the MPRIS player of s, "" when there is none or no session
+// bus.
+func (d *Ducker) player(ctx context.Context, op *duckOp, s streamInfo) string {
+	if _, err := op.mpris(ctx); err != nil {
+		log.Debug("No MPRIS players to pause", "err", err)
+		return ""
+	}
+	return playerFor(op.players, s)
+}
+
+// apply carries out op: players pause, then streams are muted and faded.
+func (d *Ducker) apply(ctx context.Context, op *duckOp) error {
+	for _, name := range op.pauses {
+		if err := op.bus.pause(ctx, name); err != nil {
+			log.Warn("Failed to pause player", "player", name, "err", err)
 		}
 	}
 
-	d.originalVol = make(map[int]int)
-	d.active = false
+	for _, id := range op.mutes {
+		if err := op.m.setSinkInputMute(ctx, id, true); err != nil {
+			return fmt.Errorf("mute id=%d: %w", id, err)
+		}
+	}
 
-	return nil
+	if len(op.fades) == 0 {
+		return nil
+	}
+
+	return fadeInputs(ctx, op.m, op.fades, d.fade)
+}
+
+// resume plays the players paused before, unless someone else stopped
+// them meanwhile.
+func (d *Ducker) resume(ctx context.Context, op *duckOp) {
+	if len(d.paused) == 0 {
+		return
+	}
+
+	bus, err := op.mpris(ctx)
+	if err != nil {
+		log.Warn("Failed to resume players", "players", d.paused, "err", err)
+		return
+	}
+
+	for _, name := range d.paused {
+		status, err := bus.status(ctx, name)
+		if err != nil || status != "Paused" {
+			continue // gone or not ours to resume
+		}
+		if err := bus.play(ctx, name); err != nil {
+			log.Warn("Failed to resume player", "player", name, "err", err)
+		}
+	}
+}
+
+// watch handles the streams that start while ducked until restore stops
+// it.
+func (d *Ducker) watch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stopWatch = cancel
+
+	go func() {
+		m, err := d.open(ctx)
+		if err != nil {
+			log.Warn("Failed to watch for new streams", "err", err)
+			return
+		}
+		defer m.Close()
+
+		ids, err := m.newStreams(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn("Failed to watch for new streams", "err", err)
+			}
+			return
+		}
+
+		for id := range ids {
+			d.duckNew(ctx, id)
+		}
+	}()
+}
+
+func (d *Ducker) stopWatching() {
+	if d.stopWatch != nil {
+		d.stopWatch()
+		d.stopWatch = nil
+	}
+}
+
+// duckNew applies the rules to a stream that started while ducked.
+func (d *Ducker) duckNew(ctx context.Context, id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if ctx.Err() != nil || !d.active {
+		return
+	}
+	if _, ok := d.originalVol[id]; ok {
+		return
+	}
+
+	op, err := d.begin(ctx)
+	if err != nil {
+		log.Warn("Failed to duck new stream", "id", id, "err", err)
+		return
+	}
+	defer op.Close()
+
+	streams, err := op.m.sinkInputs(ctx)
+	if err != nil {
+		log.Warn("Failed to duck new stream", "id", id, "err", err)
+		return
+	}
+	i := slices.IndexFunc(streams, func(s streamInfo) bool { return s.ID == id })
+	if i < 0 {
+		return // gone already
+	}
+
+	d.plan(ctx, op, streams[i], nil)
+	if op.empty() {
+		return
+	}
+	d.save()
+
+	if err := d.apply(ctx, op); err != nil {
+		log.Warn("Failed to duck new stream", "id", id, "err", err)
+		return
+	}
+	log.Debug("Ducked new stream", "id", id, "app", streams[i].AppName)
+}
+
+// save writes the state file, or removes it when nothing is ducked.
+// Ducking goes on without it.
+func (d *Ducker) save() {
+	st := duckState{Streams: d.originalVol, Paused: d.paused}
+	if err := saveDuckState(d.cfg.StatePath, st); err != nil {
+		log.Warn("Failed to save duck state", "path", d.cfg.StatePath, "err", err)
+	}
+}
+
+// open connects to the sound server.
+func (d *Ducker) open(ctx context.Context) (mixer, error) {
+	switch d.cfg.Backend {
+	case BackendPactl:
+		return pactl{}, nil
+	case BackendNative:
+		c, err := dialPulse(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("connect to pulse: %w", err)
+		}
+		return c, nil
+	}
+
+	c, err := dialPulse(ctx)
+	if err == nil {
+		return c, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	log.Debug("Native pulse connection failed, using pactl", "err", err)
+	return pactl{}, nil
 }
 
 func (d *Ducker) isSelfStream(s streamInfo) bool {
-	for _, name := range d.selfNames {
+	for _, name := range d.cfg.Self {
 		if s.AppName == name {
 			return true
 		}
@@ -169,10 +605,10 @@ func (d *Ducker) isSelfStream(s streamInfo) bool {
 	return false
 }
 
-func fadeInputs(ctx context.Context, targets []fadeTarget, duration time.Duration) error {
+func fadeInputs(ctx context.Context, m mixer, targets []fadeTarget, duration time.Duration) error {
 	if duration <= 0 {
 		for _, t := range targets {
-			if err := setSinkInputVolume(ctx, t.id, t.to); err != nil {
+			if err := m.setSinkInputVolume(ctx, t.id, t.to); err != nil {
 				return fmt.Errorf("set volume id=%d: %w", t.id, err)
 			}
 		}
@@ -199,11 +635,7 @@ func fadeInputs(ctx context.Context, targets []fadeTarget, duration time.Duratio
 		tFrac := float64(i) / float64(steps)
 
 		for _, s := range targets {
-			delta := s.to - s.from
-			vFloat := float64(s.from) + float64(delta)*tFrac
-			v := int(math.Round(vFloat))
-
-			if err := setSinkInputVolume(ctx, s.id, v); err != nil {
+			if err := m.setSinkInputVolume(ctx, s.id, interpolate(s.from, s.to, tFrac)); err != nil {
 				return fmt.Errorf("set volume id=%d: %w", s.id, err)
 			}
 		}
@@ -216,89 +648,17 @@ func fadeInputs(ctx context.Context, targets []fadeTarget, duration time.Duratio
 	return nil
 }
 
-func listStreams(ctx context.Context) ([]streamInfo, error) {
-	cmd := exec.CommandContext(ctx, "pactl", "list", "sink-inputs")
-	out, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("pactl list sink-inputs: %w", err)
-	}
-
-	text := string(out)
-	parts := strings.Split(text, "Sink Input #")
-	if len(parts) <= 1 {
-		return nil, nil
-	}
-
-	var res []streamInfo
-
-	for i := 1; i < len(parts); i++ {
-		block := parts[i]
-
-		newline := strings.IndexByte(block, '\n')
-		if newline <= 0 {
-			continue
-		}
-
-		idStr := strings.TrimSpace(block[:newline])
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			continue
-		}
-
-		body := block[newline+1:]
-
-		lines := strings.Split(body, "\n")
-
-		s := streamInfo{
-			ID: id,
-		}
-
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-
-			if strings.HasPrefix(line, "Volume:") && s.Volume == 0 {
-				m := percentRe.FindStringSubmatch(line)
-				if len(m) >= 2 {
-					v, err := strconv.Atoi(m[1])
-					if err == nil {
-						s.Volume = v
-					}
-				}
-			}
-
-			if strings.HasPrefix(line, "application.name =") && s.AppName == "" {
-				idx := strings.Index(line, "\"")
-				if idx >= 0 {
-					line = line[idx+1:]
-					idx2 := strings.Index(line, "\"")
-					if idx2 >= 0 {
-						s.AppName = line[:idx2]
-					}
-				}
-			}
-		}
-
-		if s.Volume == 0 && s.AppName == "" {
-			continue
-		}
-
-		res = append(res, s)
+// interpolate moves every channel from from towards to. A stream whose
+// channels changed meanwhile jumps straight to to.
+func interpolate(from, to Volume, frac float64) Volume {
+	if len(from) != len(to) {
+		return to
 	}
 
-	return res, nil
-}
-
-func setSinkInputVolume(ctx context.Context, id int, percent int) error {
-	if percent < 0 {
-		percent = 0
-	}
-	if percent > 150 {
-		percent = 150
+	res := make(Volume, len(to))
+	for i := range to {
+		delta := float64(to[i]) - float64(from[i])
+		res[i] = uint32(math.Round(float64(from[i]) + delta*frac))
 	}
-
-	arg := fmt.Sprintf("%d%%", percent)
-
-	cmd := exec.CommandContext(ctx, "pactl", "set-sink-input-volume", strconv.Itoa(id), arg)
-
-	return cmd.Run()
+	return res
 }
@@ -0,0 +1,98 @@
+package audio
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// What a Ducker changes is written to a state file before it changes and
+// removed once it is back, so whatever happens in between a later run can
+// put it back with Restore.
+
+// duckedStream is what a stream sounded like before it was ducked. The
+// app name tells a stream from a new one that got its index after a
+// sound server restart.
+type duckedStream struct {
+	App    string `json:"app"`
+	Volume Volume `json:"volume"`
+	Muted  bool   `json:"muted,omitempty"` // by us, to unmute
+}
+
+type duckState struct {
+	PID     int                  `json:"pid"`
+	Streams map[int]duckedStream `json:"streams"`          // by sink input index
+	Paused  []string             `json:"paused,omitempty"` // MPRIS players
+}
+
+// DefaultStatePath is $XDG_STATE_HOME/vox/duck.json, ~/.local/state when
+// unset.
+func DefaultStatePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(os.TempDir(), "vox-duck.json")
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "vox", "duck.json")
+}
+
+// loadDuckState reads the state file; a missing or broken one is empty.
+func loadDuckState(path string) (duckState, error) {
+	var st duckState
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &st)
+	}
+	if st.Streams == nil {
+		st.Streams = map[int]duckedStream{}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	return st, err
+}
+
+// saveDuckState replaces the state file in one rename, so a crash leaves
+// either the old or the new one. An empty state removes it.
+func saveDuckState(path string, st duckState) error {
+	if len(st.Streams) == 0 && len(st.Paused) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	st.PID = os.Getpid()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".duck-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,284 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	log "log/slog"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+const (
+	SampleRate   = 16000
+	monitorFrame = 320 // 20ms
+	tapBuffer    = 30 * SampleRate / monitorFrame
+)
+
+var ErrCaptureOverrun = errors.New("capture overrun")
+
+// monitor is the always-on input stream. It feeds a ring of recent audio
+// and every open tap from a single PortAudio stream.
+type monitor struct {
+	stop chan struct{}
+	done chan struct{}
+	ring *Ring
+	taps map[*tap]struct{}
+}
+
+type tap struct {
+	ch      chan []float32
+	overrun bool
+}
+
+// StartMonitor keeps the microphone open and remembers the last keep of
+// audio. While it runs, recordings tap it instead of opening a stream.
+func (r *Recorder) StartMonitor(keep time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mon != nil {
+		return nil
+	}
+
+	buf := make([]float32, monitorFrame)
+
+	stream, err := portaudio.OpenDefaultStream(1, 0, SampleRate, len(buf), buf)
+	if err != nil {
+		return err
+	}
+	if err := stream.Start(); err != nil {
+		stream.Close()
+		return err
+	}
+
+	m := &monitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		ring: NewRing(int(keep * SampleRate / time.Second)),
+		taps: make(map[*tap]struct{}),
+	}
+	r.mon = m
+
+	go r.pump(m, stream, buf)
+
+	return nil
+}
+
+func (r *Recorder) StopMonitor() {
+	r.mu.Lock()
+	m := r.mon
+	r.detach(m)
+	r.mu.Unlock()
+
+	if m == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+}
+
+func (r *Recorder) Monitoring() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mon != nil
+}
+
+// Recent copies up to d of the newest monitored audio together with the
+// absolute position of its first sample.
+func (r *Recorder) Recent(d time.Duration) ([]float32, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mon == nil {
+		return nil, 0, errors.New("monitor not running")
+	}
+	pcm, pos := r.mon.ring.Last(int(d * SampleRate / time.Second))
+	return pcm, pos, nil
+}
+
+// Pos is the absolute position of the newest monitored sample.
+func (r *Recorder) Pos() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mon == nil {
+		return 0
+	}
+	return r.mon.ring.Pos()
+}
+
+// detach drops m and ends its taps. r.mu must be held.
+func (r *Recorder) detach(m *monitor) {
+	if m == nil {
+		return
+	}
+	if r.mon == m {
+		r.mon = nil
+	}
+	for t := range m.taps {
+		close(t.ch)
+		delete(m.taps, t)
+	}
+}
+
+func (r *Recorder) pump(m *monitor, stream *portaudio.Stream, buf []float32) {
+	defer close(m.done)
+	defer stream.Close()
+	defer stream.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		if err := stream.Read(); err != nil {
+			if errors.Is(err, portaudio.InputOverflowed) {
+				continue
+			}
+			log.Error("Monitor stream failed", "err", err)
+			r.mu.Lock()
+			r.detach(m)
+			r.mu.Unlock()
+			return
+		}
+
+		frame := append([]float32(nil), buf...)
+
+		r.mu.Lock()
+		m.ring.Write(frame)
+		for t := range m.taps {
+			select {
+			case t.ch <- frame:
+			default:
+				t.overrun = true
+				close(t.ch)
+				delete(m.taps, t)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Capture is a source of consecutive mono 16 kHz frames, either a stream
+// of its own or a tap on the monitor.
+type Capture struct {
+	frame   []float32
+	stream  *portaudio.Stream
+	pending []float32
+
+	rec *Recorder
+	mon *monitor
+	tap *tap
+
+	sink Sink
+}
+
+// Sink receives audio while it is being recorded, e.g. a streaming
+// transcriber. Reset drops what was sent when the recording discards it.
+type Sink interface {
+	Write(pcm []float32)
+	Reset()
+}
+
+// SetSink makes the Record methods forward kept audio to s as they go.
+func (c *Capture) SetSink(s Sink) {
+	c.sink = s
+}
+
+// Capture opens a live source returning frameSize samples per Read.
+func (r *Recorder) Capture(frameSize int) (*Capture, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mon != nil {
+		return r.tapFrom(r.mon.ring.Pos(), frameSize), nil
+	}
+
+	c := &Capture{frame: make([]float32, frameSize)}
+
+	stream, err := portaudio.OpenDefaultStream(1, 0, SampleRate, len(c.frame), c.frame)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Start(); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	c.stream = stream
+
+	return c, nil
+}
+
+// CaptureFrom is like Capture but first replays monitored audio starting
+// at absolute position pos, so nothing said since then is lost.
+func (r *Recorder) CaptureFrom(pos int64, frameSize int) (*Capture, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mon == nil {
+		return nil, errors.New("monitor not running")
+	}
+	return r.tapFrom(pos, frameSize), nil
+}
+
+// tapFrom registers a tap on the running monitor. r.mu must be held.
+func (r *Recorder) tapFrom(pos int64, frameSize int) *Capture {
+	backlog, _ := r.mon.ring.From(pos)
+
+	t := &tap{ch: make(chan []float32, tapBuffer)}
+	r.mon.taps[t] = struct{}{}
+
+	return &Capture{
+		frame:   make([]float32, frameSize),
+		pending: backlog,
+		rec:     r,
+		mon:     r.mon,
+		tap:     t,
+	}
+}
+
+// Read fills and returns the next frame; the slice is reused by later
+// calls. A tap returns io.EOF once the monitor stops.
+func (c *Capture) Read() ([]float32, error) {
+	if c.stream != nil {
+		if err := c.stream.Read(); err != nil {
+			return nil, err
+		}
+		return c.frame, nil
+	}
+
+	for len(c.pending) < len(c.frame) {
+		f, ok := <-c.tap.ch
+		if !ok {
+			if c.tap.overrun {
+				return nil, ErrCaptureOverrun
+			}
+			return nil, io.EOF
+		}
+		c.pending = append(c.pending, f...)
+	}
+
+	n := copy(c.frame, c.pending)
+	c.pending = c.pending[n:]
+
+	return c.frame, nil
+}
+
+func (c *Capture) Close() {
+	if c.stream != nil {
+		c.stream.Stop()
+		c.stream.Close()
+		return
+	}
+
+	c.rec.mu.Lock()
+	defer c.rec.mu.Unlock()
+	if _, ok := c.mon.taps[c.tap]; ok {
+		delete(c.mon.taps, c.tap)
+		close(c.tap.ch)
+	}
+}
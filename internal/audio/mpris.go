@@ -0,0 +1,110 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Media players that "pause" rules stop are reached over MPRIS on the
+// session bus.
+
+const (
+	mprisPrefix = "org.mpris.MediaPlayer2."
+	mprisPath   = "/org/mpris/MediaPlayer2"
+	mprisIface  = "org.mpris.MediaPlayer2.Player"
+)
+
+type mprisPlayer struct {
+	Name string // bus name, e.g. org.mpris.MediaPlayer2.spotify
+	PID  uint32
+}
+
+type mprisBus struct {
+	conn *dbus.Conn
+}
+
+func dialMPRIS(ctx context.Context) (*mprisBus, error) {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("connect to session bus: %w", err)
+	}
+	return &mprisBus{conn: conn}, nil
+}
+
+func (b *mprisBus) Close() error {
+	return b.conn.Close()
+}
+
+// players lists the MPRIS players on the bus.
+func (b *mprisBus) players(ctx context.Context) ([]mprisPlayer, error) {
+	var names []string
+	err := b.conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.ListNames", 0).Store(&names)
+	if err != nil {
+		return nil, fmt.Errorf("list bus names: %w", err)
+	}
+
+	var res []mprisPlayer
+	for _, name := range names {
+		if !strings.HasPrefix(name, mprisPrefix) {
+			continue
+		}
+		p := mprisPlayer{Name: name}
+		// without a pid the player can still be found by name
+		b.conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.GetConnectionUnixProcessID", 0, name).Store(&p.PID)
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// status is the PlaybackStatus of a player: Playing, Paused or Stopped.
+func (b *mprisBus) status(ctx context.Context, name string) (string, error) {
+	var v dbus.Variant
+	err := b.conn.Object(name, mprisPath).
+		CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, mprisIface, "PlaybackStatus").
+		Store(&v)
+	if err != nil {
+		return "", err
+	}
+	status, _ := v.Value().(string)
+	return status, nil
+}
+
+func (b *mprisBus) pause(ctx context.Context, name string) error {
+	return b.conn.Object(name, mprisPath).CallWithContext(ctx, mprisIface+".Pause", 0).Err
+}
+
+func (b *mprisBus) play(ctx context.Context, name string) error {
+	return b.conn.Object(name, mprisPath).CallWithContext(ctx, mprisIface+".Play", 0).Err
+}
+
+// playerFor finds the player s plays for: the one with its process id,
+// else one named after its binary or application, as in
+// org.mpris.MediaPlayer2.firefox.instance_1_42 for "firefox". It returns
+// "" for none.
+func playerFor(players []mprisPlayer, s streamInfo) string {
+	if pid, err := strconv.ParseUint(s.Props["application.process.id"], 10, 32); err == nil {
+		for _, p := range players {
+			if p.PID != 0 && p.PID == uint32(pid) {
+				return p.Name
+			}
+		}
+	}
+
+	names := []string{
+		strings.ToLower(s.Props["application.process.binary"]),
+		strings.ToLower(s.AppName),
+	}
+	for _, p := range players {
+		first, _, _ := strings.Cut(strings.TrimPrefix(p.Name, mprisPrefix), ".")
+		for _, name := range names {
+			if name != "" && strings.ToLower(first) == name {
+				return p.Name
+			}
+		}
+	}
+	return ""
+}
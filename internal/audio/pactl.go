@@ -0,0 +1,181 @@
+package audio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pactl is the fallback mixer, parsing what pactl prints.
+type pactl struct{}
+
+var (
+	// "front-left: 42598 /  65% / -11.23 dB"
+	channelVolumeRe = regexp.MustCompile(`(\d+)\s*/\s*\d+%`)
+	// `application.name = "Firefox"`
+	propertyRe = regexp.MustCompile(`^([\w.-]+) = "(.*)"$`)
+	// "Event 'new' on sink-input #42"
+	newStreamRe = regexp.MustCompile(`^Event 'new' on sink-input #(\d+)`)
+)
+
+func (pactl) Close() error { return nil }
+
+// pactlCommand runs pactl in the C locale; the parsing relies on its
+// English labels ("Sink Input #", "Volume:", "Event 'new'").
+func pactlCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "pactl", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd
+}
+
+func (pactl) sinkInputs(ctx context.Context) ([]streamInfo, error) {
+	out, err := pactlCommand(ctx, "list", "sink-inputs").Output()
+	if err != nil {
+		return nil, fmt.Errorf("pactl list sink-inputs: %w", err)
+	}
+
+	return parseSinkInputs(string(out)), nil
+}
+
+// parseSinkInputs reads the output of pactl list sink-inputs.
+func parseSinkInputs(text string) []streamInfo {
+	parts := strings.Split(text, "Sink Input #")
+	if len(parts) <= 1 {
+		return nil
+	}
+
+	var res []streamInfo
+
+	for i := 1; i < len(parts); i++ {
+		block := parts[i]
+
+		newline := strings.IndexByte(block, '\n')
+		if newline <= 0 {
+			continue
+		}
+
+		idStr := strings.TrimSpace(block[:newline])
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+
+		body := block[newline+1:]
+
+		lines := strings.Split(body, "\n")
+
+		s := streamInfo{
+			ID:    id,
+			Props: make(map[string]string),
+		}
+
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+
+			switch {
+			case strings.HasPrefix(line, "Sink:"):
+				s.Sink, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Sink:")))
+
+			case strings.HasPrefix(line, "Mute:"):
+				s.Muted = strings.TrimSpace(strings.TrimPrefix(line, "Mute:")) == "yes"
+
+			case strings.HasPrefix(line, "Volume:") && s.Volume == nil:
+				for _, m := range channelVolumeRe.FindAllStringSubmatch(line, -1) {
+					v, err := strconv.ParseUint(m[1], 10, 32)
+					if err == nil {
+						s.Volume = append(s.Volume, uint32(v))
+					}
+				}
+
+			default:
+				if m := propertyRe.FindStringSubmatch(line); m != nil {
+					if _, ok := s.Props[m[1]]; !ok {
+						s.Props[m[1]] = m[2]
+					}
+				}
+			}
+		}
+
+		s.AppName = s.Props["application.name"]
+		s.Fixed = len(s.Volume) == 0
+
+		res = append(res, s)
+	}
+
+	return res
+}
+
+func (pactl) setSinkInputVolume(ctx context.Context, id int, vol Volume) error {
+	args := []string{"set-sink-input-volume", strconv.Itoa(id)}
+	for _, v := range vol {
+		// plain integers are raw volumes
+		args = append(args, strconv.FormatUint(uint64(v), 10))
+	}
+
+	return pactlCommand(ctx, args...).Run()
+}
+
+func (pactl) setSinkInputMute(ctx context.Context, id int, mute bool) error {
+	flag := "0"
+	if mute {
+		flag = "1"
+	}
+
+	return pactlCommand(ctx, "set-sink-input-mute", strconv.Itoa(id), flag).Run()
+}
+
+func (pactl) sinkName(ctx context.Context, index int) (string, error) {
+	out, err := pactlCommand(ctx, "list", "short", "sinks").Output()
+	if err != nil {
+		return "", fmt.Errorf("pactl list sinks: %w", err)
+	}
+
+	// "57	alsa_output.pci-0000_00_1f.3.analog-stereo	PipeWire	s32le 2ch 48000Hz	RUNNING"
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == strconv.Itoa(index) {
+			return fields[1], nil
+		}
+	}
+
+	return "", fmt.Errorf("no sink #%d", index)
+}
+
+func (pactl) newStreams(ctx context.Context) (<-chan int, error) {
+	cmd := pactlCommand(ctx, "subscribe")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("pactl subscribe: %w", err)
+	}
+
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		defer cmd.Wait()
+
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			m := newStreamRe.FindStringSubmatch(sc.Text())
+			if m == nil {
+				continue
+			}
+			id, _ := strconv.Atoi(m[1])
+
+			select {
+			case ch <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
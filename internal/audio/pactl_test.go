@@ -0,0 +1,121 @@
+package audio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseSinkInputs(t *testing.T) {
+	out, err := os.ReadFile("testdata/pactl-sink-inputs.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := parseSinkInputs(string(out))
+
+	want := []struct {
+		id, sink int
+		volume   Volume
+		muted    bool
+		app      string
+		props    map[string]string // a few of them
+	}{
+		{
+			id: 87, sink: 57,
+			volume: Volume{65536, 32768},
+			app:    "Firefox",
+			props: map[string]string{
+				"application.process.binary": "firefox",
+				"media.role":                 "music",
+				"module-stream-restore.id":   "sink-input-by-application-name:Firefox",
+			},
+		},
+		{
+			id: 91, sink: 58,
+			volume: Volume{42598},
+			muted:  true,
+			app:    "Telegram Desktop",
+			props: map[string]string{
+				"media.name": `Telegram \"notify\" sound`,
+				"media.role": "event",
+			},
+		},
+		{
+			id: 95, sink: 57,
+			volume: Volume{52429, 52429},
+			app:    "vox",
+			props:  map[string]string{"application.process.binary": "vox-daemon"},
+		},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("%d streams, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.ID != w.id || g.Sink != w.sink || g.Muted != w.muted || g.AppName != w.app {
+			t.Errorf("stream %d = %+v", w.id, g)
+		}
+		if !slices.Equal(g.Volume, w.volume) || g.Fixed {
+			t.Errorf("stream %d volume %v, fixed %v", w.id, g.Volume, g.Fixed)
+		}
+		for k, v := range w.props {
+			if g.Props[k] != v {
+				t.Errorf("stream %d %s = %q, want %q", w.id, k, g.Props[k], v)
+			}
+		}
+		// the Format line is not a property
+		if _, ok := g.Props["format.rate"]; ok {
+			t.Errorf("stream %d has the format as properties", w.id)
+		}
+	}
+}
+
+// pactl is run in the C locale whatever the user's is; a stub on PATH
+// only answers to that.
+func TestPactlLocale(t *testing.T) {
+	capture, err := filepath.Abs("testdata/pactl-sink-inputs.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	stub := "#!/bin/sh\n[ \"$LC_ALL\" = C ] || exit 1\nexec cat " + capture + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "pactl"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+
+	streams, err := pactl{}.sinkInputs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 3 {
+		t.Errorf("%d streams, want 3", len(streams))
+	}
+}
+
+func TestParseSinkInputsEmpty(t *testing.T) {
+	if got := parseSinkInputs(""); got != nil {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestNewStreamRe(t *testing.T) {
+	for line, want := range map[string]string{
+		"Event 'new' on sink-input #42":    "42",
+		"Event 'change' on sink-input #42": "",
+		"Event 'new' on sink #3":           "",
+		"Event 'new' on source-output #7":  "",
+	} {
+		var got string
+		if m := newStreamRe.FindStringSubmatch(line); m != nil {
+			got = m[1]
+		}
+		if got != want {
+			t.Errorf("%q: %q, want %q", line, got, want)
+		}
+	}
+}
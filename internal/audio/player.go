@@ -0,0 +1,217 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+var (
+	// ErrHushed is returned by Play for a clip cut off or dropped by Hush.
+	ErrHushed = errors.New("playback hushed")
+	// ErrPlayerClosed is returned by Play once the player is closed.
+	ErrPlayerClosed = errors.New("player closed")
+)
+
+// playFrames is the output buffer, about 50ms at the usual speech rates;
+// cancellation takes effect between buffers.
+const playFrames = 1024
+
+// Player plays signed 16-bit little-endian mono PCM through PortAudio.
+// Clips queue up and play one after another, each on a stream opened at
+// its own sample rate.
+type Player struct {
+	mu     sync.Mutex
+	queue  []*clip
+	active *clip
+	closed bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+type clip struct {
+	ctx  context.Context
+	src  io.Reader
+	rate int
+
+	hush chan struct{} // closed by Hush while playing
+	err  chan error    // the outcome, buffered
+}
+
+func NewPlayer() *Player {
+	p := &Player{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Play queues src and blocks until it has been played, ctx is done or
+// Hush cut it off. src is not read after Play returns.
+func (p *Player) Play(ctx context.Context, src io.Reader, rate int) error {
+	c := &clip{
+		ctx:  ctx,
+		src:  src,
+		rate: rate,
+		hush: make(chan struct{}),
+		err:  make(chan error, 1),
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPlayerClosed
+	}
+	p.queue = append(p.queue, c)
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case err := <-c.err:
+		return err
+	case <-ctx.Done():
+	}
+
+	// still waiting its turn: leave the queue; playing: the player
+	// notices ctx within a buffer
+	p.mu.Lock()
+	for i, q := range p.queue {
+		if q == c {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	p.mu.Unlock()
+	return <-c.err
+}
+
+// Hush stops the clip playing and drops the queued ones. It reports
+// whether there was anything to stop.
+func (p *Player) Hush() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	busy := p.active != nil || len(p.queue) > 0
+	for _, c := range p.queue {
+		c.err <- ErrHushed
+	}
+	p.queue = nil
+	if p.active != nil {
+		select {
+		case <-p.active.hush:
+		default:
+			close(p.active.hush)
+		}
+	}
+	return busy
+}
+
+// Playing reports whether a clip is playing or queued.
+func (p *Player) Playing() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active != nil || len(p.queue) > 0
+}
+
+// Close hushes the player and stops it; later Plays fail.
+func (p *Player) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	p.Hush()
+	close(p.done)
+}
+
+func (p *Player) run() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.wake:
+		}
+
+		for {
+			p.mu.Lock()
+			if len(p.queue) == 0 {
+				p.mu.Unlock()
+				break
+			}
+			c := p.queue[0]
+			p.queue = p.queue[1:]
+			p.active = c
+			p.mu.Unlock()
+
+			err := play(c)
+
+			p.mu.Lock()
+			p.active = nil
+			p.mu.Unlock()
+			c.err <- err
+		}
+	}
+}
+
+func play(c *clip) error {
+	buf := make([]int16, playFrames)
+	raw := make([]byte, 2*playFrames)
+
+	stream, err := portaudio.OpenDefaultStream(0, 1, float64(c.rate), len(buf), buf)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if err := stream.Start(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-c.hush:
+			stream.Abort()
+			return ErrHushed
+		case <-c.ctx.Done():
+			stream.Abort()
+			return c.ctx.Err()
+		default:
+		}
+
+		n, rerr := io.ReadFull(c.src, raw)
+		if n > 0 {
+			for i := range buf {
+				buf[i] = 0
+				if 2*i+1 < n {
+					buf[i] = int16(binary.LittleEndian.Uint16(raw[2*i:]))
+				}
+			}
+			if err := stream.Write(); err != nil && !errors.Is(err, portaudio.OutputUnderflowed) {
+				stream.Abort()
+				return err
+			}
+		}
+
+		switch {
+		case rerr == io.EOF || rerr == io.ErrUnexpectedEOF:
+			// Stop lets the buffered tail play out
+			return stream.Stop()
+		case rerr != nil:
+			stream.Abort()
+			return rerr
+		}
+	}
+}
@@ -0,0 +1,677 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A minimal client of the PulseAudio native protocol, enough to list sink
+// inputs, set their volume and mute, and hear of new ones. pipewire-pulse
+// speaks it too.
+
+// pulse commands, from pulsecore/native-common.h
+const (
+	pulseError                = 0
+	pulseReply                = 2
+	pulseAuth                 = 8
+	pulseSetClientName        = 9
+	pulseGetSinkInfo          = 21
+	pulseGetSinkInputInfoList = 30
+	pulseSubscribe            = 35
+	pulseSetSinkInputVolume   = 37
+	pulseSubscribeEvent       = 66
+	pulseSetSinkInputMute     = 69
+)
+
+// subscriptions, from pulse/def.h
+const (
+	pulseMaskSinkInput     = 0x0004 // subscription mask
+	pulseFacilitySinkInput = 0x0002
+	pulseFacilityMask      = 0x000F
+	pulseEventNew          = 0x0000
+	pulseEventTypeMask     = 0x0030
+)
+
+const (
+	pulseVersion   = 32 // what we speak; the server may go lower
+	pulseMinServer = 13 // client proplists
+	pulseCookieLen = 256
+	pulseCommand   = 0xFFFFFFFF // channel of control packets
+
+	pulseTimeout = 2 * time.Second // per request without a ctx deadline
+)
+
+// tagstruct tags
+const (
+	tagString     = 't'
+	tagStringNull = 'N'
+	tagU32        = 'L'
+	tagU8         = 'B'
+	tagU64        = 'R'
+	tagS64        = 'r'
+	tagSampleSpec = 'a'
+	tagArbitrary  = 'x'
+	tagTrue       = '1'
+	tagFalse      = '0'
+	tagTimeval    = 'T'
+	tagUsec       = 'U'
+	tagChannelMap = 'm'
+	tagCVolume    = 'v'
+	tagPropList   = 'P'
+	tagVolume     = 'V'
+	tagFormatInfo = 'f'
+)
+
+// PulseError is an error code the server answered a request with.
+type PulseError struct {
+	Command uint32
+	Code    uint32
+}
+
+func (e *PulseError) Error() string {
+	return fmt.Sprintf("pulse: command %d failed with error %d", e.Command, e.Code)
+}
+
+type pulseClient struct {
+	conn    net.Conn
+	version uint32
+	tag     uint32
+}
+
+// pulseAddr finds the server like libpulse does: $PULSE_SERVER, else the
+// native socket in the user's runtime directory.
+func pulseAddr() (network, addr string) {
+	for _, s := range strings.Fields(os.Getenv("PULSE_SERVER")) {
+		// a leading {machine-id} limits an entry to one host
+		if strings.HasPrefix(s, "{") {
+			if i := strings.IndexByte(s, '}'); i >= 0 {
+				s = s[i+1:]
+			}
+		}
+		switch {
+		case strings.HasPrefix(s, "unix:"):
+			return "unix", strings.TrimPrefix(s, "unix:")
+		case strings.HasPrefix(s, "/"):
+			return "unix", s
+		case strings.HasPrefix(s, "tcp:"), strings.HasPrefix(s, "tcp4:"), strings.HasPrefix(s, "tcp6:"):
+			_, host, _ := strings.Cut(s, ":")
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				host = net.JoinHostPort(strings.Trim(host, "[]"), "4713")
+			}
+			return "tcp", host
+		}
+	}
+
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return "unix", filepath.Join(dir, "pulse", "native")
+}
+
+// pulseCookie reads the auth cookie. pipewire-pulse and servers that
+// trust their socket ignore it, so a missing one is sent as zeros.
+func pulseCookie() []byte {
+	var paths []string
+	if p := os.Getenv("PULSE_COOKIE"); p != "" {
+		paths = append(paths, p)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		config := os.Getenv("XDG_CONFIG_HOME")
+		if config == "" {
+			config = filepath.Join(home, ".config")
+		}
+		paths = append(paths, filepath.Join(config, "pulse", "cookie"), filepath.Join(home, ".pulse-cookie"))
+	}
+
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err == nil && len(b) >= pulseCookieLen {
+			return b[:pulseCookieLen]
+		}
+	}
+	return make([]byte, pulseCookieLen)
+}
+
+func dialPulse(ctx context.Context) (*pulseClient, error) {
+	network, addr := pulseAddr()
+	return dialPulseAddr(ctx, network, addr)
+}
+
+// dialPulseAddr connects, authenticates and names the client.
+func dialPulseAddr(ctx context.Context, network, addr string) (*pulseClient, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &pulseClient{conn: conn}
+
+	var auth tagWriter
+	auth.u32(pulseVersion)
+	auth.arbitrary(pulseCookie())
+	r, err := c.request(ctx, pulseAuth, auth.Bytes())
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("pulse auth: %w", err)
+	}
+	server, err := r.u32()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("pulse auth: %w", err)
+	}
+	c.version = min(server&0xFFFF, pulseVersion)
+	if c.version < pulseMinServer {
+		conn.Close()
+		return nil, fmt.Errorf("pulse server protocol %d too old", c.version)
+	}
+
+	var name tagWriter
+	name.proplist(map[string]string{
+		"application.name":           "VOX",
+		"application.process.binary": filepath.Base(os.Args[0]),
+	})
+	if _, err := c.request(ctx, pulseSetClientName, name.Bytes()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("pulse client name: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *pulseClient) Close() error {
+	return c.conn.Close()
+}
+
+// sinkInputs lists the playback streams.
+func (c *pulseClient) sinkInputs(ctx context.Context) ([]streamInfo, error) {
+	r, err := c.request(ctx, pulseGetSinkInputInfoList, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []streamInfo
+	for !r.empty() {
+		s, err := c.sinkInput(r)
+		if err != nil {
+			return nil, fmt.Errorf("sink input info: %w", err)
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// sinkInput reads one entry of the info list, whose fields grew with the
+// protocol version.
+func (c *pulseClient) sinkInput(r *tagReader) (streamInfo, error) {
+	var (
+		s   streamInfo
+		err error
+	)
+	read := func(f func() error) {
+		if err == nil {
+			err = f()
+		}
+	}
+	u32 := func(p *uint32) func() error {
+		return func() (e error) { *p, e = r.u32(); return }
+	}
+
+	var index, sink uint32
+	read(u32(&index))
+	read(r.skipString) // name
+	read(r.skipU32)    // owner module
+	read(r.skipU32)    // client
+	read(u32(&sink))
+	read(r.skipSampleSpec)
+	read(r.skipChannelMap)
+	read(func() (e error) { s.Volume, e = r.cvolume(); return })
+	read(r.skipUsec)   // buffer latency
+	read(r.skipUsec)   // sink latency
+	read(r.skipString) // resample method
+	read(r.skipString) // driver
+	if c.version >= 11 {
+		read(func() (e error) { s.Muted, e = r.boolean(); return })
+	}
+	if c.version >= 13 {
+		read(func() (e error) { s.Props, e = r.proplist(); return })
+	}
+	if c.version >= 19 {
+		read(r.skipBool) // corked
+	}
+	writable := true
+	if c.version >= 20 {
+		var hasVolume bool
+		read(func() (e error) { hasVolume, e = r.boolean(); return })
+		read(func() (e error) { writable, e = r.boolean(); return })
+		writable = writable && hasVolume
+	}
+	if c.version >= 21 {
+		read(r.skipFormatInfo)
+	}
+	if err != nil {
+		return streamInfo{}, err
+	}
+
+	s.ID = int(index)
+	s.Sink = int(sink)
+	s.AppName = s.Props["application.name"]
+	s.Fixed = !writable
+	return s, nil
+}
+
+func (c *pulseClient) setSinkInputVolume(ctx context.Context, id int, vol Volume) error {
+	var w tagWriter
+	w.u32(uint32(id))
+	w.cvolume(vol)
+	_, err := c.request(ctx, pulseSetSinkInputVolume, w.Bytes())
+	return err
+}
+
+func (c *pulseClient) setSinkInputMute(ctx context.Context, id int, mute bool) error {
+	var w tagWriter
+	w.u32(uint32(id))
+	w.boolean(mute)
+	_, err := c.request(ctx, pulseSetSinkInputMute, w.Bytes())
+	return err
+}
+
+// sinkName looks up the name of a sink, such as
+// alsa_output.pci-0000_00_1f.3.analog-stereo.
+func (c *pulseClient) sinkName(ctx context.Context, index int) (string, error) {
+	var w tagWriter
+	w.u32(uint32(index))
+	w.WriteByte(tagStringNull)
+	r, err := c.request(ctx, pulseGetSinkInfo, w.Bytes())
+	if err != nil {
+		return "", err
+	}
+	// the name follows the index, the rest is of no interest
+	if err := r.skipU32(); err != nil {
+		return "", err
+	}
+	return r.str()
+}
+
+// newStreams subscribes to sink input events and sends the index of every
+// new one until ctx is done or the connection fails. The client serves
+// nothing else afterwards.
+func (c *pulseClient) newStreams(ctx context.Context) (<-chan int, error) {
+	var w tagWriter
+	w.u32(pulseMaskSinkInput)
+	if _, err := c.request(ctx, pulseSubscribe, w.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := c.conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan int)
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Unix(1, 0)) })
+	go func() {
+		defer close(ch)
+		defer stop()
+
+		for {
+			channel, payload, err := c.readPacket()
+			if err != nil {
+				return
+			}
+			if channel != pulseCommand {
+				continue
+			}
+
+			r := &tagReader{b: payload}
+			cmd, err := r.u32()
+			if err != nil || cmd != pulseSubscribeEvent {
+				continue
+			}
+			r.u32() // tag
+			event, err := r.u32()
+			if err != nil {
+				continue
+			}
+			index, err := r.u32()
+			if err != nil {
+				continue
+			}
+			if event&pulseFacilityMask != pulseFacilitySinkInput || event&pulseEventTypeMask != pulseEventNew {
+				continue
+			}
+
+			select {
+			case ch <- int(index):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// request sends a command and waits for its reply, skipping anything else
+// the server sends meanwhile.
+func (c *pulseClient) request(ctx context.Context, command uint32, args []byte) (*tagReader, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(pulseTimeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	c.tag++
+	tag := c.tag
+
+	var w tagWriter
+	w.u32(command)
+	w.u32(tag)
+	w.Write(args)
+	if err := c.writePacket(w.Bytes()); err != nil {
+		return nil, c.ctxErr(ctx, err)
+	}
+
+	for {
+		channel, payload, err := c.readPacket()
+		if err != nil {
+			return nil, c.ctxErr(ctx, err)
+		}
+		if channel != pulseCommand {
+			continue // audio data, not ours
+		}
+
+		r := &tagReader{b: payload}
+		cmd, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		rtag, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		if rtag != tag || (cmd != pulseReply && cmd != pulseError) {
+			continue
+		}
+
+		if cmd == pulseError {
+			code, _ := r.u32()
+			return nil, &PulseError{Command: command, Code: code}
+		}
+		return r, nil
+	}
+}
+
+func (c *pulseClient) ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// writePacket frames payload with the 20 byte descriptor: length,
+// channel, offset (2 words) and flags.
+func (c *pulseClient) writePacket(payload []byte) error {
+	pkt := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint32(pkt[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(pkt[4:], pulseCommand)
+	pkt = append(pkt, payload...)
+	_, err := c.conn.Write(pkt)
+	return err
+}
+
+func (c *pulseClient) readPacket() (uint32, []byte, error) {
+	var desc [20]byte
+	if _, err := io.ReadFull(c.conn, desc[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(desc[0:])
+	channel := binary.BigEndian.Uint32(desc[4:])
+	if n > 16<<20 {
+		return 0, nil, fmt.Errorf("pulse: oversized packet of %d bytes", n)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		return 0, nil, err
+	}
+	return channel, payload, nil
+}
+
+// tagWriter builds a tagstruct: every value is preceded by its type tag,
+// integers are big endian.
+type tagWriter struct {
+	bytes.Buffer
+}
+
+func (w *tagWriter) u32(v uint32) {
+	w.WriteByte(tagU32)
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *tagWriter) str(s string) {
+	w.WriteByte(tagString)
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+func (w *tagWriter) boolean(b bool) {
+	if b {
+		w.WriteByte(tagTrue)
+	} else {
+		w.WriteByte(tagFalse)
+	}
+}
+
+func (w *tagWriter) arbitrary(b []byte) {
+	w.WriteByte(tagArbitrary)
+	binary.Write(w, binary.BigEndian, uint32(len(b)))
+	w.Write(b)
+}
+
+func (w *tagWriter) cvolume(vol Volume) {
+	w.WriteByte(tagCVolume)
+	w.WriteByte(byte(len(vol)))
+	for _, v := range vol {
+		binary.Write(w, binary.BigEndian, v)
+	}
+}
+
+func (w *tagWriter) proplist(props map[string]string) {
+	w.WriteByte(tagPropList)
+	for k, v := range props {
+		w.str(k)
+		value := append([]byte(v), 0)
+		w.u32(uint32(len(value)))
+		w.arbitrary(value)
+	}
+	w.WriteByte(tagStringNull)
+}
+
+var errTagStruct = errors.New("malformed tagstruct")
+
+type tagReader struct {
+	b []byte
+}
+
+func (r *tagReader) empty() bool { return len(r.b) == 0 }
+
+func (r *tagReader) next(n int) ([]byte, error) {
+	if len(r.b) < n {
+		return nil, errTagStruct
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b, nil
+}
+
+func (r *tagReader) expect(tag byte) error {
+	b, err := r.next(1)
+	if err != nil {
+		return err
+	}
+	if b[0] != tag {
+		return fmt.Errorf("%w: want tag %q, got %q", errTagStruct, tag, b[0])
+	}
+	return nil
+}
+
+func (r *tagReader) u32() (uint32, error) {
+	if err := r.expect(tagU32); err != nil {
+		return 0, err
+	}
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// str reads a string; a null string is "".
+func (r *tagReader) str() (string, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return "", err
+	}
+	switch b[0] {
+	case tagStringNull:
+		return "", nil
+	case tagString:
+	default:
+		return "", fmt.Errorf("%w: want a string, got %q", errTagStruct, b[0])
+	}
+	i := bytes.IndexByte(r.b, 0)
+	if i < 0 {
+		return "", errTagStruct
+	}
+	s := string(r.b[:i])
+	r.b = r.b[i+1:]
+	return s, nil
+}
+
+func (r *tagReader) boolean() (bool, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return false, err
+	}
+	switch b[0] {
+	case tagTrue:
+		return true, nil
+	case tagFalse:
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: want a boolean, got %q", errTagStruct, b[0])
+}
+
+func (r *tagReader) arbitrary() ([]byte, error) {
+	if err := r.expect(tagArbitrary); err != nil {
+		return nil, err
+	}
+	b, err := r.next(4)
+	if err != nil {
+		return nil, err
+	}
+	return r.next(int(binary.BigEndian.Uint32(b)))
+}
+
+func (r *tagReader) cvolume() (Volume, error) {
+	if err := r.expect(tagCVolume); err != nil {
+		return nil, err
+	}
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := r.next(4 * int(b[0]))
+	if err != nil {
+		return nil, err
+	}
+	vol := make(Volume, b[0])
+	for i := range vol {
+		vol[i] = binary.BigEndian.Uint32(raw[4*i:])
+	}
+	return vol, nil
+}
+
+func (r *tagReader) proplist() (map[string]string, error) {
+	if err := r.expect(tagPropList); err != nil {
+		return nil, err
+	}
+	props := map[string]string{}
+	for {
+		if len(r.b) > 0 && r.b[0] == tagStringNull {
+			r.b = r.b[1:]
+			return props, nil
+		}
+		key, err := r.str()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.u32(); err != nil { // length, repeated by the arbitrary
+			return nil, err
+		}
+		value, err := r.arbitrary()
+		if err != nil {
+			return nil, err
+		}
+		props[key] = string(bytes.TrimRight(value, "\x00"))
+	}
+}
+
+func (r *tagReader) skip(tag byte, n int) error {
+	if err := r.expect(tag); err != nil {
+		return err
+	}
+	_, err := r.next(n)
+	return err
+}
+
+func (r *tagReader) skipString() error {
+	_, err := r.str()
+	return err
+}
+
+func (r *tagReader) skipU32() error  { return r.skip(tagU32, 4) }
+func (r *tagReader) skipUsec() error { return r.skip(tagUsec, 8) }
+
+func (r *tagReader) skipBool() error {
+	_, err := r.boolean()
+	return err
+}
+
+func (r *tagReader) skipSampleSpec() error {
+	return r.skip(tagSampleSpec, 6) // format, channels, rate
+}
+
+func (r *tagReader) skipChannelMap() error {
+	if err := r.expect(tagChannelMap); err != nil {
+		return err
+	}
+	b, err := r.next(1)
+	if err != nil {
+		return err
+	}
+	_, err = r.next(int(b[0]))
+	return err
+}
+
+func (r *tagReader) skipFormatInfo() error {
+	if err := r.expect(tagFormatInfo); err != nil {
+		return err
+	}
+	if err := r.skip(tagU8, 1); err != nil { // encoding
+		return err
+	}
+	_, err := r.proplist()
+	return err
+}
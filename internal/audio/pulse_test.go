@@ -0,0 +1,560 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePulse is a PulseAudio server speaking just what pulseClient asks
+// for, at a given protocol version.
+type fakePulse struct {
+	t       *testing.T
+	version uint32
+	path    string
+
+	mu      sync.Mutex
+	streams []fakeStream
+	volumes map[uint32][]Volume // every volume set, by stream
+	mutes   map[uint32]bool
+	auth    struct {
+		version uint32
+		cookie  []byte
+	}
+	client map[string]string
+	subs   []*fakeConn
+}
+
+type fakeStream struct {
+	index, sink uint32
+	volume      Volume
+	muted       bool
+	fixed       bool
+	props       map[string]string
+}
+
+type fakeConn struct {
+	mu sync.Mutex
+	c  *pulseClient
+}
+
+func (fc *fakeConn) send(w *tagWriter) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.c.writePacket(w.Bytes())
+}
+
+func startFakePulse(t *testing.T, version uint32, streams ...fakeStream) *fakePulse {
+	t.Helper()
+	f := &fakePulse{
+		t:       t,
+		version: version,
+		path:    filepath.Join(t.TempDir(), "native"),
+		streams: streams,
+		volumes: map[uint32][]Volume{},
+		mutes:   map[uint32]bool{},
+	}
+	ln, err := net.Listen("unix", f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		conns []net.Conn
+		cmu   sync.Mutex
+	)
+	t.Cleanup(func() {
+		ln.Close()
+		cmu.Lock()
+		for _, c := range conns {
+			c.Close()
+		}
+		cmu.Unlock()
+		wg.Wait()
+	})
+	wg.Go(func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			cmu.Lock()
+			conns = append(conns, conn)
+			cmu.Unlock()
+			wg.Go(func() { f.serve(&fakeConn{c: &pulseClient{conn: conn}}) })
+		}
+	})
+
+	t.Setenv("PULSE_SERVER", "unix:"+f.path)
+	return f
+}
+
+func (f *fakePulse) serve(fc *fakeConn) {
+	for {
+		_, payload, err := fc.c.readPacket()
+		if err != nil {
+			return
+		}
+		r := &tagReader{b: payload}
+		cmd, _ := r.u32()
+		tag, _ := r.u32()
+
+		var w tagWriter
+		code := f.handle(fc, cmd, r, &w)
+
+		var out tagWriter
+		if code != 0 {
+			out.u32(pulseError)
+			out.u32(tag)
+			out.u32(code)
+		} else {
+			out.u32(pulseReply)
+			out.u32(tag)
+			out.Write(w.Bytes())
+		}
+		if err := fc.send(&out); err != nil {
+			return
+		}
+	}
+}
+
+// pulse error codes, from pulse/def.h
+const (
+	errPulseCommand  = 2
+	errPulseNoEntity = 5
+)
+
+// handle answers one command into w, or returns an error code.
+func (f *fakePulse) handle(fc *fakeConn, cmd uint32, r *tagReader, w *tagWriter) uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch cmd {
+	case pulseAuth:
+		f.auth.version, _ = r.u32()
+		f.auth.cookie, _ = r.arbitrary()
+		w.u32(f.version)
+
+	case pulseSetClientName:
+		f.client, _ = r.proplist()
+		w.u32(1) // client index
+
+	case pulseGetSinkInputInfoList:
+		for _, s := range f.streams {
+			f.writeSinkInput(w, s)
+		}
+
+	case pulseGetSinkInfo:
+		index, _ := r.u32()
+		w.u32(index)
+		w.str(fmt.Sprintf("sink%d", index))
+
+	case pulseSetSinkInputVolume:
+		index, _ := r.u32()
+		vol, err := r.cvolume()
+		i := f.stream(index)
+		if err != nil || i < 0 {
+			return errPulseNoEntity
+		}
+		f.streams[i].volume = vol
+		f.volumes[index] = append(f.volumes[index], vol)
+
+	case pulseSetSinkInputMute:
+		index, _ := r.u32()
+		mute, err := r.boolean()
+		i := f.stream(index)
+		if err != nil || i < 0 {
+			return errPulseNoEntity
+		}
+		f.streams[i].muted = mute
+		f.mutes[index] = mute
+
+	case pulseSubscribe:
+		mask, _ := r.u32()
+		if mask&pulseMaskSinkInput != 0 {
+			f.subs = append(f.subs, fc)
+		}
+
+	default:
+		return errPulseCommand
+	}
+	return 0
+}
+
+func (f *fakePulse) stream(index uint32) int {
+	return slices.IndexFunc(f.streams, func(s fakeStream) bool { return s.index == index })
+}
+
+// writeSinkInput writes s in the field order of f.version, see
+// pulseClient.sinkInput.
+func (f *fakePulse) writeSinkInput(w *tagWriter, s fakeStream) {
+	w.u32(s.index)
+	w.str(s.props["media.name"])
+	w.u32(0xFFFFFFFF) // owner module
+	w.u32(7)          // client
+	w.u32(s.sink)
+	w.WriteByte(tagSampleSpec)
+	w.Write([]byte{3, byte(len(s.volume)), 0, 0, 0xBB, 0x80}) // s16le, 48000
+	w.WriteByte(tagChannelMap)
+	w.WriteByte(byte(len(s.volume)))
+	for i := range s.volume {
+		w.WriteByte(byte(i + 1))
+	}
+	w.cvolume(s.volume)
+	for range 2 { // buffer and sink latency
+		w.WriteByte(tagUsec)
+		w.Write(make([]byte, 8))
+	}
+	w.str("speex-float-1")
+	w.str("protocol-native.c")
+	if f.version >= 11 {
+		w.boolean(s.muted)
+	}
+	if f.version >= 13 {
+		w.proplist(s.props)
+	}
+	if f.version >= 19 {
+		w.boolean(false) // corked
+	}
+	if f.version >= 20 {
+		w.boolean(true) // has volume
+		w.boolean(!s.fixed)
+	}
+	if f.version >= 21 {
+		w.WriteByte(tagFormatInfo)
+		w.WriteByte(tagU8)
+		w.WriteByte(1) // PCM
+		w.proplist(nil)
+	}
+}
+
+// add starts a stream and tells the subscribers.
+func (f *fakePulse) add(s fakeStream) {
+	f.mu.Lock()
+	f.streams = append(f.streams, s)
+	f.mu.Unlock()
+	f.event(pulseFacilitySinkInput|pulseEventNew, s.index)
+}
+
+func (f *fakePulse) event(event, index uint32) {
+	f.mu.Lock()
+	subs := slices.Clone(f.subs)
+	f.mu.Unlock()
+
+	for _, fc := range subs {
+		var w tagWriter
+		w.u32(pulseSubscribeEvent)
+		w.u32(0xFFFFFFFF)
+		w.u32(event)
+		w.u32(index)
+		fc.send(&w)
+	}
+}
+
+// waitFor polls until cond holds under f.mu.
+func (f *fakePulse) waitFor(what string, cond func() bool) {
+	f.t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		f.mu.Lock()
+		ok := cond()
+		f.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			f.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (f *fakePulse) volume(index uint32) Volume {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.streams[f.stream(index)].volume
+}
+
+func dialFake(t *testing.T, f *fakePulse) *pulseClient {
+	t.Helper()
+	c, err := dialPulseAddr(context.Background(), "unix", f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestTagStructRoundTrip(t *testing.T) {
+	props := map[string]string{"application.name": "Firefox", "media.role": "music", "empty": ""}
+	vol := Volume{VolumeNorm, VolumeNorm / 2, 0}
+
+	var w tagWriter
+	w.u32(0xDEADBEEF)
+	w.str("привет")
+	w.str("")
+	w.WriteByte(tagStringNull)
+	w.boolean(true)
+	w.boolean(false)
+	w.arbitrary([]byte{0, 1, 2})
+	w.cvolume(vol)
+	w.proplist(props)
+
+	r := &tagReader{b: w.Bytes()}
+	if v, err := r.u32(); err != nil || v != 0xDEADBEEF {
+		t.Errorf("u32 = %x, %v", v, err)
+	}
+	for _, want := range []string{"привет", "", ""} {
+		if s, err := r.str(); err != nil || s != want {
+			t.Errorf("str = %q, %v, want %q", s, err, want)
+		}
+	}
+	for _, want := range []bool{true, false} {
+		if b, err := r.boolean(); err != nil || b != want {
+			t.Errorf("boolean = %v, %v", b, err)
+		}
+	}
+	if b, err := r.arbitrary(); err != nil || !bytes.Equal(b, []byte{0, 1, 2}) {
+		t.Errorf("arbitrary = %v, %v", b, err)
+	}
+	if v, err := r.cvolume(); err != nil || !slices.Equal(v, vol) {
+		t.Errorf("cvolume = %v, %v", v, err)
+	}
+	if p, err := r.proplist(); err != nil || !maps.Equal(p, props) {
+		t.Errorf("proplist = %v, %v", p, err)
+	}
+	if !r.empty() {
+		t.Errorf("%d bytes left", len(r.b))
+	}
+}
+
+func TestTagStructMalformed(t *testing.T) {
+	var w tagWriter
+	w.str("not a number")
+	if _, err := (&tagReader{b: w.Bytes()}).u32(); !errors.Is(err, errTagStruct) {
+		t.Errorf("u32 of a string: %v", err)
+	}
+
+	w.Reset()
+	w.cvolume(Volume{1, 2})
+	if _, err := (&tagReader{b: w.Bytes()[:6]}).cvolume(); !errors.Is(err, errTagStruct) {
+		t.Errorf("truncated cvolume: %v", err)
+	}
+
+	w.Reset()
+	w.WriteByte(tagString)
+	w.WriteString("unterminated")
+	if _, err := (&tagReader{b: w.Bytes()}).str(); !errors.Is(err, errTagStruct) {
+		t.Errorf("unterminated string: %v", err)
+	}
+}
+
+func TestPulseHandshake(t *testing.T) {
+	cookie := bytes.Repeat([]byte{0xA5}, pulseCookieLen)
+	path := filepath.Join(t.TempDir(), "cookie")
+	if err := os.WriteFile(path, cookie, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PULSE_COOKIE", path)
+
+	// the server's flags in the upper bits do not count
+	f := startFakePulse(t, 35|0x80000000)
+	c := dialFake(t, f)
+
+	if c.version != pulseVersion {
+		t.Errorf("version = %d, want ours, %d", c.version, pulseVersion)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.auth.version != pulseVersion || !bytes.Equal(f.auth.cookie, cookie) {
+		t.Errorf("auth with version %d and cookie % x...", f.auth.version, f.auth.cookie[:4])
+	}
+	if f.client["application.name"] != "VOX" || f.client["application.process.binary"] == "" {
+		t.Errorf("client name %v", f.client)
+	}
+
+}
+
+func TestPulseHandshakeOlderServer(t *testing.T) {
+	f := startFakePulse(t, 21)
+	if c := dialFake(t, f); c.version != 21 {
+		t.Errorf("version = %d with a version 21 server", c.version)
+	}
+}
+
+func TestPulseHandshakeTooOld(t *testing.T) {
+	f := startFakePulse(t, pulseMinServer-1)
+	if _, err := dialPulseAddr(context.Background(), "unix", f.path); err == nil {
+		t.Error("connected to a version 12 server")
+	}
+}
+
+func TestPulseSinkInputs(t *testing.T) {
+	streams := []fakeStream{
+		{
+			index: 3, sink: 1,
+			volume: Volume{VolumeNorm, VolumeNorm / 2},
+			props:  map[string]string{"application.name": "Firefox", "media.name": "Video", "application.process.binary": "firefox"},
+		},
+		{
+			index: 8, sink: 0,
+			volume: Volume{VolumeNorm / 4},
+			muted:  true,
+			fixed:  true,
+			props:  map[string]string{"application.name": "mpv"},
+		},
+	}
+
+	for _, version := range []uint32{13, 19, 20, 21, pulseVersion} {
+		t.Run(fmt.Sprint("v", version), func(t *testing.T) {
+			f := startFakePulse(t, version, streams...)
+			got, err := dialFake(t, f).sinkInputs(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(streams) {
+				t.Fatalf("%d streams, want %d", len(got), len(streams))
+			}
+
+			for i, s := range streams {
+				g := got[i]
+				if g.ID != int(s.index) || g.Sink != int(s.sink) || !slices.Equal(g.Volume, s.volume) || g.Muted != s.muted {
+					t.Errorf("stream %d = %+v", s.index, g)
+				}
+				if g.AppName != s.props["application.name"] || !maps.Equal(g.Props, s.props) {
+					t.Errorf("stream %d props %v", s.index, g.Props)
+				}
+				// before version 20 the server does not say
+				if want := s.fixed && version >= 20; g.Fixed != want {
+					t.Errorf("stream %d Fixed = %v, want %v", s.index, g.Fixed, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPulseSetSinkInput(t *testing.T) {
+	f := startFakePulse(t, pulseVersion, fakeStream{index: 3, volume: Volume{VolumeNorm, VolumeNorm}})
+	c := dialFake(t, f)
+	ctx := context.Background()
+
+	if err := c.setSinkInputVolume(ctx, 3, Volume{VolumeNorm / 2, VolumeNorm / 4}); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.volume(3); !slices.Equal(v, Volume{VolumeNorm / 2, VolumeNorm / 4}) {
+		t.Errorf("volume = %v", v)
+	}
+	if err := c.setSinkInputMute(ctx, 3, true); err != nil {
+		t.Errorf("mute: %v", err)
+	}
+	f.waitFor("the mute", func() bool { return f.mutes[3] })
+
+	var perr *PulseError
+	if err := c.setSinkInputVolume(ctx, 42, Volume{0}); !errors.As(err, &perr) || perr.Code != errPulseNoEntity {
+		t.Errorf("volume of a missing stream: %v", err)
+	}
+	// the connection is still usable after an error
+	if name, err := c.sinkName(ctx, 1); err != nil || name != "sink1" {
+		t.Errorf("sinkName = %q, %v", name, err)
+	}
+}
+
+func TestPulseNewStreams(t *testing.T) {
+	f := startFakePulse(t, pulseVersion)
+	c := dialFake(t, f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ids, err := c.newStreams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		facilitySink = 0x0000
+		eventChange  = 0x0010
+		eventRemove  = 0x0020
+	)
+	f.event(pulseFacilitySinkInput|eventChange, 4)
+	f.event(facilitySink|pulseEventNew, 5)
+	f.event(pulseFacilitySinkInput|eventRemove, 6)
+	f.event(pulseFacilitySinkInput|pulseEventNew, 7)
+
+	select {
+	case id := <-ids:
+		if id != 7 {
+			t.Errorf("new stream %d, want 7", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no new stream")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ids:
+		if ok {
+			t.Error("more streams after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed on cancel")
+	}
+}
+
+// Ducking through the native client scales every channel alike, ducks
+// streams that start meanwhile and puts them all back.
+func TestDuckerNative(t *testing.T) {
+	f := startFakePulse(t, pulseVersion,
+		fakeStream{index: 1, volume: Volume{VolumeNorm, VolumeNorm / 2}, props: map[string]string{"application.name": "Firefox"}},
+		fakeStream{index: 2, volume: Volume{VolumeNorm}, props: map[string]string{"application.name": "vox"}},
+		fakeStream{index: 3, volume: Volume{VolumeNorm}, fixed: true, props: map[string]string{"application.name": "Game"}},
+	)
+	state := filepath.Join(t.TempDir(), "duck.json")
+	d := NewDucker(DuckerConfig{Self: []string{"vox"}, Backend: BackendNative, StatePath: state})
+	ctx := context.Background()
+
+	if err := d.DuckOthers(ctx, 0.25, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.volume(1); !slices.Equal(v, Volume{VolumeNorm / 4, VolumeNorm / 8}) {
+		t.Errorf("ducked volume %v, want the balance kept", v)
+	}
+	f.mu.Lock()
+	touched := len(f.volumes[2]) + len(f.volumes[3])
+	f.mu.Unlock()
+	if touched > 0 {
+		t.Error("our own or a fixed stream was changed")
+	}
+	if _, err := os.Stat(state); err != nil {
+		t.Errorf("no state file while ducked: %v", err)
+	}
+
+	f.waitFor("the watcher", func() bool { return len(f.subs) > 0 })
+	f.add(fakeStream{index: 4, volume: Volume{VolumeNorm / 2, VolumeNorm / 2}, props: map[string]string{"application.name": "mpv"}})
+	f.waitFor("the new stream to be ducked", func() bool { return len(f.volumes[4]) > 0 })
+	if v := f.volume(4); !slices.Equal(v, Volume{VolumeNorm / 8, VolumeNorm / 8}) {
+		t.Errorf("new stream volume %v", v)
+	}
+
+	if err := d.UnduckOthers(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.volume(1); !slices.Equal(v, Volume{VolumeNorm, VolumeNorm / 2}) {
+		t.Errorf("restored volume %v", v)
+	}
+	if v := f.volume(4); !slices.Equal(v, Volume{VolumeNorm / 2, VolumeNorm / 2}) {
+		t.Errorf("restored new stream volume %v", v)
+	}
+	if _, err := os.Stat(state); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("state file left after unducking: %v", err)
+	}
+}
@@ -2,13 +2,18 @@ package audio
 
 import (
 	"errors"
+	"io"
 	"math"
+	"sync"
 	"time"
 
 	"github.com/gordonklaus/portaudio"
 )
 
-type Recorder struct{}
+type Recorder struct {
+	mu  sync.Mutex
+	mon *monitor
+}
 
 func NewRecorder() *Recorder { return &Recorder{} }
 
@@ -17,96 +22,44 @@ func (r *Recorder) Init() error {
 }
 
 func (r *Recorder) Close() {
+	r.StopMonitor()
 	portaudio.Terminate()
 }
 
 func (r *Recorder) RecordAuto() ([]float32, error) {
-	const (
-		sampleRate       = 16000
-		frameSize        = 320                             // 20ms
-		silenceThreshRMS = 0.015                           // tune if needed
-		silenceDuration  = 600 * float32(time.Millisecond) // 600ms
-		maxLengthSeconds = 10
-	)
-
-	buf := make([]float32, frameSize)
-	out := make([]float32, 0, sampleRate*3)
-
-	stream, err := portaudio.OpenDefaultStream(1, 0, sampleRate, len(buf), buf)
-	if err != nil {
-		return nil, err
-	}
-	defer stream.Close()
+	return r.RecordVAD(nil, 10*time.Second, NewVAD(DefaultVADConfig()))
+}
 
-	if err := stream.Start(); err != nil {
+func (r *Recorder) RecordUntil(stop <-chan struct{}, maxDur time.Duration) ([]float32, error) {
+	c, err := r.Capture(1024)
+	if err != nil {
 		return nil, err
 	}
-	defer stream.Stop()
-
-	var (
-		speaking      bool
-		silenceFrames int
-	)
-
-	maxFrames := maxLengthSeconds * sampleRate / frameSize
+	defer c.Close()
 
-	for i := 0; i < maxFrames; i++ {
-		if err := stream.Read(); err != nil {
-			return nil, err
-		}
+	return c.RecordUntil(stop, maxDur)
+}
 
-		rms := frameRMS(buf)
-
-		if rms > silenceThreshRMS {
-			speaking = true
-			silenceFrames = 0
-			out = append(out, buf...)
-		} else {
-			if speaking {
-				silenceFrames++
-				if float32(silenceFrames)*20 >= silenceDuration {
-					break
-				}
-				out = append(out, buf...)
-			}
-		}
+// RecordVAD records until vad hears the end of an utterance, stop is closed
+// or maxDur passes. If no speech was detected, a manual stop or timeout
+// returns everything recorded, while the VAD giving up yields ErrNoSpeech.
+func (r *Recorder) RecordVAD(stop <-chan struct{}, maxDur time.Duration, vad *VAD) ([]float32, error) {
+	c, err := r.Capture(vad.FrameSize())
+	if err != nil {
+		return nil, err
 	}
+	defer c.Close()
 
-	return out, nil
+	return c.RecordVAD(stop, maxDur, vad)
 }
 
-func (r *Recorder) RecordUntil(stop <-chan struct{}, maxDur time.Duration) ([]float32, error) {
-	const (
-		sampleRate = 16000
-	)
-
+func (c *Capture) RecordUntil(stop <-chan struct{}, maxDur time.Duration) ([]float32, error) {
 	if maxDur <= 0 {
 		maxDur = 15 * time.Second
 	}
 
-	const frameSize = 1024
-
-	buf := make([]float32, frameSize)
-
-	stream, err := portaudio.OpenDefaultStream(
-		1, // in
-		0, // no out
-		float64(sampleRate),
-		len(buf),
-		buf,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer stream.Close()
-
-	if err := stream.Start(); err != nil {
-		return nil, err
-	}
-	defer stream.Stop()
-
 	deadline := time.Now().Add(maxDur)
-	out := make([]float32, 0, int(float64(sampleRate)*maxDur.Seconds()))
+	out := make([]float32, 0, int(float64(SampleRate)*maxDur.Seconds()))
 
 	for {
 		// проверяем таймаут
@@ -122,11 +75,18 @@ func (r *Recorder) RecordUntil(stop <-chan struct{}, maxDur time.Duration) ([]fl
 		default:
 		}
 
-		if err := stream.Read(); err != nil {
+		buf, err := c.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
 			return nil, err
 		}
 
 		out = append(out, buf...)
+		if c.sink != nil {
+			c.sink.Write(buf)
+		}
 	}
 
 	if len(out) == 0 {
@@ -136,6 +96,69 @@ func (r *Recorder) RecordUntil(stop <-chan struct{}, maxDur time.Duration) ([]fl
 	return out, nil
 }
 
+func (c *Capture) RecordVAD(stop <-chan struct{}, maxDur time.Duration, vad *VAD) ([]float32, error) {
+	if maxDur <= 0 {
+		maxDur = 15 * time.Second
+	}
+
+	deadline := time.Now().Add(maxDur)
+	raw := make([]float32, 0, int(float64(SampleRate)*maxDur.Seconds()))
+
+	// the sink follows the VAD's kept speech, not the raw input
+	sent := 0
+	feed := func() {
+		if c.sink == nil {
+			return
+		}
+		speech := vad.Speech()
+		if len(speech) < sent {
+			c.sink.Reset()
+			sent = 0
+		}
+		if len(speech) > sent {
+			c.sink.Write(speech[sent:])
+			sent = len(speech)
+		}
+	}
+
+loop:
+	for time.Now().Before(deadline) {
+		select {
+		case <-stop:
+			break loop
+		default:
+		}
+
+		buf, err := c.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, buf...)
+
+		ev := vad.Process(buf)
+		feed()
+
+		switch ev {
+		case VADSpeechEnd:
+			return vad.Speech(), nil
+		case VADNoSpeech:
+			return nil, ErrNoSpeech
+		}
+	}
+
+	if vad.Heard() {
+		return vad.Speech(), nil
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("no audio recorded")
+	}
+
+	return raw, nil
+}
+
 func frameRMS(f []float32) float64 {
 	var s float64
 	for _, x := range f {
@@ -143,3 +166,19 @@ func frameRMS(f []float32) float64 {
 	}
 	return math.Sqrt(s / float64(len(f)))
 }
+
+// PeakRMS is the loudest frame-sized RMS in pcm, a cheap "is anyone
+// talking" gate.
+func PeakRMS(pcm []float32, frame int) float64 {
+	if frame <= 0 {
+		frame = monitorFrame
+	}
+
+	var peak float64
+	for i := 0; i+frame <= len(pcm); i += frame {
+		if rms := frameRMS(pcm[i : i+frame]); rms > peak {
+			peak = rms
+		}
+	}
+	return peak
+}
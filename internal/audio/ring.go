@@ -0,0 +1,63 @@
+package audio
+
+// Ring keeps the most recent samples of a stream. Positions are absolute
+// sample counts since the ring was created, so callers can refer to a
+// moment in the stream even after the ring has wrapped.
+type Ring struct {
+	buf []float32
+	pos int64 // total samples written
+}
+
+func NewRing(capacity int) *Ring {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Ring{buf: make([]float32, capacity)}
+}
+
+func (r *Ring) Write(p []float32) {
+	n := len(r.buf)
+	if len(p) > n {
+		r.pos += int64(len(p) - n)
+		p = p[len(p)-n:]
+	}
+
+	at := int(r.pos % int64(n))
+	c := copy(r.buf[at:], p)
+	copy(r.buf, p[c:])
+	r.pos += int64(len(p))
+}
+
+// Pos is the absolute position just past the newest sample.
+func (r *Ring) Pos() int64 {
+	return r.pos
+}
+
+// From copies everything still held from absolute position pos onwards and
+// returns the position of the first copied sample, which is later than pos
+// when that audio has already been overwritten.
+func (r *Ring) From(pos int64) ([]float32, int64) {
+	oldest := r.pos - int64(len(r.buf))
+	if oldest < 0 {
+		oldest = 0
+	}
+	if pos < oldest {
+		pos = oldest
+	}
+	if pos >= r.pos {
+		return nil, r.pos
+	}
+
+	n := len(r.buf)
+	out := make([]float32, r.pos-pos)
+	at := int(pos % int64(n))
+	c := copy(out, r.buf[at:])
+	copy(out[c:], r.buf)
+
+	return out, pos
+}
+
+// Last copies up to n of the newest samples.
+func (r *Ring) Last(n int) ([]float32, int64) {
+	return r.From(r.pos - int64(n))
+}
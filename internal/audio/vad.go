@@ -0,0 +1,241 @@
+package audio
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNoSpeech = errors.New("no speech detected")
+
+type VADConfig struct {
+	SampleRate      int
+	FrameSize       int           // samples per decision frame
+	ThresholdRatio  float64       // speech when RMS exceeds floor * ratio
+	MinThreshold    float64       // absolute RMS below which nothing is speech
+	ReleaseRatio    float64       // fraction of the threshold that keeps speech going
+	FloorAdapt      float64       // EMA weight of a silent frame in the noise floor
+	Hangover        time.Duration // trailing silence that ends an utterance
+	PreRoll         time.Duration // audio kept from before the onset
+	MinSpeech       time.Duration // shorter bursts are dropped as noise
+	NoSpeechTimeout time.Duration // 0 = wait for speech forever
+}
+
+func DefaultVADConfig() VADConfig {
+	return VADConfig{
+		SampleRate:      16000,
+		FrameSize:       320, // 20ms
+		ThresholdRatio:  3.0,
+		MinThreshold:    0.01,
+		ReleaseRatio:    0.7,
+		FloorAdapt:      0.05,
+		Hangover:        700 * time.Millisecond,
+		PreRoll:         300 * time.Millisecond,
+		MinSpeech:       250 * time.Millisecond,
+		NoSpeechTimeout: 5 * time.Second,
+	}
+}
+
+type VADEvent int
+
+const (
+	VADNone VADEvent = iota
+	VADSpeechStart
+	VADSpeechEnd
+	VADNoSpeech
+)
+
+func (e VADEvent) String() string {
+	switch e {
+	case VADSpeechStart:
+		return "speech_start"
+	case VADSpeechEnd:
+		return "speech_end"
+	case VADNoSpeech:
+		return "no_speech"
+	default:
+		return "none"
+	}
+}
+
+// VADDecision is one entry of the decision timeline kept for debugging.
+type VADDecision struct {
+	At        time.Duration `json:"at"`
+	Event     string        `json:"event"`
+	RMS       float64       `json:"rms"`
+	Floor     float64       `json:"floor"`
+	Threshold float64       `json:"threshold"`
+}
+
+type vadState int
+
+const (
+	vadSilence vadState = iota
+	vadSpeech
+	vadDone
+)
+
+// VAD is an energy based endpointer with an adaptive noise floor. Feed it
+// consecutive frames; it keeps the utterance plus PreRoll of lead-in audio.
+type VAD struct {
+	cfg VADConfig
+
+	state   vadState
+	elapsed time.Duration
+	floor   float64
+
+	preRoll  []float32
+	out      []float32
+	speech   time.Duration
+	silence  time.Duration
+	heard    bool
+	timeline []VADDecision
+}
+
+func NewVAD(cfg VADConfig) *VAD {
+	def := DefaultVADConfig()
+	if cfg.SampleRate <= 0 {
+		cfg.SampleRate = def.SampleRate
+	}
+	if cfg.FrameSize <= 0 {
+		cfg.FrameSize = def.FrameSize
+	}
+	if cfg.ThresholdRatio <= 1 {
+		cfg.ThresholdRatio = def.ThresholdRatio
+	}
+	if cfg.ReleaseRatio <= 0 || cfg.ReleaseRatio > 1 {
+		cfg.ReleaseRatio = def.ReleaseRatio
+	}
+	if cfg.FloorAdapt <= 0 || cfg.FloorAdapt > 1 {
+		cfg.FloorAdapt = def.FloorAdapt
+	}
+
+	return &VAD{
+		cfg:   cfg,
+		floor: -1,
+	}
+}
+
+func (v *VAD) FrameSize() int {
+	return v.cfg.FrameSize
+}
+
+// Process consumes a single frame and reports a state change, if any.
+func (v *VAD) Process(frame []float32) VADEvent {
+	if v.state == vadDone || len(frame) == 0 {
+		return VADNone
+	}
+
+	rms := frameRMS(frame)
+	if v.floor < 0 {
+		// the first frame may already be speech or the trigger beep; a
+		// floor seeded from it would put the threshold out of reach
+		v.floor = rms
+		if v.cfg.MinThreshold > 0 {
+			v.floor = min(rms, v.cfg.MinThreshold)
+		}
+	}
+
+	fd := time.Duration(len(frame)) * time.Second / time.Duration(v.cfg.SampleRate)
+	v.elapsed += fd
+
+	thr := v.threshold()
+
+	switch v.state {
+	case vadSilence:
+		if rms >= thr {
+			v.state = vadSpeech
+			v.heard = true
+			v.speech = fd
+			v.silence = 0
+			v.out = append(append(v.out[:0], v.preRoll...), frame...)
+			v.decide(VADSpeechStart.String(), rms, thr)
+			return VADSpeechStart
+		}
+
+		v.floor += (rms - v.floor) * v.cfg.FloorAdapt
+		v.pushPreRoll(frame)
+
+		if v.cfg.NoSpeechTimeout > 0 && v.elapsed >= v.cfg.NoSpeechTimeout {
+			v.state = vadDone
+			v.decide(VADNoSpeech.String(), rms, thr)
+			return VADNoSpeech
+		}
+
+	case vadSpeech:
+		v.out = append(v.out, frame...)
+
+		if rms >= thr*v.cfg.ReleaseRatio {
+			v.speech += fd + v.silence
+			v.silence = 0
+			return VADNone
+		}
+
+		v.silence += fd
+		if v.silence < v.cfg.Hangover {
+			return VADNone
+		}
+
+		if v.speech < v.cfg.MinSpeech {
+			// too short to be an utterance: a click or a cough
+			v.decide("discard", rms, thr)
+			v.state = vadSilence
+			v.heard = false
+			v.out = v.out[:0]
+			v.preRoll = v.preRoll[:0]
+			return VADNone
+		}
+
+		v.state = vadDone
+		v.decide(VADSpeechEnd.String(), rms, thr)
+		return VADSpeechEnd
+	}
+
+	return VADNone
+}
+
+// Speech returns the captured utterance, or nil if none was heard.
+func (v *VAD) Speech() []float32 {
+	if !v.heard {
+		return nil
+	}
+	return v.out
+}
+
+// Heard reports whether an utterance onset has been detected.
+func (v *VAD) Heard() bool {
+	return v.heard
+}
+
+func (v *VAD) Timeline() []VADDecision {
+	return append([]VADDecision(nil), v.timeline...)
+}
+
+func (v *VAD) threshold() float64 {
+	thr := v.floor * v.cfg.ThresholdRatio
+	if thr < v.cfg.MinThreshold {
+		thr = v.cfg.MinThreshold
+	}
+	return thr
+}
+
+func (v *VAD) pushPreRoll(frame []float32) {
+	limit := int(v.cfg.PreRoll * time.Duration(v.cfg.SampleRate) / time.Second)
+	if limit <= 0 {
+		return
+	}
+
+	v.preRoll = append(v.preRoll, frame...)
+	if over := len(v.preRoll) - limit; over > 0 {
+		v.preRoll = append(v.preRoll[:0], v.preRoll[over:]...)
+	}
+}
+
+func (v *VAD) decide(event string, rms, thr float64) {
+	v.timeline = append(v.timeline, VADDecision{
+		At:        v.elapsed,
+		Event:     event,
+		RMS:       rms,
+		Floor:     v.floor,
+		Threshold: thr,
+	})
+}
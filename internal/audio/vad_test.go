@@ -0,0 +1,184 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+// segment is a stretch of constant-level audio; a constant signal's RMS
+// is its level.
+type segment struct {
+	level float32
+	dur   time.Duration
+}
+
+type vadEvent struct {
+	event VADEvent
+	at    time.Duration
+}
+
+// feed runs segments through v frame by frame and collects the events.
+func feed(v *VAD, segs ...segment) []vadEvent {
+	var (
+		events []vadEvent
+		at     time.Duration
+	)
+	frameDur := time.Duration(v.FrameSize()) * time.Second / time.Duration(v.cfg.SampleRate)
+	for _, s := range segs {
+		for n := time.Duration(0); n < s.dur; n += frameDur {
+			frame := make([]float32, v.FrameSize())
+			for i := range frame {
+				frame[i] = s.level
+			}
+			at += frameDur
+			if ev := v.Process(frame); ev != VADNone {
+				events = append(events, vadEvent{ev, at})
+			}
+		}
+	}
+	return events
+}
+
+func samples(d time.Duration) int {
+	return int(d * 16000 / time.Second)
+}
+
+const (
+	quiet  = 0.001
+	speech = 0.2
+)
+
+func TestVAD(t *testing.T) {
+	tests := []struct {
+		name   string
+		segs   []segment
+		events []vadEvent
+		heard  bool
+		length time.Duration // of Speech()
+	}{
+		{
+			name: "silence speech silence",
+			segs: []segment{{quiet, time.Second}, {speech, 600 * time.Millisecond}, {quiet, time.Second}},
+			events: []vadEvent{
+				{VADSpeechStart, 1020 * time.Millisecond},
+				{VADSpeechEnd, 2300 * time.Millisecond},
+			},
+			heard: true,
+			// pre-roll, speech and the hangover
+			length: 300*time.Millisecond + 600*time.Millisecond + 700*time.Millisecond,
+		},
+		{
+			name: "gap shorter than hangover",
+			segs: []segment{
+				{quiet, 400 * time.Millisecond},
+				{speech, 400 * time.Millisecond},
+				{quiet, 500 * time.Millisecond},
+				{speech, 400 * time.Millisecond},
+				{quiet, time.Second},
+			},
+			events: []vadEvent{
+				{VADSpeechStart, 420 * time.Millisecond},
+				{VADSpeechEnd, 2400 * time.Millisecond},
+			},
+			heard:  true,
+			length: 300*time.Millisecond + 1300*time.Millisecond + 700*time.Millisecond,
+		},
+		{
+			name:   "short pre-roll at the start",
+			segs:   []segment{{quiet, 100 * time.Millisecond}, {speech, 400 * time.Millisecond}, {quiet, time.Second}},
+			events: []vadEvent{{VADSpeechStart, 120 * time.Millisecond}, {VADSpeechEnd, 1200 * time.Millisecond}},
+			heard:  true,
+			length: 100*time.Millisecond + 400*time.Millisecond + 700*time.Millisecond,
+		},
+		{
+			name:   "burst below min speech",
+			segs:   []segment{{quiet, 400 * time.Millisecond}, {speech, 100 * time.Millisecond}, {quiet, time.Second}},
+			events: []vadEvent{{VADSpeechStart, 420 * time.Millisecond}},
+			heard:  false,
+		},
+		{
+			name:   "no speech timeout",
+			segs:   []segment{{quiet, 6 * time.Second}},
+			events: []vadEvent{{VADNoSpeech, 5 * time.Second}},
+			heard:  false,
+		},
+		{
+			name:   "speech from the first frame",
+			segs:   []segment{{speech, 600 * time.Millisecond}, {quiet, time.Second}},
+			events: []vadEvent{{VADSpeechStart, 20 * time.Millisecond}, {VADSpeechEnd, 1300 * time.Millisecond}},
+			heard:  true,
+			length: 600*time.Millisecond + 700*time.Millisecond,
+		},
+		{
+			name: "beep before speech",
+			segs: []segment{
+				{0.5, 100 * time.Millisecond},
+				{quiet, time.Second},
+				{speech, 600 * time.Millisecond},
+				{quiet, time.Second},
+			},
+			// the beep is too short and dropped
+			events: []vadEvent{
+				{VADSpeechStart, 20 * time.Millisecond},
+				{VADSpeechStart, 1120 * time.Millisecond},
+				{VADSpeechEnd, 2400 * time.Millisecond},
+			},
+			heard:  true,
+			length: 300*time.Millisecond + 600*time.Millisecond + 700*time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVAD(DefaultVADConfig())
+			events := feed(v, tt.segs...)
+
+			if len(events) != len(tt.events) {
+				t.Fatalf("events = %v, want %v", events, tt.events)
+			}
+			for i := range events {
+				if events[i] != tt.events[i] {
+					t.Errorf("event %d = %v, want %v", i, events[i], tt.events[i])
+				}
+			}
+			if v.Heard() != tt.heard {
+				t.Errorf("Heard() = %v, want %v", v.Heard(), tt.heard)
+			}
+			if got := len(v.Speech()); got != samples(tt.length) {
+				t.Errorf("len(Speech()) = %d, want %d", got, samples(tt.length))
+			}
+		})
+	}
+}
+
+func TestVADPreRollLength(t *testing.T) {
+	v := NewVAD(DefaultVADConfig())
+	feed(v, segment{quiet, 2 * time.Second}, segment{speech, 400 * time.Millisecond})
+
+	pcm := v.Speech()
+	pre := samples(v.cfg.PreRoll)
+	if len(pcm) < pre {
+		t.Fatalf("len(Speech()) = %d, want at least the pre-roll %d", len(pcm), pre)
+	}
+	for i, x := range pcm[:pre] {
+		if x != quiet {
+			t.Fatalf("sample %d = %v, want pre-roll silence", i, x)
+		}
+	}
+	if pcm[pre] != speech {
+		t.Errorf("sample %d = %v, want the onset right after the pre-roll", pre, pcm[pre])
+	}
+}
+
+func TestVADDiscardTimeline(t *testing.T) {
+	v := NewVAD(DefaultVADConfig())
+	feed(v, segment{quiet, 400 * time.Millisecond}, segment{speech, 100 * time.Millisecond}, segment{quiet, time.Second})
+
+	var discarded bool
+	for _, d := range v.Timeline() {
+		discarded = discarded || d.Event == "discard"
+	}
+	if !discarded {
+		t.Errorf("timeline %v has no discard", v.Timeline())
+	}
+}
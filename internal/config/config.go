@@ -0,0 +1,667 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration written as "400ms", "20s" etc. in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+type Config struct {
+	Log    LogConfig    `toml:"log" json:"log"`
+	Hub    HubConfig    `toml:"hub" json:"hub"`
+	Proxy  ProxyConfig  `toml:"proxy" json:"proxy"`
+	STT    STTConfig    `toml:"stt" json:"stt"`
+	TTS    TTSConfig    `toml:"tts" json:"tts"`
+	NLU    NLUConfig    `toml:"nlu" json:"nlu"`
+	Answer AnswerConfig `toml:"answer" json:"answer"`
+	Record RecordConfig `toml:"record" json:"record"`
+	VAD    VADConfig    `toml:"vad" json:"vad"`
+	Wake   WakeConfig   `toml:"wake" json:"wake"`
+	Duck   DuckConfig   `toml:"duck" json:"duck"`
+
+	Dialogue DialogueConfig `toml:"dialogue" json:"dialogue"`
+}
+
+type LogConfig struct {
+	Level string `toml:"level" json:"level"`
+}
+
+type HubConfig struct {
+	Url       string   `toml:"url" json:"url"`
+	Shard     string   `toml:"shard" json:"shard"`
+	Reconn    Duration `toml:"reconnect" json:"reconnect"`
+	ReconnMax Duration `toml:"reconnect_max" json:"reconnect_max"`
+	Ping      Duration `toml:"ping" json:"ping"`
+	Timeout   Duration `toml:"timeout" json:"timeout"`
+	Queue     int      `toml:"queue" json:"queue"`
+
+	Sequence bool `toml:"sequence" json:"sequence"`
+
+	// shard=ID pairs, e.g. "VERTEX=0F"; HexAddresses sends the IDs
+	Addresses    []string `toml:"addresses" json:"addresses"`
+	HexAddresses bool     `toml:"hex_addresses" json:"hex_addresses"`
+}
+
+var hexIDRe = regexp.MustCompile(`^[0-9A-Fa-f]{2}$`)
+
+// AddressMap parses Addresses into shard -> hex ID.
+func (h HubConfig) AddressMap() (map[string]string, error) {
+	m := make(map[string]string, len(h.Addresses))
+	for _, pair := range h.Addresses {
+		name, id, ok := strings.Cut(pair, "=")
+		name, id = strings.TrimSpace(name), strings.TrimSpace(id)
+		if !ok || name == "" || !hexIDRe.MatchString(id) {
+			return nil, fmt.Errorf("want shard=ID with a two-digit hex ID, got %q", pair)
+		}
+		m[name] = strings.ToUpper(id)
+	}
+	return m, nil
+}
+
+type ProxyConfig struct {
+	Addr string `toml:"addr" json:"addr"`
+}
+
+type STTConfig struct {
+	Model          string   `toml:"model" json:"model"`
+	Language       string   `toml:"language" json:"language"`
+	Threads        int      `toml:"threads" json:"threads"`
+	Timeout        Duration `toml:"timeout" json:"timeout"`
+	Streaming      bool     `toml:"streaming" json:"streaming"`
+	StreamInterval Duration `toml:"stream_interval" json:"stream_interval"`
+
+	// Backend is "local" (whisper.cpp) or "openai"; with Fallback a failed
+	// or timed out local run is retried with the openai backend.
+	Backend  string          `toml:"backend" json:"backend"`
+	Fallback bool            `toml:"fallback" json:"fallback"`
+	OpenAI   STTOpenAIConfig `toml:"openai" json:"openai"`
+}
+
+// STTOpenAIConfig points at an OpenAI-compatible /audio/transcriptions
+// endpoint, reached through the proxy.
+type STTOpenAIConfig struct {
+	Url     string   `toml:"url" json:"url"`
+	Model   string   `toml:"model" json:"model"`
+	Timeout Duration `toml:"timeout" json:"timeout"`
+}
+
+// TTSConfig picks the speech backend. Every backend has a default voice
+// and per-language ones, "lang=voice" pairs matched against the language
+// whisper detected.
+type TTSConfig struct {
+	Backend string          `toml:"backend" json:"backend"` // espeak, piper or openai
+	ESpeak  TTSESpeakConfig `toml:"espeak" json:"espeak"`
+	Piper   TTSPiperConfig  `toml:"piper" json:"piper"`
+	OpenAI  TTSOpenAIConfig `toml:"openai" json:"openai"`
+}
+
+type TTSESpeakConfig struct {
+	Voice  string   `toml:"voice" json:"voice"`
+	Voices []string `toml:"voices" json:"voices"`
+	Rate   int      `toml:"rate" json:"rate"`
+	Pitch  int      `toml:"pitch" json:"pitch"`
+}
+
+// TTSPiperConfig runs the piper CLI; voices are .onnx model paths.
+type TTSPiperConfig struct {
+	Binary string   `toml:"binary" json:"binary"`
+	Voice  string   `toml:"voice" json:"voice"`
+	Voices []string `toml:"voices" json:"voices"`
+}
+
+// TTSOpenAIConfig points at an OpenAI-compatible /audio/speech endpoint,
+// reached through the proxy.
+type TTSOpenAIConfig struct {
+	Url     string   `toml:"url" json:"url"`
+	Model   string   `toml:"model" json:"model"`
+	Voice   string   `toml:"voice" json:"voice"`
+	Voices  []string `toml:"voices" json:"voices"`
+	Timeout Duration `toml:"timeout" json:"timeout"`
+}
+
+// VoiceMap parses "lang=voice" pairs into lang -> voice.
+func VoiceMap(voices []string) (map[string]string, error) {
+	m := make(map[string]string, len(voices))
+	for _, pair := range voices {
+		lang, voice, ok := strings.Cut(pair, "=")
+		lang, voice = strings.ToLower(strings.TrimSpace(lang)), strings.TrimSpace(voice)
+		if !ok || lang == "" || voice == "" {
+			return nil, fmt.Errorf("want lang=voice, got %q", pair)
+		}
+		m[lang] = voice
+	}
+	return m, nil
+}
+
+type NLUConfig struct {
+	Model         string  `toml:"model" json:"model"`
+	Mode          string  `toml:"mode" json:"mode"`
+	MinConfidence float64 `toml:"min_confidence" json:"min_confidence"`
+	// Devices is the device registry file; empty uses the built-in one.
+	Devices string `toml:"devices" json:"devices"`
+}
+
+// AnswerConfig controls what VOX says back after a command; see
+// answer.Config.
+type AnswerConfig struct {
+	Mode    string   `toml:"mode" json:"mode"`
+	Model   string   `toml:"model" json:"model"`
+	Timeout Duration `toml:"timeout" json:"timeout"`
+}
+
+type RecordConfig struct {
+	MaxDuration Duration `toml:"max_duration" json:"max_duration"`
+}
+
+// VADConfig tunes voice activity endpointing; see audio.VADConfig.
+type VADConfig struct {
+	Enabled         bool     `toml:"enabled" json:"enabled"`
+	ThresholdRatio  float64  `toml:"threshold_ratio" json:"threshold_ratio"`
+	MinThreshold    float64  `toml:"min_threshold" json:"min_threshold"`
+	ReleaseRatio    float64  `toml:"release_ratio" json:"release_ratio"`
+	FloorAdapt      float64  `toml:"floor_adapt" json:"floor_adapt"`
+	Hangover        Duration `toml:"hangover" json:"hangover"`
+	PreRoll         Duration `toml:"pre_roll" json:"pre_roll"`
+	MinSpeech       Duration `toml:"min_speech" json:"min_speech"`
+	NoSpeechTimeout Duration `toml:"no_speech_timeout" json:"no_speech_timeout"`
+}
+
+type WakeConfig struct {
+	Enabled  bool     `toml:"enabled" json:"enabled"`
+	Phrases  []string `toml:"phrases" json:"phrases"`
+	Model    string   `toml:"model" json:"model"`
+	Language string   `toml:"language" json:"language"`
+	Threads  int      `toml:"threads" json:"threads"`
+	Window   Duration `toml:"window" json:"window"`
+	Hop      Duration `toml:"hop" json:"hop"`
+	MinRMS   float64  `toml:"min_rms" json:"min_rms"`
+}
+
+// DialogueConfig controls the conversation context and follow-up
+// questions; see dialogue.Config.
+type DialogueConfig struct {
+	Enabled      bool     `toml:"enabled" json:"enabled"`
+	Turns        int      `toml:"turns" json:"turns"`
+	TTL          Duration `toml:"ttl" json:"ttl"`
+	FollowUp     bool     `toml:"follow_up" json:"follow_up"`
+	MaxQuestions int      `toml:"max_questions" json:"max_questions"`
+}
+
+type DuckConfig struct {
+	Self      []string `toml:"self" json:"self"`
+	Factor    float64  `toml:"factor" json:"factor"`
+	Fade      Duration `toml:"fade" json:"fade"`
+	MinVolume int      `toml:"min_volume" json:"min_volume"`
+	Backend   string   `toml:"backend" json:"backend"`
+	State     string   `toml:"state" json:"state"` // "" = $XDG_STATE_HOME/vox/duck.json
+	// Rules pick what happens to each stream, see DuckRules; streams no
+	// rule matches are ducked.
+	Rules []string `toml:"rules" json:"rules"`
+}
+
+// DuckRule is one of duck.rules: "field:pattern=action", where field is
+// app, binary, role or sink, pattern a case-insensitive glob and action
+// duck, mute, ignore or pause. "duck:0.5" ducks by its own factor.
+type DuckRule struct {
+	Field   string
+	Pattern string
+	Action  string
+	Factor  float64 // 0 = duck.factor
+}
+
+var (
+	duckFields  = []string{"app", "binary", "role", "sink"}
+	duckActions = []string{"duck", "mute", "ignore", "pause"}
+)
+
+// DuckRules parses duck.rules in order; the first rule that matches a
+// stream wins.
+func DuckRules(rules []string) ([]DuckRule, error) {
+	res := make([]DuckRule, 0, len(rules))
+	for _, text := range rules {
+		match, action, ok := strings.Cut(text, "=")
+		field, pattern, ok2 := strings.Cut(match, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("want field:pattern=action, got %q", text)
+		}
+
+		r := DuckRule{
+			Field:   strings.ToLower(strings.TrimSpace(field)),
+			Pattern: strings.TrimSpace(pattern),
+			Action:  strings.ToLower(strings.TrimSpace(action)),
+		}
+		if !slices.Contains(duckFields, r.Field) {
+			return nil, fmt.Errorf("%q: field must be app, binary, role or sink", text)
+		}
+		if _, err := filepath.Match(r.Pattern, ""); err != nil || r.Pattern == "" {
+			return nil, fmt.Errorf("%q: bad pattern", text)
+		}
+		if name, factor, ok := strings.Cut(r.Action, ":"); ok && name == "duck" {
+			f, err := strconv.ParseFloat(factor, 64)
+			if err != nil || f <= 0 || f > 1 {
+				return nil, fmt.Errorf("%q: duck factor must be within (0, 1], mute for silence", text)
+			}
+			r.Action, r.Factor = name, f
+		}
+		if !slices.Contains(duckActions, r.Action) {
+			return nil, fmt.Errorf("%q: action must be duck, mute, ignore or pause", text)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func Default() Config {
+	return Config{
+		Log: LogConfig{
+			Level: "info",
+		},
+		Hub: HubConfig{
+			Url:       "ws://192.168.0.69:8092",
+			Shard:     "VOX",
+			Reconn:    Duration{time.Second},
+			ReconnMax: Duration{time.Minute},
+			Ping:      Duration{15 * time.Second},
+			Timeout:   Duration{3 * time.Second},
+			Queue:     64,
+		},
+		Proxy: ProxyConfig{
+			Addr: "127.0.0.1:8888",
+		},
+		STT: STTConfig{
+			Model:    "third_party/whisper.cpp/models/ggml-medium.bin",
+			Language: "auto",
+			Threads:  0,
+			Timeout:  Duration{30 * time.Second},
+
+			Streaming:      true,
+			StreamInterval: Duration{700 * time.Millisecond},
+
+			Backend:  "local",
+			Fallback: false,
+			OpenAI: STTOpenAIConfig{
+				Url:     "https://api.openai.com/v1",
+				Model:   "whisper-1",
+				Timeout: Duration{30 * time.Second},
+			},
+		},
+		TTS: TTSConfig{
+			Backend: "espeak",
+			ESpeak: TTSESpeakConfig{
+				Voice:  "ru",
+				Voices: []string{"en=en-us"},
+				Rate:   175,
+				Pitch:  50,
+			},
+			Piper: TTSPiperConfig{
+				Binary: "piper",
+				Voice:  "third_party/piper/ru_RU-irina-medium.onnx",
+			},
+			OpenAI: TTSOpenAIConfig{
+				Url:     "https://api.openai.com/v1",
+				Model:   "gpt-4o-mini-tts",
+				Voice:   "alloy",
+				Timeout: Duration{15 * time.Second},
+			},
+		},
+		NLU: NLUConfig{
+			Model:         "gpt-5-nano",
+			Mode:          "hybrid",
+			MinConfidence: 0.8,
+		},
+		Answer: AnswerConfig{
+			Mode:    "template",
+			Model:   "gpt-5-nano",
+			Timeout: Duration{5 * time.Second},
+		},
+		Record: RecordConfig{
+			MaxDuration: Duration{20 * time.Second},
+		},
+		VAD: VADConfig{
+			Enabled:         true,
+			ThresholdRatio:  3.0,
+			MinThreshold:    0.01,
+			ReleaseRatio:    0.7,
+			FloorAdapt:      0.05,
+			Hangover:        Duration{700 * time.Millisecond},
+			PreRoll:         Duration{300 * time.Millisecond},
+			MinSpeech:       Duration{250 * time.Millisecond},
+			NoSpeechTimeout: Duration{5 * time.Second},
+		},
+		Wake: WakeConfig{
+			Enabled:  false,
+			Phrases:  []string{"вокс", "vox"},
+			Model:    "third_party/whisper.cpp/models/ggml-base.bin",
+			Language: "ru",
+			Threads:  2,
+			Window:   Duration{2 * time.Second},
+			Hop:      Duration{500 * time.Millisecond},
+			MinRMS:   0.02,
+		},
+		Duck: DuckConfig{
+			Self:      []string{"MonolithVox"},
+			Factor:    0.3,
+			Fade:      Duration{400 * time.Millisecond},
+			MinVolume: 5,
+			Backend:   "auto",
+		},
+		Dialogue: DialogueConfig{
+			Enabled:      true,
+			Turns:        6,
+			TTL:          Duration{2 * time.Minute},
+			FollowUp:     true,
+			MaxQuestions: 2,
+		},
+	}
+}
+
+// Load reads path over the defaults. The format is picked by extension:
+// .toml, .json, or .yaml/.yml.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		md, err := toml.Decode(string(data), &cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+		if undec := md.Undecoded(); len(undec) > 0 {
+			return cfg, fmt.Errorf("%s: unknown key %q", path, undec[0].String())
+		}
+	case ".json":
+		var tree map[string]any
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&tree); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+		// go through Set so that errors name the dotted key
+		if err := cfg.applyTree("", tree); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		var tree map[string]any
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := cfg.applyTree("", tree); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return cfg, fmt.Errorf("%s: unsupported config format %q (want .toml, .json or .yaml)", path, ext)
+	}
+
+	return cfg, nil
+}
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// Validate reports every invalid setting, each naming its key.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(key, format string, args ...any) {
+		errs = append(errs, &KeyError{Key: key, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if !slices.Contains(logLevels, c.Log.Level) {
+		bad("log.level", "must be one of %s, got %q", strings.Join(logLevels, "|"), c.Log.Level)
+	}
+
+	if !slices.ContainsFunc([]string{"ws://", "wss://", "tcp://", "serial://"}, func(scheme string) bool {
+		return strings.HasPrefix(c.Hub.Url, scheme)
+	}) {
+		bad("hub.url", "must be a ws://, wss://, tcp:// or serial:// url, got %q", c.Hub.Url)
+	}
+	if c.Hub.Shard == "" {
+		bad("hub.shard", "must not be empty")
+	}
+	if c.Hub.Reconn.Duration < time.Second {
+		bad("hub.reconnect", "must be at least 1s, got %s", c.Hub.Reconn)
+	}
+	if c.Hub.ReconnMax.Duration < c.Hub.Reconn.Duration {
+		bad("hub.reconnect_max", "must not be below hub.reconnect (%s), got %s", c.Hub.Reconn, c.Hub.ReconnMax)
+	}
+	if c.Hub.Ping.Duration != 0 && c.Hub.Ping.Duration < time.Second {
+		bad("hub.ping", "must be 0 (off) or at least 1s, got %s", c.Hub.Ping)
+	}
+	if c.Hub.Queue < 1 {
+		bad("hub.queue", "must be at least 1, got %d", c.Hub.Queue)
+	}
+	if _, err := c.Hub.AddressMap(); err != nil {
+		bad("hub.addresses", "%v", err)
+	}
+	if c.Hub.Timeout.Duration < 0 {
+		bad("hub.timeout", "must not be negative, got %s", c.Hub.Timeout)
+	}
+
+	if c.Proxy.Addr == "" {
+		bad("proxy.addr", "must not be empty")
+	}
+
+	switch c.STT.Backend {
+	case "local", "openai":
+	default:
+		bad("stt.backend", "must be local or openai, got %q", c.STT.Backend)
+	}
+	if c.STT.Model == "" && c.STT.Backend == "local" {
+		bad("stt.model", "must not be empty")
+	}
+	if c.STT.Backend == "openai" || c.STT.Fallback {
+		if c.STT.OpenAI.Url == "" {
+			bad("stt.openai.url", "must not be empty")
+		}
+		if c.STT.OpenAI.Model == "" {
+			bad("stt.openai.model", "must not be empty")
+		}
+	}
+	if c.STT.OpenAI.Timeout.Duration < 0 {
+		bad("stt.openai.timeout", "must not be negative, got %s", c.STT.OpenAI.Timeout)
+	}
+	if c.STT.Language == "" {
+		bad("stt.language", "must not be empty (use \"auto\" to detect)")
+	}
+	if c.STT.Threads < 0 {
+		bad("stt.threads", "must not be negative, got %d", c.STT.Threads)
+	}
+	if c.STT.Timeout.Duration <= 0 {
+		bad("stt.timeout", "must be positive, got %s", c.STT.Timeout)
+	}
+
+	if c.STT.Streaming && c.STT.StreamInterval.Duration < 100*time.Millisecond {
+		bad("stt.stream_interval", "must be at least 100ms, got %s", c.STT.StreamInterval)
+	}
+
+	switch c.TTS.Backend {
+	case "espeak":
+		if c.TTS.ESpeak.Voice == "" {
+			bad("tts.espeak.voice", "must not be empty")
+		}
+	case "piper":
+		if c.TTS.Piper.Binary == "" {
+			bad("tts.piper.binary", "must not be empty")
+		}
+		if c.TTS.Piper.Voice == "" {
+			bad("tts.piper.voice", "must not be empty")
+		}
+	case "openai":
+		if c.TTS.OpenAI.Url == "" {
+			bad("tts.openai.url", "must not be empty")
+		}
+		if c.TTS.OpenAI.Model == "" {
+			bad("tts.openai.model", "must not be empty")
+		}
+		if c.TTS.OpenAI.Voice == "" {
+			bad("tts.openai.voice", "must not be empty")
+		}
+	default:
+		bad("tts.backend", "must be espeak, piper or openai, got %q", c.TTS.Backend)
+	}
+	if c.TTS.ESpeak.Rate < 80 || c.TTS.ESpeak.Rate > 450 {
+		bad("tts.espeak.rate", "must be within [80, 450], got %d", c.TTS.ESpeak.Rate)
+	}
+	if c.TTS.ESpeak.Pitch < 0 || c.TTS.ESpeak.Pitch > 100 {
+		bad("tts.espeak.pitch", "must be within [0, 100], got %d", c.TTS.ESpeak.Pitch)
+	}
+	if _, err := VoiceMap(c.TTS.ESpeak.Voices); err != nil {
+		bad("tts.espeak.voices", "%v", err)
+	}
+	if _, err := VoiceMap(c.TTS.Piper.Voices); err != nil {
+		bad("tts.piper.voices", "%v", err)
+	}
+	if _, err := VoiceMap(c.TTS.OpenAI.Voices); err != nil {
+		bad("tts.openai.voices", "%v", err)
+	}
+	if c.TTS.OpenAI.Timeout.Duration < 0 {
+		bad("tts.openai.timeout", "must not be negative, got %s", c.TTS.OpenAI.Timeout)
+	}
+
+	if c.NLU.Model == "" {
+		bad("nlu.model", "must not be empty")
+	}
+	switch c.NLU.Mode {
+	case "hybrid", "offline", "llm":
+	default:
+		bad("nlu.mode", "must be hybrid, offline or llm, got %q", c.NLU.Mode)
+	}
+	if c.NLU.MinConfidence < 0 || c.NLU.MinConfidence > 1 {
+		bad("nlu.min_confidence", "must be within [0, 1], got %g", c.NLU.MinConfidence)
+	}
+
+	switch c.Answer.Mode {
+	case "template", "llm", "off":
+	default:
+		bad("answer.mode", "must be template, llm or off, got %q", c.Answer.Mode)
+	}
+	if c.Answer.Mode == "llm" && c.Answer.Model == "" {
+		bad("answer.model", "must not be empty")
+	}
+	if c.Answer.Timeout.Duration < 0 {
+		bad("answer.timeout", "must not be negative, got %s", c.Answer.Timeout)
+	}
+
+	if c.Record.MaxDuration.Duration <= 0 {
+		bad("record.max_duration", "must be positive, got %s", c.Record.MaxDuration)
+	}
+
+	if c.VAD.ThresholdRatio <= 1 {
+		bad("vad.threshold_ratio", "must be greater than 1, got %v", c.VAD.ThresholdRatio)
+	}
+	if c.VAD.MinThreshold < 0 || c.VAD.MinThreshold >= 1 {
+		bad("vad.min_threshold", "must be within [0, 1), got %v", c.VAD.MinThreshold)
+	}
+	if c.VAD.ReleaseRatio <= 0 || c.VAD.ReleaseRatio > 1 {
+		bad("vad.release_ratio", "must be within (0, 1], got %v", c.VAD.ReleaseRatio)
+	}
+	if c.VAD.FloorAdapt <= 0 || c.VAD.FloorAdapt > 1 {
+		bad("vad.floor_adapt", "must be within (0, 1], got %v", c.VAD.FloorAdapt)
+	}
+	if c.VAD.Hangover.Duration <= 0 {
+		bad("vad.hangover", "must be positive, got %s", c.VAD.Hangover)
+	}
+	if c.VAD.PreRoll.Duration < 0 {
+		bad("vad.pre_roll", "must not be negative, got %s", c.VAD.PreRoll)
+	}
+	if c.VAD.MinSpeech.Duration < 0 {
+		bad("vad.min_speech", "must not be negative, got %s", c.VAD.MinSpeech)
+	}
+	if c.VAD.NoSpeechTimeout.Duration < 0 {
+		bad("vad.no_speech_timeout", "must not be negative, got %s", c.VAD.NoSpeechTimeout)
+	}
+
+	if len(c.Wake.Phrases) == 0 {
+		bad("wake.phrases", "must list at least one phrase")
+	}
+	if c.Wake.Model == "" {
+		bad("wake.model", "must not be empty")
+	}
+	if c.Wake.Language == "" {
+		bad("wake.language", "must not be empty (use \"auto\" to detect)")
+	}
+	if c.Wake.Threads < 0 {
+		bad("wake.threads", "must not be negative, got %d", c.Wake.Threads)
+	}
+	if c.Wake.Window.Duration < 500*time.Millisecond || c.Wake.Window.Duration > 10*time.Second {
+		bad("wake.window", "must be within [500ms, 10s], got %s", c.Wake.Window)
+	}
+	if c.Wake.Hop.Duration <= 0 || c.Wake.Hop.Duration > c.Wake.Window.Duration {
+		bad("wake.hop", "must be positive and not longer than wake.window, got %s", c.Wake.Hop)
+	}
+	if c.Wake.MinRMS < 0 || c.Wake.MinRMS >= 1 {
+		bad("wake.min_rms", "must be within [0, 1), got %v", c.Wake.MinRMS)
+	}
+
+	if c.Duck.Factor < 0 || c.Duck.Factor > 1 {
+		bad("duck.factor", "must be within [0, 1], got %v", c.Duck.Factor)
+	}
+	if c.Duck.Fade.Duration < 0 {
+		bad("duck.fade", "must not be negative, got %s", c.Duck.Fade)
+	}
+	if c.Duck.MinVolume < 0 || c.Duck.MinVolume > 150 {
+		bad("duck.min_volume", "must be within [0, 150], got %d", c.Duck.MinVolume)
+	}
+	switch c.Duck.Backend {
+	case "auto", "native", "pactl":
+	default:
+		bad("duck.backend", "must be auto, native or pactl, got %q", c.Duck.Backend)
+	}
+	if _, err := DuckRules(c.Duck.Rules); err != nil {
+		bad("duck.rules", "%v", err)
+	}
+
+	if c.Dialogue.Turns < 0 {
+		bad("dialogue.turns", "must not be negative, got %d", c.Dialogue.Turns)
+	}
+	if c.Dialogue.TTL.Duration <= 0 {
+		bad("dialogue.ttl", "must be positive, got %s", c.Dialogue.TTL)
+	}
+	if c.Dialogue.MaxQuestions < 0 {
+		bad("dialogue.max_questions", "must not be negative, got %d", c.Dialogue.MaxQuestions)
+	}
+
+	return errors.Join(errs...)
+}
+
+// KeyError is a problem with a single config key.
+type KeyError struct {
+	Key string
+	Msg string
+}
+
+func (e *KeyError) Error() string {
+	return e.Key + ": " + e.Msg
+}
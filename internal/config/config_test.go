@@ -0,0 +1,109 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// The same settings in every format, with list items holding commas.
+func TestLoadFormats(t *testing.T) {
+	files := map[string]string{
+		"vox.toml": `
+[duck]
+factor = 0.5
+rules = ["app=Firefox, Chromium:mute"]
+
+[wake]
+phrases = ["эй, вокс", "vox"]
+window = "3s"
+`,
+		"vox.json": `{
+  "duck": {"factor": 0.5, "rules": ["app=Firefox, Chromium:mute"]},
+  "wake": {"phrases": ["эй, вокс", "vox"], "window": "3s"}
+}`,
+		"vox.yaml": `
+duck:
+  factor: 0.5
+  rules:
+    - "app=Firefox, Chromium:mute"
+wake:
+  phrases: ["эй, вокс", vox]
+  window: 3s
+`,
+	}
+
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := Load(writeConfig(t, name, data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Duck.Factor != 0.5 || cfg.Wake.Window.Duration != 3*time.Second {
+				t.Errorf("duck.factor %v, wake.window %s", cfg.Duck.Factor, cfg.Wake.Window)
+			}
+			if want := []string{"эй, вокс", "vox"}; !slices.Equal(cfg.Wake.Phrases, want) {
+				t.Errorf("wake.phrases %q, want %q", cfg.Wake.Phrases, want)
+			}
+			if want := []string{"app=Firefox, Chromium:mute"}; !slices.Equal(cfg.Duck.Rules, want) {
+				t.Errorf("duck.rules %q, want %q", cfg.Duck.Rules, want)
+			}
+			// untouched keys keep their defaults
+			if cfg.Hub.Shard != Default().Hub.Shard {
+				t.Errorf("hub.shard %q", cfg.Hub.Shard)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name, data string
+		key        string // named by the KeyError, if any
+	}{
+		{"unknown.json", `{"duck": {"fator": 0.5}}`, "duck.fator"},
+		{"unknown.yml", "duck:\n  fator: 0.5\n", "duck.fator"},
+		{"list.yaml", "duck:\n  factor: [0.5]\n", "duck.factor"},
+		{"nested.yaml", "wake:\n  phrases: [[vox]]\n", "wake.phrases"},
+		{"type.json", `{"wake": {"threads": "two"}}`, "wake.threads"},
+		{"broken.yaml", "wake: [", ""},
+		{"vox.ini", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.name, tt.data))
+			if err == nil {
+				t.Fatal("loaded")
+			}
+			var ke *KeyError
+			if tt.key != "" && (!errors.As(err, &ke) || ke.Key != tt.key) {
+				t.Errorf("err = %v, want one about %s", err, tt.key)
+			}
+		})
+	}
+}
+
+// Variables and flags have no list syntax but commas.
+func TestSetList(t *testing.T) {
+	cfg := Default()
+	t.Setenv("VOX_WAKE_PHRASES", "эй вокс, vox,,")
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"эй вокс", "vox"}; !slices.Equal(cfg.Wake.Phrases, want) {
+		t.Errorf("wake.phrases %q, want %q", cfg.Wake.Phrases, want)
+	}
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix prefixes environment overrides: hub.url is read from VOX_HUB_URL.
+const EnvPrefix = "VOX_"
+
+// Set overrides a single dotted key such as "duck.factor" from its string
+// form, as given in the environment or a flag. Lists are comma separated.
+func (c *Config) Set(key, value string) error {
+	field, ok := lookup(reflect.ValueOf(c).Elem(), strings.Split(key, "."))
+	if !ok {
+		return &KeyError{Key: key, Msg: "unknown key"}
+	}
+	if err := setValue(field, value); err != nil {
+		return &KeyError{Key: key, Msg: err.Error()}
+	}
+	return nil
+}
+
+// setList assigns a list decoded from a config file. Unlike the string
+// form given to Set, its items may contain commas.
+func (c *Config) setList(key string, items []any) error {
+	field, ok := lookup(reflect.ValueOf(c).Elem(), strings.Split(key, "."))
+	if !ok {
+		return &KeyError{Key: key, Msg: "unknown key"}
+	}
+	if field.Kind() != reflect.Slice || field.Type().Elem().Kind() != reflect.String {
+		return &KeyError{Key: key, Msg: "want a single value, got a list"}
+	}
+
+	var list []string
+	for _, item := range items {
+		switch item.(type) {
+		case map[string]any, []any:
+			return &KeyError{Key: key, Msg: "list items must be plain values"}
+		}
+		if s := strings.TrimSpace(fmt.Sprint(item)); s != "" {
+			list = append(list, s)
+		}
+	}
+	field.Set(reflect.ValueOf(list))
+	return nil
+}
+
+// ApplyEnv applies every VOX_* variable that names a known key.
+func (c *Config) ApplyEnv() error {
+	for _, key := range Keys() {
+		name := EnvName(key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) applyTree(prefix string, tree map[string]any) error {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := prefix + name
+		switch v := tree[name].(type) {
+		case nil:
+		case map[string]any:
+			if err := c.applyTree(key+".", v); err != nil {
+				return err
+			}
+		case []any:
+			if err := c.setList(key, v); err != nil {
+				return err
+			}
+		default:
+			if err := c.Set(key, fmt.Sprint(v)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Keys lists every settable dotted key in sorted order.
+func Keys() []string {
+	var keys []string
+	collectKeys(reflect.TypeOf(Config{}), "", &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+// Diff lists the keys whose values differ between a and b.
+func Diff(a, b Config) []string {
+	va := reflect.ValueOf(&a).Elem()
+	vb := reflect.ValueOf(&b).Elem()
+
+	var changed []string
+	for _, key := range Keys() {
+		path := strings.Split(key, ".")
+		fa, _ := lookup(va, path)
+		fb, _ := lookup(vb, path)
+		if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func isLeaf(t reflect.Type) bool {
+	return t.Kind() != reflect.Struct || reflect.PointerTo(t).Implements(textUnmarshaler)
+}
+
+func collectKeys(t reflect.Type, prefix string, keys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := tagName(f)
+		if name == "" {
+			continue
+		}
+		if isLeaf(f.Type) {
+			*keys = append(*keys, prefix+name)
+		} else {
+			collectKeys(f.Type, prefix+name+".", keys)
+		}
+	}
+}
+
+func lookup(v reflect.Value, path []string) (reflect.Value, bool) {
+	for _, part := range path {
+		if isLeaf(v.Type()) {
+			return reflect.Value{}, false
+		}
+
+		found := false
+		for j := 0; j < v.NumField(); j++ {
+			if tagName(v.Type().Field(j)) == part {
+				v = v.Field(j)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, false
+		}
+	}
+
+	if !isLeaf(v.Type()) {
+		return reflect.Value{}, false
+	}
+	return v, true
+}
+
+func tagName(f reflect.StructField) string {
+	tag := f.Tag.Get("toml")
+	if tag == "-" {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("want a boolean, got %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("want an integer, got %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("want a non-negative integer, got %q", s)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("want a number, got %q", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
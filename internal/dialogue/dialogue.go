@@ -0,0 +1,204 @@
+package dialogue
+
+import (
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"vox/internal/nlu"
+)
+
+type Config struct {
+	Turns        int           // turns kept as context for the NLU
+	TTL          time.Duration // older turns and open questions are forgotten
+	MaxQuestions int           // follow-up questions in a row before giving up
+}
+
+// Manager keeps the short conversation the daemon has had, so a command can
+// refer back to the previous one and a missing entity can be asked for.
+type Manager struct {
+	mu      sync.Mutex
+	cfg     Config
+	turns   []turn
+	pending *pending
+	asked   int
+}
+
+type turn struct {
+	at time.Time
+	ex nlu.Exchange
+}
+
+// pending is a command waiting for the answer to a question.
+type pending struct {
+	cmd    nlu.Result
+	entity string // empty when the whole command was not understood
+	at     time.Time
+}
+
+func New(cfg Config) *Manager {
+	return &Manager{cfg: cfg}
+}
+
+// Configure swaps the settings, keeping the conversation.
+func (m *Manager) Configure(cfg Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = cfg
+	m.trim(time.Now())
+}
+
+// Context returns the recent turns, oldest first, and the device they were
+// last about.
+func (m *Manager) Context(now time.Time) ([]nlu.Exchange, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.trim(now)
+
+	history := make([]nlu.Exchange, 0, len(m.turns))
+	var last string
+	for _, t := range m.turns {
+		history = append(history, t.ex)
+		if dev := t.ex.Result.Entities["device"]; dev != "" {
+			last = dev
+		}
+	}
+	return history, last
+}
+
+// Resolve completes res from the conversation. An answer to an open
+// question is merged into the command that asked it, unless it is a new
+// command of its own; a command without a device goes to the last device,
+// when the registry routes the intent there.
+func (m *Manager) Resolve(res nlu.Result, reg *nlu.Registry, now time.Time) nlu.Result {
+	m.mu.Lock()
+	p, ttl := m.pending, m.cfg.TTL
+	m.pending = nil
+	m.mu.Unlock()
+
+	if p != nil && now.Sub(p.at) <= ttl && p.entity != "" &&
+		(res.Intent == "unknown" || res.Intent == p.cmd.Intent) {
+		merged := p.cmd
+		merged.Entities = maps.Clone(p.cmd.Entities)
+		if merged.Entities == nil {
+			merged.Entities = map[string]string{}
+		}
+		for k, v := range res.Entities {
+			if merged.Entities[k] == "" {
+				merged.Entities[k] = v
+			}
+		}
+		// "в семь утра" is the time itself; devices have to be named
+		if merged.Entities[p.entity] == "" && p.entity != "device" {
+			merged.Entities[p.entity] = strings.TrimSpace(res.Query)
+		}
+		merged.Query = p.cmd.Query + " / " + res.Query
+		merged.Source = res.Source
+		return merged
+	}
+
+	if res.Intent == "unknown" || res.Entities["device"] != "" {
+		return res
+	}
+
+	_, last := m.Context(now)
+	if reg.Supports(last, res.Intent) {
+		res.Entities = maps.Clone(res.Entities)
+		if res.Entities == nil {
+			res.Entities = map[string]string{}
+		}
+		res.Entities["device"] = last
+	}
+	return res
+}
+
+// Done records a turn that needed no question.
+func (m *Manager) Done(res nlu.Result, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.asked = 0
+	m.add(turn{at: now, ex: nlu.Exchange{Query: res.Query, Result: res}})
+}
+
+// Ask records a turn that lacks entity, or was not understood at all when
+// entity is empty, and returns the question to put to the user in lang.
+// It returns false once MaxQuestions have been asked in a row.
+func (m *Manager) Ask(res nlu.Result, entity, lang string, now time.Time) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.asked >= m.cfg.MaxQuestions {
+		m.asked = 0
+		m.pending = nil
+		return "", false
+	}
+	m.asked++
+
+	q := question(entity, lang)
+	m.pending = &pending{cmd: res, entity: entity, at: now}
+	m.add(turn{at: now, ex: nlu.Exchange{Query: res.Query, Result: res, Question: q}})
+
+	return q, true
+}
+
+// Forget drops an open question, e.g. when the answer was cancelled.
+func (m *Manager) Forget() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = nil
+	m.asked = 0
+}
+
+func (m *Manager) add(t turn) {
+	m.turns = append(m.turns, t)
+	m.trim(t.at)
+}
+
+func (m *Manager) trim(now time.Time) {
+	i := 0
+	for i < len(m.turns) && now.Sub(m.turns[i].at) > m.cfg.TTL {
+		i++
+	}
+	if over := len(m.turns) - i - m.cfg.Turns; over > 0 {
+		i += over
+	}
+	m.turns = m.turns[i:]
+}
+
+var questions = map[string]map[string]string{
+	"ru": {
+		"":           "Не поняла, повторите, пожалуйста.",
+		"device":     "Какое устройство?",
+		"brightness": "Какую яркость поставить?",
+		"mode":       "Какой режим включить?",
+		"time":       "На какое время?",
+		"date":       "На какой день?",
+	},
+	"en": {
+		"":           "Sorry, I didn't get that. Say it again?",
+		"device":     "Which device?",
+		"brightness": "What brightness?",
+		"mode":       "Which mode?",
+		"time":       "For what time?",
+		"date":       "For which day?",
+	},
+}
+
+// question phrases the question for entity; Russian unless the user spoke
+// English.
+func question(entity, lang string) string {
+	qs, ok := questions[lang]
+	if !ok {
+		qs = questions["ru"]
+	}
+	if q, ok := qs[entity]; ok {
+		return q
+	}
+	if lang == "en" {
+		return "What " + entity + "?"
+	}
+	return "Уточните: " + entity + "?"
+}
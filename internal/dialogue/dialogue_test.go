@@ -0,0 +1,196 @@
+package dialogue
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"vox/internal/nlu"
+)
+
+var t0 = time.Date(2026, 10, 17, 7, 30, 0, 0, time.UTC)
+
+func testManager() *Manager {
+	return New(Config{Turns: 4, TTL: time.Minute, MaxQuestions: 2})
+}
+
+func TestResolveAnswer(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      nlu.Result // the command that asked
+		entity   string
+		answer   nlu.Result
+		entities map[string]string
+	}{
+		{
+			name:     "answer understood on its own",
+			cmd:      nlu.Result{Intent: "set_brightness", Entities: map[string]string{"device": "lamp"}, Query: "яркость лампы"},
+			entity:   "brightness",
+			answer:   nlu.Result{Intent: "set_brightness", Entities: map[string]string{"brightness": "128"}, Query: "50 процентов"},
+			entities: map[string]string{"device": "lamp", "brightness": "128"},
+		},
+		{
+			name:     "answer taken as the value",
+			cmd:      nlu.Result{Intent: "set_time", Entities: map[string]string{"device": "alarm"}, Query: "поставь будильник"},
+			entity:   "time",
+			answer:   nlu.Result{Intent: "unknown", Entities: map[string]string{}, Query: " в семь утра "},
+			entities: map[string]string{"device": "alarm", "time": "в семь утра"},
+		},
+		{
+			name:     "device named",
+			cmd:      nlu.Result{Intent: "turn_on", Entities: map[string]string{}, Query: "включи"},
+			entity:   "device",
+			answer:   nlu.Result{Intent: "unknown", Entities: map[string]string{"device": "lamp"}, Query: "лампу"},
+			entities: map[string]string{"device": "lamp"},
+		},
+		{
+			// a device has to be one the NLU knows, not whatever was said
+			name:     "device not named",
+			cmd:      nlu.Result{Intent: "turn_on", Entities: map[string]string{}, Query: "включи"},
+			entity:   "device",
+			answer:   nlu.Result{Intent: "unknown", Entities: map[string]string{}, Query: "чайник"},
+			entities: map[string]string{},
+		},
+		{
+			name:     "the command's own entities win",
+			cmd:      nlu.Result{Intent: "set_brightness", Entities: map[string]string{"device": "lamp"}, Query: "яркость лампы"},
+			entity:   "brightness",
+			answer:   nlu.Result{Intent: "set_brightness", Entities: map[string]string{"device": "all", "brightness": "255"}, Query: "на максимум"},
+			entities: map[string]string{"device": "lamp", "brightness": "255"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testManager()
+			before := maps.Clone(tt.cmd.Entities)
+			if _, ok := m.Ask(tt.cmd, tt.entity, "ru", t0); !ok {
+				t.Fatal("did not ask")
+			}
+
+			got := m.Resolve(tt.answer, nlu.DefaultRegistry(), t0.Add(10*time.Second))
+			if got.Intent != tt.cmd.Intent || !maps.Equal(got.Entities, tt.entities) {
+				t.Errorf("got %s %v, want %s %v", got.Intent, got.Entities, tt.cmd.Intent, tt.entities)
+			}
+			if want := tt.cmd.Query + " / " + tt.answer.Query; got.Query != want {
+				t.Errorf("query %q, want %q", got.Query, want)
+			}
+			if !maps.Equal(tt.cmd.Entities, before) {
+				t.Errorf("the asking command was changed: %v", tt.cmd.Entities)
+			}
+
+			// the question is answered
+			again := m.Resolve(tt.answer, nlu.DefaultRegistry(), t0.Add(20*time.Second))
+			if again.Query != tt.answer.Query {
+				t.Errorf("answered twice: %+v", again)
+			}
+		})
+	}
+}
+
+func TestResolveExpired(t *testing.T) {
+	m := testManager()
+	cmd := nlu.Result{Intent: "set_brightness", Entities: map[string]string{"device": "lamp"}, Query: "яркость лампы"}
+	m.Ask(cmd, "brightness", "ru", t0)
+
+	answer := nlu.Result{Intent: "unknown", Entities: map[string]string{}, Query: "50"}
+	got := m.Resolve(answer, nlu.DefaultRegistry(), t0.Add(time.Minute+time.Second))
+	if got.Intent != "unknown" || got.Query != "50" || len(got.Entities) != 0 {
+		t.Errorf("got %+v after the TTL, want the answer as it was", got)
+	}
+	if history, last := m.Context(t0.Add(time.Minute + time.Second)); len(history) != 0 || last != "" {
+		t.Errorf("context %v, %q after the TTL", history, last)
+	}
+}
+
+// A new command while a question is open drops the question.
+func TestResolveNewCommand(t *testing.T) {
+	m := testManager()
+	reg := nlu.DefaultRegistry()
+	m.Ask(nlu.Result{Intent: "set_brightness", Entities: map[string]string{"device": "lamp"}, Query: "яркость лампы"}, "brightness", "ru", t0)
+
+	cmd := nlu.Result{Intent: "turn_off", Entities: map[string]string{"device": "all"}, Query: "выключи всё"}
+	if got := m.Resolve(cmd, reg, t0.Add(time.Second)); got.Intent != "turn_off" || !maps.Equal(got.Entities, cmd.Entities) {
+		t.Errorf("got %+v, want the new command", got)
+	}
+
+	answer := nlu.Result{Intent: "set_brightness", Entities: map[string]string{"brightness": "128"}, Query: "50 процентов"}
+	if got := m.Resolve(answer, reg, t0.Add(2*time.Second)); got.Query != answer.Query {
+		t.Errorf("late answer merged: %+v", got)
+	}
+}
+
+func TestAskCap(t *testing.T) {
+	m := testManager()
+	res := nlu.Result{Intent: "unknown", Query: "бла"}
+
+	for i := range 2 {
+		if q, ok := m.Ask(res, "", "ru", t0); !ok || q == "" {
+			t.Fatalf("question %d not asked", i+1)
+		}
+	}
+	if _, ok := m.Ask(res, "", "ru", t0); ok {
+		t.Fatal("asked past MaxQuestions")
+	}
+	// giving up starts over
+	if _, ok := m.Ask(res, "", "ru", t0); !ok {
+		t.Error("no question after giving up")
+	}
+
+	// as does a command that needed none
+	m.Done(nlu.Result{Intent: "turn_on", Entities: map[string]string{"device": "lamp"}}, t0)
+	for i := range 2 {
+		if _, ok := m.Ask(res, "", "ru", t0); !ok {
+			t.Errorf("question %d after a done command not asked", i+1)
+		}
+	}
+}
+
+func TestResolveLastDevice(t *testing.T) {
+	m := testManager()
+	reg := nlu.DefaultRegistry()
+	m.Done(nlu.Result{Intent: "turn_on", Entities: map[string]string{"device": "lamp"}, Query: "включи лампу"}, t0)
+
+	if got := m.Resolve(nlu.Result{Intent: "turn_off", Query: "выключи"}, reg, t0.Add(time.Second)); got.Entities["device"] != "lamp" {
+		t.Errorf("got %+v, want the lamp", got)
+	}
+	// the lamp has no modes
+	if got := m.Resolve(nlu.Result{Intent: "set_mode", Query: "режим ночь"}, reg, t0.Add(time.Second)); got.Entities["device"] != "" {
+		t.Errorf("got %+v, want no device", got)
+	}
+	// nor is anything remembered past the TTL
+	if got := m.Resolve(nlu.Result{Intent: "turn_off", Query: "выключи"}, reg, t0.Add(2*time.Minute)); got.Entities["device"] != "" {
+		t.Errorf("got %+v after the TTL", got)
+	}
+}
+
+func TestContextTurns(t *testing.T) {
+	m := testManager()
+	for i, dev := range []string{"lamp", "all", "lamp", "all", "lamp"} {
+		m.Done(nlu.Result{Intent: "turn_on", Entities: map[string]string{"device": dev}, Query: dev}, t0.Add(time.Duration(i)*time.Second))
+	}
+	m.Done(nlu.Result{Intent: "stop", Entities: map[string]string{}, Query: "стоп"}, t0.Add(5*time.Second))
+
+	history, last := m.Context(t0.Add(5 * time.Second))
+	if len(history) != 4 || history[0].Query != "lamp" || history[3].Query != "стоп" {
+		t.Errorf("history %+v, want the last 4 turns", history)
+	}
+	if last != "lamp" {
+		t.Errorf("last device %q", last)
+	}
+}
+
+func TestQuestion(t *testing.T) {
+	for _, tt := range []struct{ entity, lang, want string }{
+		{"brightness", "ru", "Какую яркость поставить?"},
+		{"brightness", "en", "What brightness?"},
+		{"", "en", "Sorry, I didn't get that. Say it again?"},
+		{"device", "de", "Какое устройство?"},
+		{"color", "en", "What color?"},
+		{"color", "ru", "Уточните: color?"},
+	} {
+		if got := question(tt.entity, tt.lang); got != tt.want {
+			t.Errorf("question(%q, %q) = %q, want %q", tt.entity, tt.lang, got, tt.want)
+		}
+	}
+}
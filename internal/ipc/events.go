@@ -0,0 +1,90 @@
+package ipc
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	EventSessionStarted    = "session_started"
+	EventRecordingStopped  = "recording_stopped"
+	EventPartialTranscript = "partial_transcript"
+	EventTranscript        = "transcript"
+	EventNLUResult         = "nlu_result"
+	EventDispatchResult    = "dispatch_result"
+	EventQuestion          = "question"
+	EventSessionFinished   = "session_finished"
+	EventConfigReloaded    = "config_reloaded"
+	EventWake              = "wake"
+	EventHubCommand        = "hub_command"
+	EventHubState          = "hub_state"
+	EventError             = "error"
+)
+
+// Event is a single notification streamed to subscribers as one JSON line.
+type Event struct {
+	Type    string    `json:"type"`
+	Session int       `json:"session,omitempty"`
+	Time    time.Time `json:"time"`
+	Data    any       `json:"data,omitempty"`
+}
+
+// Broker fans events out to every live subscription. Slow subscribers lose
+// events instead of stalling the publisher.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[*subscription]struct{}
+}
+
+type subscription struct {
+	ch    chan Event
+	types []string
+}
+
+const subscriptionBuffer = 64
+
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[*subscription]struct{}),
+	}
+}
+
+func (b *Broker) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		if len(s.types) > 0 && !slices.Contains(s.types, ev.Type) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribe registers a subscription limited to types (all when empty).
+func (b *Broker) subscribe(types []string) *subscription {
+	s := &subscription{
+		ch:    make(chan Event, subscriptionBuffer),
+		types: types,
+	}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+func (b *Broker) unsubscribe(s *subscription) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+}
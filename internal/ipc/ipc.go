@@ -2,14 +2,37 @@ package ipc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 const SocketPath = "/tmp/vox.sock"
 
-func StartServer(handler func(ControlMessage)) error {
+// callTimeout bounds how long a client waits for the daemon; "say" blocks
+// until speech is done, so it is generous.
+const callTimeout = 2 * time.Minute
+
+// ErrNotRunning is wrapped by Call and Watch when nothing listens on
+// SocketPath.
+var ErrNotRunning = errors.New("vox-daemon not running")
+
+// Handler serves a single control request. The returned value is encoded
+// as the reply result; a non-nil error is reported back to the client.
+type Handler func(msg ControlMessage) (any, error)
+
+// CmdSubscribe keeps the connection open and streams events after the reply.
+const CmdSubscribe = "subscribe"
+
+// StartServer serves control requests with handler. When events is non-nil,
+// "subscribe" requests are answered by streaming from it.
+func StartServer(handler Handler, events *Broker) error {
 	os.Remove(SocketPath)
 
 	ln, err := net.Listen("unix", SocketPath)
@@ -23,14 +46,14 @@ func StartServer(handler func(ControlMessage)) error {
 			if err != nil {
 				continue
 			}
-			go handleConn(conn, handler)
+			go handleConn(conn, handler, events)
 		}
 	}()
 
 	return nil
 }
 
-func handleConn(conn net.Conn, handler func(ControlMessage)) {
+func handleConn(conn net.Conn, handler Handler, events *Broker) {
 	defer conn.Close()
 
 	var msg ControlMessage
@@ -38,16 +61,153 @@ func handleConn(conn net.Conn, handler func(ControlMessage)) {
 	if err := dec.Decode(&msg); err != nil {
 		return
 	}
-	handler(msg)
+
+	if msg.Cmd == CmdSubscribe && events != nil {
+		streamEvents(conn, msg, events)
+		return
+	}
+
+	reply := ControlReply{
+		ID:  msg.ID,
+		Cmd: msg.Cmd,
+	}
+
+	res, err := handler(msg)
+	if err != nil {
+		reply.Error = err.Error()
+	} else if res != nil {
+		raw, err := json.Marshal(res)
+		if err != nil {
+			reply.Error = fmt.Sprintf("marshal result: %v", err)
+		} else {
+			reply.Result = raw
+		}
+	}
+
+	enc := json.NewEncoder(conn)
+	_ = enc.Encode(reply)
 }
 
-func SendCommand(cmd string) error {
-	conn, err := net.Dial("unix", SocketPath)
+func streamEvents(conn net.Conn, msg ControlMessage, events *Broker) {
+	sub := events.subscribe(msg.Args)
+	defer events.unsubscribe(sub)
+
+	enc := json.NewEncoder(conn)
+	if err := enc.Encode(ControlReply{ID: msg.ID, Cmd: msg.Cmd}); err != nil {
+		return
+	}
+
+	// the client never writes again; a read returning means it went away
+	gone := make(chan struct{})
+	go func() {
+		_, _ = conn.Read(make([]byte, 1))
+		close(gone)
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case ev := <-sub.ch:
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+var reqSeq atomic.Uint64
+
+func nextID() string {
+	return strconv.Itoa(os.Getpid()) + "-" + strconv.FormatUint(reqSeq.Add(1), 10)
+}
+
+// Call sends cmd with args to the daemon and waits for its reply.
+// A reply carrying an error is returned together with that error.
+func Call(cmd string, args ...string) (*ControlReply, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(callTimeout))
+
+	msg := ControlMessage{
+		ID:   nextID(),
+		Cmd:  cmd,
+		Args: args,
+	}
+
+	enc := json.NewEncoder(conn)
+	if err := enc.Encode(msg); err != nil {
+		return nil, fmt.Errorf("send: %w", err)
+	}
+
+	var reply ControlReply
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&reply); err != nil {
+		return nil, fmt.Errorf("read reply: %w", err)
+	}
+	if reply.ID != msg.ID {
+		return nil, fmt.Errorf("reply id mismatch: got %q, want %q", reply.ID, msg.ID)
+	}
+	if !reply.Ok() {
+		return &reply, errors.New(reply.Error)
+	}
+
+	return &reply, nil
+}
+
+// Watch subscribes to daemon events, limited to types when given, and calls
+// fn for each one until fn returns an error or the connection drops.
+func Watch(fn func(Event) error, types ...string) error {
+	conn, err := dial()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
+	msg := ControlMessage{
+		ID:   nextID(),
+		Cmd:  CmdSubscribe,
+		Args: types,
+	}
+
 	enc := json.NewEncoder(conn)
-	return enc.Encode(ControlMessage{Cmd: cmd})
+	if err := enc.Encode(msg); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	dec := json.NewDecoder(conn)
+
+	var reply ControlReply
+	if err := dec.Decode(&reply); err != nil {
+		return fmt.Errorf("read reply: %w", err)
+	}
+	if !reply.Ok() {
+		return errors.New(reply.Error)
+	}
+
+	for {
+		var ev Event
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read event: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
+
+func dial() (net.Conn, error) {
+	conn, err := net.Dial("unix", SocketPath)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+		// no socket, or a stale one left by a daemon that died
+		return nil, fmt.Errorf("%w: %w", ErrNotRunning, err)
+	}
+	return conn, err
 }
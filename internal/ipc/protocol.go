@@ -1,5 +1,22 @@
 package ipc
 
+import "encoding/json"
+
+// ControlMessage is a single request sent to the daemon over the control socket.
 type ControlMessage struct {
-	Cmd string `json:"cmd"`
+	ID   string   `json:"id,omitempty"`
+	Cmd  string   `json:"cmd"`
+	Args []string `json:"args,omitempty"`
+}
+
+// ControlReply answers a ControlMessage with the same ID.
+type ControlReply struct {
+	ID     string          `json:"id,omitempty"`
+	Cmd    string          `json:"cmd"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+func (r *ControlReply) Ok() bool {
+	return r.Error == ""
 }
@@ -1,33 +1,166 @@
 package nlu
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
 	"vox/pkg/protocol"
 )
 
-func Dispatch(cmd Result, ptcl *protocol.Protocol) (string, error) {
-	var verb, noun, to string
-	switch cmd.Intent {
-	case "turn_on":
-		verb = "ON"
-	case "turn_off":
-		verb = "OFF"
-	default:
-		return "", fmt.Errorf("Unknown intnent")
+// Dispatch sends cmd to the device the registry routes it to and returns
+// the hub's reply. Entities the action needs become ARG tokens; a missing
+// or malformed one is reported as an *EntityError. An ERR reply is returned
+// along with its *protocol.ReplyError. A command for a group goes to all of
+// its members at once, see dispatchGroup.
+func Dispatch(ctx context.Context, cmd Result, reg *Registry, ptcl *protocol.Protocol) (string, error) {
+	if g, ok := reg.Group(cmd.Entities["device"]); ok {
+		return dispatchGroup(ctx, cmd, reg, g, ptcl)
 	}
 
-	switch cmd.Entities["device"] {
-	case "lamp":
-		to = "VERTEX"
-		noun = "LAMP"
-	default:
-		return "", fmt.Errorf("Unknown device")
+	dev, err := route(cmd, reg)
+	if err != nil {
+		return "", err
 	}
-
-	msg, err := ptcl.TransmitReceive([]string{to, noun, verb})
+	req, err := command(cmd, dev, time.Now())
 	if err != nil {
 		return "", err
 	}
 
-	return msg.String(), nil
+	msg, err := ptcl.TransmitReceive(ctx, req)
+	if msg == nil {
+		return "", err
+	}
+
+	return msg.String(), err
+}
+
+// GroupError reports the members of a group command that failed.
+type GroupError struct {
+	Group  string
+	Total  int
+	Failed map[string]error // by device id
+}
+
+func (e *GroupError) Error() string {
+	ids := slices.Sorted(maps.Keys(e.Failed))
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s: %v", id, e.Failed[id])
+	}
+	return fmt.Sprintf("%d of %d devices in %q failed: %s", len(ids), e.Total, e.Group, strings.Join(parts, "; "))
+}
+
+func (e *GroupError) Unwrap() []error {
+	return slices.Collect(maps.Values(e.Failed))
+}
+
+// dispatchGroup fans cmd out to every member of g that supports it and
+// waits for all of them. The replies are joined as "id=reply; ..."; when
+// any member fails a *GroupError lists them.
+func dispatchGroup(ctx context.Context, cmd Result, reg *Registry, g Group, ptcl *protocol.Protocol) (string, error) {
+	if cmd.Intent == "" || cmd.Intent == "unknown" {
+		return "", fmt.Errorf("Unknown intent %q", cmd.Intent)
+	}
+	members := reg.Members(g, cmd.Intent)
+	if len(members) == 0 {
+		return "", fmt.Errorf("Group %q has no device for %q", g.ID, cmd.Intent)
+	}
+
+	now := time.Now()
+	reqs := make([]protocol.Message, len(members))
+	for i, dev := range members {
+		req, err := command(cmd, dev, now)
+		if err != nil {
+			// the entities are shared, so ask about the group
+			var ee *EntityError
+			if errors.As(err, &ee) {
+				ee.Device = g.ID
+			}
+			return "", err
+		}
+		reqs[i] = req
+	}
+
+	gerr := &GroupError{Group: g.ID, Total: len(members), Failed: map[string]error{}}
+	parts := make([]string, len(members))
+	for i, r := range ptcl.Multicast(ctx, reqs) {
+		id := members[i].ID
+		if r.Err != nil {
+			gerr.Failed[id] = r.Err
+		}
+		reply := "-"
+		if r.Msg != nil {
+			reply = r.Msg.String()
+		}
+		parts[i] = id + "=" + reply
+	}
+
+	out := strings.Join(parts, "; ")
+	if len(gerr.Failed) > 0 {
+		return out, gerr
+	}
+	return out, nil
+}
+
+// command builds the message for cmd on dev.
+func command(cmd Result, dev Device, now time.Time) (protocol.Message, error) {
+	act := dev.Intents[cmd.Intent]
+
+	args := make([]string, 0, len(act.Args))
+	for _, name := range act.Args {
+		arg, err := entityArg(cmd, name, now)
+		if err != nil {
+			return protocol.Message{}, &EntityError{
+				Intent: cmd.Intent,
+				Device: dev.ID,
+				Entity: name,
+				Value:  cmd.Entities[name],
+				Err:    err,
+			}
+		}
+		args = append(args, arg)
+	}
+
+	return protocol.Message{
+		To:   dev.To,
+		Verb: act.Verb,
+		Noun: dev.Noun,
+		Args: args,
+	}, nil
+}
+
+// route finds the device cmd is for. Without a device entity, an intent
+// only one device supports ("stop") goes to that device.
+func route(cmd Result, reg *Registry) (Device, error) {
+	if cmd.Intent == "" || cmd.Intent == "unknown" {
+		return Device{}, fmt.Errorf("Unknown intent %q", cmd.Intent)
+	}
+
+	id := cmd.Entities["device"]
+	if id == "" {
+		var found []Device
+		for _, d := range reg.Devices {
+			if d.Supports(cmd.Intent) {
+				found = append(found, d)
+			}
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		}
+		return Device{}, &EntityError{Intent: cmd.Intent, Entity: "device", Err: ErrMissingEntity}
+	}
+
+	dev, ok := reg.Device(id)
+	if !ok {
+		return Device{}, fmt.Errorf("Unknown device %q", id)
+	}
+	if !dev.Supports(cmd.Intent) {
+		return Device{}, fmt.Errorf("Device %q does not support %q", id, cmd.Intent)
+	}
+	return dev, nil
 }
@@ -0,0 +1,157 @@
+package nlu
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"vox/pkg/hubmock"
+	"vox/pkg/protocol"
+)
+
+// testRegistry has three devices on three shards and a group of them all.
+func testRegistry() *Registry {
+	return &Registry{
+		Devices: []Device{
+			{ID: "lamp", To: "VERTEX", Noun: "LAMP", Intents: map[string]Action{
+				"turn_on":        {Verb: "ON"},
+				"turn_off":       {Verb: "OFF"},
+				"set_brightness": {Verb: "SET", Args: []string{"brightness"}},
+			}},
+			{ID: "speakers", To: "ALARM", Noun: "SOUND", Intents: map[string]Action{
+				"turn_off": {Verb: "OFF"},
+			}},
+			{ID: "display", To: "TIMER", Noun: "DISPLAY", Intents: map[string]Action{
+				"turn_off": {Verb: "OFF"},
+			}},
+		},
+		Groups: []Group{{ID: "all"}},
+	}
+}
+
+func dial(t *testing.T, url string) *protocol.Protocol {
+	t.Helper()
+	ptcl, err := protocol.NewProtocol(protocol.PtclConfig{
+		Shard:    "VOX",
+		Url:      url,
+		Timeout:  200 * time.Millisecond,
+		Queue:    16,
+		Sequence: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ptcl.Run()
+	t.Cleanup(func() { ptcl.Close() })
+	return ptcl
+}
+
+func TestDispatch(t *testing.T) {
+	hub, url := hubmock.Start(t)
+	hub.Script(
+		hubmock.Rule{To: "VERTEX", Verb: "SET", Reply: "ERR:RANGE"},
+		hubmock.Rule{To: "VERTEX", Reply: "OK"},
+	)
+	ptcl := dial(t, url)
+	reg := testRegistry()
+
+	reply, err := Dispatch(context.Background(), Result{
+		Intent:   "set_brightness",
+		Entities: map[string]string{"device": "lamp", "brightness": "50%"},
+	}, reg, ptcl)
+	var re *protocol.ReplyError
+	if !errors.As(err, &re) || reply != "VOX:ERR:RANGE:VERTEX" {
+		t.Errorf("reply, err = %q, %v, want the ERR", reply, err)
+	}
+	if _, err := hub.Wait(ctx(t), func(r hubmock.Record) bool {
+		return r.Dir == hubmock.In && strings.HasPrefix(r.Frame, "VERTEX:SET:LAMP:128:SEQ")
+	}); err != nil {
+		t.Errorf("no SET with the brightness: %v", err)
+	}
+
+	reply, err = Dispatch(context.Background(), Result{
+		Intent:   "turn_on",
+		Entities: map[string]string{"device": "lamp"},
+	}, reg, ptcl)
+	if err != nil || reply != "VOX:OK:LAMP:VERTEX" {
+		t.Errorf("reply, err = %q, %v", reply, err)
+	}
+}
+
+func TestDispatchMissingEntity(t *testing.T) {
+	hub, url := hubmock.Start(t)
+	ptcl := dial(t, url)
+
+	_, err := Dispatch(context.Background(), Result{
+		Intent:   "set_brightness",
+		Entities: map[string]string{"device": "lamp"},
+	}, testRegistry(), ptcl)
+	var ee *EntityError
+	if !errors.As(err, &ee) || ee.Entity != "brightness" || !errors.Is(err, ErrMissingEntity) {
+		t.Errorf("err = %v, want a missing brightness", err)
+	}
+	if n := len(hub.Traffic()); n != 0 {
+		t.Errorf("%d frames sent for an incomplete command", n)
+	}
+}
+
+func TestDispatchGroup(t *testing.T) {
+	hub, url := hubmock.Start(t)
+	hub.Script(
+		hubmock.Rule{To: "VERTEX", Reply: "OK"},
+		hubmock.Rule{To: "ALARM", Reply: "ERR:BUSY"},
+		hubmock.Rule{To: "TIMER"}, // never answers
+	)
+	ptcl := dial(t, url)
+
+	reply, err := Dispatch(context.Background(), Result{
+		Intent:   "turn_off",
+		Entities: map[string]string{"device": "all"},
+	}, testRegistry(), ptcl)
+
+	want := "lamp=VOX:OK:LAMP:VERTEX; speakers=VOX:ERR:BUSY:ALARM; display=-"
+	if reply != want {
+		t.Errorf("reply = %q, want %q", reply, want)
+	}
+
+	var gerr *GroupError
+	if !errors.As(err, &gerr) {
+		t.Fatalf("err = %v, want a GroupError", err)
+	}
+	if gerr.Group != "all" || gerr.Total != 3 || len(gerr.Failed) != 2 {
+		t.Errorf("GroupError = %+v", gerr)
+	}
+	var re *protocol.ReplyError
+	if !errors.As(gerr.Failed["speakers"], &re) {
+		t.Errorf("speakers: %v, want the ERR", gerr.Failed["speakers"])
+	}
+	if !errors.Is(gerr.Failed["display"], protocol.ErrNoReply) {
+		t.Errorf("display: %v, want no reply", gerr.Failed["display"])
+	}
+	if !errors.Is(err, protocol.ErrNoReply) {
+		t.Error("GroupError does not unwrap to its members' errors")
+	}
+}
+
+func TestDispatchGroupOnlySupporting(t *testing.T) {
+	hub, url := hubmock.Start(t)
+	hub.Script(hubmock.Rule{Reply: "OK"})
+	ptcl := dial(t, url)
+
+	// only the lamp can be turned on
+	reply, err := Dispatch(context.Background(), Result{
+		Intent:   "turn_on",
+		Entities: map[string]string{"device": "all"},
+	}, testRegistry(), ptcl)
+	if err != nil || reply != "lamp=VOX:OK:LAMP:VERTEX" {
+		t.Errorf("reply, err = %q, %v", reply, err)
+	}
+}
+
+func ctx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
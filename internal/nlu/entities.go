@@ -0,0 +1,249 @@
+package nlu
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrMissingEntity is wrapped by an EntityError when the command lacks an
+// entity the device's action needs.
+var ErrMissingEntity = errors.New("missing")
+
+// EntityError names the entity a command could not be dispatched for.
+type EntityError struct {
+	Intent string
+	Device string
+	Entity string
+	Value  string
+	Err    error
+}
+
+func (e *EntityError) Error() string {
+	where := e.Intent
+	if e.Device != "" {
+		where = fmt.Sprintf("%s on %q", e.Intent, e.Device)
+	}
+	if e.Value == "" {
+		return fmt.Sprintf("%s: %s: %v", where, e.Entity, e.Err)
+	}
+	return fmt.Sprintf("%s: %s %q: %v", where, e.Entity, e.Value, e.Err)
+}
+
+func (e *EntityError) Unwrap() error {
+	return e.Err
+}
+
+// isoBasic is the protocol's time format, e.g. 20261017T073000+0300.
+const isoBasic = "20060102T150405-0700"
+
+// entityArg turns an entity value into a protocol ARG token. Brightness is
+// 0–255, time (with the date entity, if any) becomes ISO basic, anything
+// else an upper-case token.
+func entityArg(cmd Result, name string, now time.Time) (string, error) {
+	v := strings.TrimSpace(cmd.Entities[name])
+
+	switch name {
+	case "brightness":
+		if v == "" {
+			return "", ErrMissingEntity
+		}
+		return parseBrightness(v)
+
+	case "time":
+		if v == "" {
+			return "", ErrMissingEntity
+		}
+		t, err := parseTime(v, cmd.Entities["date"], now)
+		if err != nil {
+			return "", err
+		}
+		return t.Format(isoBasic), nil
+
+	case "date":
+		if v == "" {
+			return "", ErrMissingEntity
+		}
+		t, err := parseTime("00:00", v, now)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("20060102"), nil
+	}
+
+	if v == "" {
+		return "", ErrMissingEntity
+	}
+	tok := strings.ToUpper(strings.Join(strings.Fields(v), "_"))
+	if !hubTokenRe.MatchString(tok) {
+		return "", fmt.Errorf("not a protocol token")
+	}
+	return tok, nil
+}
+
+// parseBrightness accepts 0–255 or a percentage ("40%").
+func parseBrightness(v string) (string, error) {
+	s, percent := strings.CutSuffix(v, "%")
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return "", fmt.Errorf("not a number")
+	}
+	if percent {
+		if f < 0 || f > 100 {
+			return "", fmt.Errorf("must be within 0–100%%")
+		}
+		f = f * 255 / 100
+	}
+	if f < 0 || f > 255 {
+		return "", fmt.Errorf("must be within 0–255")
+	}
+	return strconv.Itoa(int(math.Round(f))), nil
+}
+
+var (
+	isoDateRe = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	dotDateRe = regexp.MustCompile(`\b(\d{1,2})\.(\d{1,2})\.(\d{4})\b`)
+	clockRe   = regexp.MustCompile(`(?:^|\D)(\d{1,2})(?:[:.](\d{2}))?(?::(\d{2}))?(?:\D|$)`)
+	// \b is ASCII only, so Russian words are bounded by hand
+	relativeRe = regexp.MustCompile(`(?:^|\s)(?:in|через)\s+(\d+|an?|half an|пол)?\s*(minutes?|mins?|hours?|hrs?|минут[уы]?|мин|час(?:а|ов)?)(?:\s|$)`)
+	// a bare amount, as the NLU often leaves it; "7 часов" is a clock time
+	bareRelativeRe = regexp.MustCompile(`(?:^|\s)(\d+)\s*(minutes?|mins?|hours?|hrs?|минут[уы]?|мин)(?:\s|$)`)
+)
+
+// time layouts tried before the spoken forms
+var timeLayouts = []string{
+	isoBasic,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// parseTime reads the time and date entities the NLU fills in: absolute
+// timestamps, clock times ("7:30", "at 7 pm", "в 7 вечера"), relative ones
+// ("in 10 minutes", "через час", or just "5 минут") and day words
+// ("tomorrow", "завтра") or dates. A clock time without a date that has
+// already passed today means tomorrow.
+func parseTime(tm, date string, now time.Time) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(tm), now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	phrase := strings.ToLower(strings.TrimSpace(tm + " " + date))
+	phrase = strings.ReplaceAll(phrase, "ё", "е")
+
+	rel := relativeRe.FindStringSubmatch(phrase)
+	if rel == nil {
+		rel = bareRelativeRe.FindStringSubmatch(phrase)
+	}
+	if rel != nil {
+		n := 1.0
+		switch rel[1] {
+		case "", "a", "an":
+		case "half an", "пол":
+			n = 0.5
+		default:
+			n, _ = strconv.ParseFloat(rel[1], 64)
+		}
+		unit := time.Minute
+		if strings.HasPrefix(rel[2], "h") || strings.HasPrefix(rel[2], "час") {
+			unit = time.Hour
+		}
+		return now.Add(time.Duration(n * float64(unit))).Truncate(time.Second), nil
+	}
+	day, dated, err := parseDay(&phrase, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var h, m, s int
+	switch {
+	case hasWord(phrase, "noon", "полдень"):
+		h = 12
+	case hasWord(phrase, "midnight", "полночь"):
+		h = 0
+	default:
+		c := clockRe.FindStringSubmatch(phrase)
+		if c == nil {
+			return time.Time{}, fmt.Errorf("no clock time")
+		}
+		h, _ = strconv.Atoi(c[1])
+		m, _ = strconv.Atoi(c[2])
+		s, _ = strconv.Atoi(c[3])
+
+		switch {
+		case hasWord(phrase, "pm", "evening", "afternoon", "tonight", "вечера", "дня"):
+			if h < 12 {
+				h += 12
+			}
+		case hasWord(phrase, "am", "morning", "утра", "ночи"):
+			if h == 12 {
+				h = 0
+			}
+		}
+	}
+	if h > 23 || m > 59 || s > 59 {
+		return time.Time{}, fmt.Errorf("no such time of day")
+	}
+
+	t := time.Date(day.Year(), day.Month(), day.Day(), h, m, s, 0, now.Location())
+	if !dated && t.Before(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseDay finds the day in phrase and cuts explicit dates out of it, so
+// their digits are not taken for a clock time. dated is false when the
+// phrase names no day at all.
+func parseDay(phrase *string, now time.Time) (day time.Time, dated bool, err error) {
+	day = now
+
+	if m := isoDateRe.FindStringSubmatch(*phrase); m != nil {
+		*phrase = strings.Replace(*phrase, m[0], " ", 1)
+		return ymd(m[1], m[2], m[3], now)
+	}
+	if m := dotDateRe.FindStringSubmatch(*phrase); m != nil {
+		*phrase = strings.Replace(*phrase, m[0], " ", 1)
+		return ymd(m[3], m[2], m[1], now)
+	}
+
+	switch {
+	case strings.Contains(*phrase, "day after tomorrow") || hasWord(*phrase, "послезавтра"):
+		return day.AddDate(0, 0, 2), true, nil
+	case hasWord(*phrase, "tomorrow", "завтра"):
+		return day.AddDate(0, 0, 1), true, nil
+	case hasWord(*phrase, "today", "сегодня"):
+		return day, true, nil
+	}
+	return day, false, nil
+}
+
+func ymd(y, m, d string, now time.Time) (time.Time, bool, error) {
+	t, err := time.ParseInLocation("2006-1-2", y+"-"+m+"-"+d, now.Location())
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("no such date")
+	}
+	return t, true, nil
+}
+
+// hasWord reports whether s has one of words as a whole word.
+func hasWord(s string, words ...string) bool {
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if slices.Contains(words, f) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package nlu
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	msk = time.FixedZone("MSK", 3*60*60)
+	// a Saturday morning
+	testNow = time.Date(2026, 10, 17, 7, 30, 0, 0, msk)
+)
+
+func TestParseTime(t *testing.T) {
+	at := func(month time.Month, day, h, m int) time.Time {
+		return time.Date(2026, month, day, h, m, 0, 0, msk)
+	}
+
+	tests := []struct {
+		time, date string
+		want       time.Time // zero for an error
+	}{
+		// relative
+		{time: "in 10 minutes", want: at(10, 17, 7, 40)},
+		{time: "in an hour", want: at(10, 17, 8, 30)},
+		{time: "in half an hour", want: at(10, 17, 8, 0)},
+		{time: "через час", want: at(10, 17, 8, 30)},
+		{time: "через полчаса", want: at(10, 17, 8, 0)},
+		{time: "через 2 часа", want: at(10, 17, 9, 30)},
+		{time: "через 15 минут", want: at(10, 17, 7, 45)},
+		{time: "10 minutes", want: at(10, 17, 7, 40)},
+		{time: "5 минут", want: at(10, 17, 7, 35)},
+		{time: "2 hours", want: at(10, 17, 9, 30)},
+
+		// clock times, the ones already passed today mean tomorrow
+		{time: "8:15", want: at(10, 17, 8, 15)},
+		{time: "6:00", want: at(10, 18, 6, 0)},
+		{time: "7:30", want: at(10, 17, 7, 30)},
+		{time: "8 am", want: at(10, 17, 8, 0)},
+		{time: "7 pm", want: at(10, 17, 19, 0)},
+		{time: "12 am", want: at(10, 18, 0, 0)},
+		{time: "12 pm", want: at(10, 17, 12, 0)},
+		{time: "в 7 вечера", want: at(10, 17, 19, 0)},
+		{time: "в 3 ночи", want: at(10, 18, 3, 0)},
+		{time: "7 часов", want: at(10, 18, 7, 0)},
+		{time: "noon", want: at(10, 17, 12, 0)},
+		{time: "полночь", want: at(10, 18, 0, 0)},
+
+		// with a day or date, kept even when passed
+		{time: "9:15", date: "tomorrow", want: at(10, 18, 9, 15)},
+		{time: "в 7 утра", date: "послезавтра", want: at(10, 19, 7, 0)},
+		{time: "6:00", date: "today", want: at(10, 17, 6, 0)},
+		{time: "18:00", date: "2026-12-31", want: at(12, 31, 18, 0)},
+		{time: "18:00", date: "31.12.2026", want: at(12, 31, 18, 0)},
+		{time: "6:00", date: "2026-10-01", want: at(10, 1, 6, 0)},
+
+		// timestamps as they are
+		{time: "20261020T101500+0300", want: at(10, 20, 10, 15)},
+		{time: "2026-10-20T10:15:00+03:00", want: at(10, 20, 10, 15)},
+		{time: "2026-10-20 10:15", want: at(10, 20, 10, 15)},
+
+		// out of range and nonsense
+		{time: "25:00"},
+		{time: "7:75"},
+		{time: "24 pm"},
+		{time: "soon"},
+		{time: "18:00", date: "2026-02-30"},
+		{time: "18:00", date: "31.13.2026"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTime(tt.time, tt.date, testNow)
+		switch {
+		case tt.want.IsZero() && err == nil:
+			t.Errorf("parseTime(%q, %q) = %s, want an error", tt.time, tt.date, got)
+		case !tt.want.IsZero() && err != nil:
+			t.Errorf("parseTime(%q, %q): %v", tt.time, tt.date, err)
+		case !got.Equal(tt.want):
+			t.Errorf("parseTime(%q, %q) = %s, want %s", tt.time, tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestParseBrightness(t *testing.T) {
+	tests := []struct {
+		in, want string // no want for an error
+	}{
+		{"0", "0"},
+		{"128", "128"},
+		{"255", "255"},
+		{"99.6", "100"},
+		{"0%", "0"},
+		{"50%", "128"},
+		{"40 %", "102"},
+		{"100%", "255"},
+		{"256", ""},
+		{"-1", ""},
+		{"101%", ""},
+		{"-5%", ""},
+		{"bright", ""},
+		{"%", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBrightness(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseBrightness(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseBrightness(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestEntityArg(t *testing.T) {
+	tests := []struct {
+		name     string
+		entities map[string]string
+		want     string
+		missing  bool // ErrMissingEntity rather than a bad value
+	}{
+		{name: "brightness", entities: map[string]string{"brightness": " 50% "}, want: "128"},
+		{name: "brightness", entities: map[string]string{}, missing: true},
+		{name: "brightness", entities: map[string]string{"brightness": "300"}},
+		{name: "time", entities: map[string]string{"time": "7:00"}, want: "20261018T070000+0300"},
+		{name: "time", entities: map[string]string{"time": "7:00", "date": "today"}, want: "20261017T070000+0300"},
+		{name: "time", entities: map[string]string{"time": "10 minutes"}, want: "20261017T074000+0300"},
+		{name: "time", entities: map[string]string{"date": "tomorrow"}, missing: true},
+		{name: "time", entities: map[string]string{"time": "whenever"}},
+		{name: "date", entities: map[string]string{"date": "завтра"}, want: "20261018"},
+		{name: "date", entities: map[string]string{"date": "2026-11-05"}, want: "20261105"},
+		{name: "date", entities: map[string]string{"date": "2026-11-31"}},
+		{name: "mode", entities: map[string]string{"mode": "night  light"}, want: "NIGHT_LIGHT"},
+		{name: "mode", entities: map[string]string{"mode": "ночник"}},
+		{name: "mode", entities: map[string]string{"mode": "  "}, missing: true},
+	}
+
+	for _, tt := range tests {
+		cmd := Result{Intent: "set", Entities: tt.entities}
+		got, err := entityArg(cmd, tt.name, testNow)
+		switch {
+		case tt.missing:
+			if !errors.Is(err, ErrMissingEntity) {
+				t.Errorf("%s %v: %q, %v, want it missing", tt.name, tt.entities, got, err)
+			}
+		case tt.want == "":
+			if err == nil || errors.Is(err, ErrMissingEntity) {
+				t.Errorf("%s %v: %q, %v, want a bad value", tt.name, tt.entities, got, err)
+			}
+		case err != nil || got != tt.want:
+			t.Errorf("%s %v: %q, %v, want %q", tt.name, tt.entities, got, err, tt.want)
+		}
+	}
+}
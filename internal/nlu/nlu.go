@@ -5,14 +5,50 @@ import (
 	"encoding/json"
 	"fmt"
 	log "log/slog"
+	"strings"
 
 	openai "github.com/openai/openai-go/v3"
 )
 
+// Modes pick who classifies an utterance.
+const (
+	ModeHybrid  = "hybrid"  // rules first, the LLM when they are unsure
+	ModeOffline = "offline" // rules only, never leaves the machine
+	ModeLLM     = "llm"     // always the LLM
+)
+
+// Sources say which stage produced a Result.
+const (
+	SourceRules = "rules"
+	SourceLLM   = "llm"
+)
+
+type Config struct {
+	Model         string    // chat model, e.g. "gpt-5-nano"
+	Mode          string    // ModeHybrid when empty
+	MinConfidence float64   // rule results below it go to the LLM
+	Registry      *Registry // DefaultRegistry() when nil
+
+	// Conversation so far, oldest first, and the device it was last about;
+	// "turn it off" is resolved against them.
+	History    []Exchange
+	LastDevice string
+}
+
+// Exchange is one earlier turn: what was said, how it was understood and
+// the question VOX asked back, if any.
+type Exchange struct {
+	Query    string `json:"query"`
+	Result   Result `json:"result"`
+	Question string `json:"question,omitempty"`
+}
+
 type Result struct {
-	Intent   string            `json:"intent"`
-	Entities map[string]string `json:"entities"`
-	Query    string            `json:"query"`
+	Intent     string            `json:"intent"`
+	Entities   map[string]string `json:"entities"`
+	Query      string            `json:"query"`
+	Source     string            `json:"source,omitempty"`
+	Confidence float64           `json:"confidence,omitempty"`
 }
 
 const systemPrompt = `
@@ -46,25 +82,30 @@ ENTITIES (strict canonical schema):
 {
   "device": "<canonical ID or null>",
   "brightness": <int or null>,
+  "mode": "<string or null>",
   "time": "<string or null>",
   "date": "<string or null>",
 }
 
 DEVICE REGISTRY (canonical identifiers):
-- "lamp"          = ночник, лампа, night lamp, desk lamp, подсветка
-- "led"           = союз печать, союз печаль
-- "timer"         = термометр, погода, терми, weather display
-- "alarm"         = колонки, аудио, sound, speakers
-
+%s
 RULES FOR DEVICES:
 - Map ANY synonyms to the canonical id.
+- A device takes only the intents listed with it.
+- A command for anything not listed here, or an intent its device does not
+  take, is intent = "unknown".
+- A group id stands for all of its devices: use it for "everything", "всё".
 - If multiple devices mentioned — choose the MAIN one (the one acted upon).
 - If no device is relevant — output null for device.
 
 ENTITY NORMALIZATION:
 - brightness/volume must be 0–255 integers if present.
 - colors: canonicalize to simple English: "red", "blue", "warm_white", etc.
-- time/date: keep raw phrase ("tomorrow", "at 7", "in the evening").
+- time: 24h "HH:MM" when a clock time is said, otherwise the raw phrase
+  ("in 10 minutes", "at 7 in the evening").
+- date: "YYYY-MM-DD" when a calendar date is said, otherwise the raw
+  phrase ("today", "tomorrow").
+- mode: short lowercase name ("night", "rainbow").
 - Never invent missing values.
 
 If the meaning is unclear → intent = "unknown".
@@ -73,36 +114,144 @@ Be strict and minimal.
 Do not generate text other than the JSON.
 `
 
-func Analyze(client openai.Client, transcript string) (Result, error) {
-	ctx := context.Background()
+// Analyze classifies transcript. In hybrid mode the rule matcher answers
+// when it is confident enough, and also stands in when the LLM cannot be
+// reached.
+func Analyze(ctx context.Context, client openai.Client, cfg Config, transcript string) (Result, error) {
+	if cfg.Registry == nil {
+		cfg.Registry = DefaultRegistry()
+	}
 
-	resp, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(systemPrompt),
-			openai.UserMessage(transcript),
-		},
-		Model: openai.ChatModelGPT5Nano,
-	})
-	if err != nil {
-		return Result{}, fmt.Errorf("chat completion: %w", err)
+	if cfg.Mode == ModeLLM {
+		return analyzeLLM(ctx, client, cfg, transcript)
 	}
 
-	if len(resp.Choices) == 0 {
-		return Result{}, fmt.Errorf("no choices in response")
+	rules := matchRules(cfg.Registry, transcript, cfg.LastDevice)
+	log.Debug("Rules matched", "intent", rules.Intent, "entities", rules.Entities, "confidence", rules.Confidence)
+
+	if rules.Confidence >= cfg.MinConfidence {
+		return rules, nil
+	}
+	if cfg.Mode == ModeOffline {
+		rules.Intent = "unknown"
+		return rules, nil
+	}
+
+	out, err := analyzeLLM(ctx, client, cfg, transcript)
+	if err != nil && ctx.Err() == nil && rules.Intent != "unknown" {
+		log.Warn("LLM unavailable, using rule match", "confidence", rules.Confidence, "err", err)
+		return rules, nil
 	}
+	return out, err
+}
+
+// contextPrompt carries the conversation so far.
+const contextPrompt = `CONVERSATION SO FAR (oldest first):
+%s
+Resolve pronouns ("it", "его", "её") and elliptical commands ("and now off",
+"а теперь 50 процентов") against it. If VOX asked a question, the utterance
+is most likely the answer: repeat that command with the missing entity
+filled in.`
 
-	content := resp.Choices[0].Message.Content
-	if content == "" {
-		return Result{}, fmt.Errorf("empty message content")
+func historyPrompt(history []Exchange, last string) string {
+	var b strings.Builder
+	for _, ex := range history {
+		res, _ := json.Marshal(struct {
+			Intent   string            `json:"intent"`
+			Entities map[string]string `json:"entities"`
+		}{ex.Result.Intent, ex.Result.Entities})
+		fmt.Fprintf(&b, "- user: %q -> %s\n", ex.Query, res)
+		if ex.Question != "" {
+			fmt.Fprintf(&b, "- VOX asked: %q\n", ex.Question)
+		}
+	}
+	if last != "" {
+		fmt.Fprintf(&b, "- last device: %q\n", last)
 	}
+	return fmt.Sprintf(contextPrompt, b.String())
+}
 
-	var out Result
+// repairPrompt goes back to the model with its invalid reply.
+const repairPrompt = `That reply does not match the required schema: %v.
+Answer again with only the corrected JSON object.`
 
-	log.Debug("Processed", "data", content)
+// analyzeLLM asks the model for a Result constrained by the JSON schema.
+// A reply that still fails validation gets one repair round-trip.
+func analyzeLLM(ctx context.Context, client openai.Client, cfg Config, transcript string) (Result, error) {
+	model := openai.ChatModelGPT5Nano
+	if cfg.Model != "" {
+		model = cfg.Model
+	}
 
-	if err := json.Unmarshal([]byte(content), &out); err != nil {
-		return Result{}, fmt.Errorf("unmarshal NLU result: %w (raw: %s)", err, content)
+	messages := []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(fmt.Sprintf(systemPrompt, cfg.Registry.prompt())),
+	}
+	if len(cfg.History) > 0 || cfg.LastDevice != "" {
+		messages = append(messages, openai.SystemMessage(historyPrompt(cfg.History, cfg.LastDevice)))
 	}
+	messages = append(messages, openai.UserMessage(transcript))
+
+	params := openai.ChatCompletionNewParams{
+		Messages: messages,
+		Model:    model,
+		ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
+				JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   "vox_nlu_result",
+					Strict: openai.Bool(true),
+					Schema: resultSchema(cfg.Registry),
+				},
+			},
+		},
+	}
+
+	content, err := complete(ctx, client, params)
+	if err != nil {
+		return Result{}, err
+	}
+
+	out, err := parseResult(content, cfg.Registry)
+	if err != nil {
+		log.Warn("Invalid NLU output, asking for a repair", "err", err)
+
+		params.Messages = append(params.Messages,
+			openai.AssistantMessage(content),
+			openai.UserMessage(fmt.Sprintf(repairPrompt, err)),
+		)
+		content, err = complete(ctx, client, params)
+		if err != nil {
+			return Result{}, err
+		}
+
+		out, err = parseResult(content, cfg.Registry)
+		if err != nil {
+			return Result{}, &ValidationError{Raw: content, Err: err}
+		}
+	}
+	out.Source = SourceLLM
 
 	return out, nil
 }
+
+func complete(ctx context.Context, client openai.Client, params openai.ChatCompletionNewParams) (string, error) {
+	resp, err := client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return "", fmt.Errorf("chat completion: %w", err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no choices in response")
+	}
+
+	msg := resp.Choices[0].Message
+	if msg.Refusal != "" {
+		return "", fmt.Errorf("model refused: %s", msg.Refusal)
+	}
+	if msg.Content == "" {
+		return "", fmt.Errorf("empty message content")
+	}
+
+	log.Debug("Processed", "data", msg.Content)
+
+	return msg.Content, nil
+}
@@ -0,0 +1,325 @@
+package nlu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Intents is every intent the NLU may report, in prompt order.
+var Intents = []string{
+	"turn_on",
+	"turn_off",
+	"set_brightness",
+	"set_mode",
+	"set_time",
+	"stop",
+}
+
+// Registry describes the devices VOX controls. The NLU prompt and the rule
+// matcher take their vocabulary from it and Dispatch its routes, so adding
+// a device is an edit to the registry file.
+type Registry struct {
+	Devices []Device `toml:"device" json:"devices"`
+	Groups  []Group  `toml:"group" json:"groups"`
+}
+
+// Device is one registry entry: the canonical id the NLU reports, the words
+// people use for it per language, and where its commands go on the hub.
+type Device struct {
+	ID       string              `toml:"id" json:"id"`
+	Synonyms map[string][]string `toml:"synonyms" json:"synonyms"` // language -> words
+	To       string              `toml:"to" json:"to"`             // target shard
+	Noun     string              `toml:"noun" json:"noun"`
+	Intents  map[string]Action   `toml:"intents" json:"intents"`
+}
+
+// Group stands for several devices at once, "всё" or "the lights". A
+// command for a group goes to every member that supports its intent.
+type Group struct {
+	ID       string              `toml:"id" json:"id"`
+	Synonyms map[string][]string `toml:"synonyms" json:"synonyms"`
+	Devices  []string            `toml:"devices" json:"devices"` // empty for every device
+}
+
+// Action is how one intent reaches a device: the protocol verb and the
+// entities that become its ARG tokens, in order.
+type Action struct {
+	Verb string   `toml:"verb" json:"verb"`
+	Args []string `toml:"args" json:"args"`
+}
+
+// DefaultRegistry is used when no registry file is configured.
+func DefaultRegistry() *Registry {
+	return &Registry{Devices: []Device{
+		{
+			ID: "lamp",
+			Synonyms: map[string][]string{
+				"ru": {"ночник", "лампа", "подсветка"},
+				"en": {"night lamp", "desk lamp"},
+			},
+			To:   "VERTEX",
+			Noun: "LAMP",
+			Intents: map[string]Action{
+				"turn_on":        {Verb: "ON"},
+				"turn_off":       {Verb: "OFF"},
+				"set_brightness": {Verb: "SET", Args: []string{"brightness"}},
+			},
+		},
+		{
+			ID:       "led",
+			Synonyms: map[string][]string{"ru": {"союз печать", "союз печаль"}},
+		},
+		{
+			ID: "timer",
+			Synonyms: map[string][]string{
+				"ru": {"термометр", "погода", "терми"},
+				"en": {"weather display"},
+			},
+		},
+		{
+			ID: "alarm",
+			Synonyms: map[string][]string{
+				"ru": {"колонки", "аудио"},
+				"en": {"sound", "speakers"},
+			},
+		},
+	}, Groups: []Group{
+		{
+			ID: "all",
+			Synonyms: map[string][]string{
+				"ru": {"всё", "все", "везде", "все устройства"},
+				"en": {"everything", "all", "all devices"},
+			},
+		},
+	}}
+}
+
+// LoadRegistry reads a registry from a .toml or .json file.
+func LoadRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var reg Registry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		md, err := toml.Decode(string(data), &reg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if und := md.Undecoded(); len(und) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %s", path, und[0])
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&reg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported registry format, want .toml or .json", path)
+	}
+
+	if err := reg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &reg, nil
+}
+
+var hubTokenRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func (r *Registry) Validate() error {
+	var errs []error
+	bad := func(id, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("device %q: %s", id, fmt.Sprintf(format, args...)))
+	}
+
+	seen := map[string]bool{}
+	for i, d := range r.Devices {
+		if d.ID == "" {
+			errs = append(errs, fmt.Errorf("device #%d: id must not be empty", i+1))
+			continue
+		}
+		if seen[d.ID] {
+			bad(d.ID, "defined twice")
+		}
+		seen[d.ID] = true
+
+		if d.To != "" && !hubTokenRe.MatchString(d.To) {
+			bad(d.ID, "to %q is not a protocol token", d.To)
+		}
+		if d.Noun != "" && !hubTokenRe.MatchString(d.Noun) {
+			bad(d.ID, "noun %q is not a protocol token", d.Noun)
+		}
+		if len(d.Intents) > 0 && (d.To == "" || d.Noun == "") {
+			bad(d.ID, "intents need both to and noun")
+		}
+		for intent, a := range d.Intents {
+			if !slices.Contains(Intents, intent) {
+				bad(d.ID, "unknown intent %q, want one of %s", intent, strings.Join(Intents, "|"))
+			}
+			if !hubTokenRe.MatchString(a.Verb) {
+				bad(d.ID, "intents.%s.verb %q is not a protocol token", intent, a.Verb)
+			}
+		}
+	}
+
+	for i, g := range r.Groups {
+		if g.ID == "" {
+			errs = append(errs, fmt.Errorf("group #%d: id must not be empty", i+1))
+			continue
+		}
+		if seen[g.ID] {
+			errs = append(errs, fmt.Errorf("group %q: id already used", g.ID))
+		}
+		seen[g.ID] = true
+
+		for _, id := range g.Devices {
+			if _, ok := r.Device(id); !ok {
+				errs = append(errs, fmt.Errorf("group %q: unknown device %q", g.ID, id))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Group looks a group up by id.
+func (r *Registry) Group(id string) (Group, bool) {
+	i := slices.IndexFunc(r.Groups, func(g Group) bool { return g.ID == id })
+	if i < 0 {
+		return Group{}, false
+	}
+	return r.Groups[i], true
+}
+
+// Has reports whether id is a device or a group.
+func (r *Registry) Has(id string) bool {
+	_, dev := r.Device(id)
+	_, group := r.Group(id)
+	return dev || group
+}
+
+// Routed reports whether the device or group id takes any intent at all;
+// the rest are only known by name.
+func (r *Registry) Routed(id string) bool {
+	return slices.ContainsFunc(Intents, func(intent string) bool {
+		return r.Supports(id, intent)
+	})
+}
+
+// Members returns the devices of g that support intent, in registry order.
+func (r *Registry) Members(g Group, intent string) []Device {
+	var out []Device
+	for _, d := range r.Devices {
+		if (len(g.Devices) == 0 || slices.Contains(g.Devices, d.ID)) && d.Supports(intent) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Supports reports whether intent can be sent to the device or group id.
+func (r *Registry) Supports(id, intent string) bool {
+	if d, ok := r.Device(id); ok {
+		return d.Supports(intent)
+	}
+	if g, ok := r.Group(id); ok {
+		return len(r.Members(g, intent)) > 0
+	}
+	return false
+}
+
+// Device looks a device up by canonical id.
+func (r *Registry) Device(id string) (Device, bool) {
+	i := slices.IndexFunc(r.Devices, func(d Device) bool { return d.ID == id })
+	if i < 0 {
+		return Device{}, false
+	}
+	return r.Devices[i], true
+}
+
+// Name is what to call the device or group id when speaking lang: its
+// first synonym in that language, else the id.
+func (r *Registry) Name(id, lang string) string {
+	var synonyms map[string][]string
+	if d, ok := r.Device(id); ok {
+		synonyms = d.Synonyms
+	} else if g, ok := r.Group(id); ok {
+		synonyms = g.Synonyms
+	}
+	if s := synonyms[lang]; len(s) > 0 {
+		return s[0]
+	}
+	return id
+}
+
+// Names returns the id and every synonym, languages in sorted order.
+func (d Device) Names() []string {
+	return names(d.ID, d.Synonyms)
+}
+
+// Names returns the id and every synonym, languages in sorted order.
+func (g Group) Names() []string {
+	return names(g.ID, g.Synonyms)
+}
+
+func names(id string, synonyms map[string][]string) []string {
+	names := []string{id}
+	langs := make([]string, 0, len(synonyms))
+	for lang := range synonyms {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	for _, lang := range langs {
+		names = append(names, synonyms[lang]...)
+	}
+	return names
+}
+
+// Supports reports whether the device has a route for intent.
+func (d Device) Supports(intent string) bool {
+	_, ok := d.Intents[intent]
+	return ok
+}
+
+// prompt renders the registry the way the system prompt lists it. Devices
+// and groups without routes are left out: nothing could be sent to them.
+func (r *Registry) prompt() string {
+	var b strings.Builder
+	for _, d := range r.Devices {
+		if !r.Routed(d.ID) {
+			continue
+		}
+		fmt.Fprintf(&b, "- %-15s = %s", fmt.Sprintf("%q", d.ID), strings.Join(d.Names()[1:], ", "))
+
+		var intents []string
+		for _, intent := range Intents {
+			if d.Supports(intent) {
+				intents = append(intents, intent)
+			}
+		}
+		fmt.Fprintf(&b, " (intents: %s)\n", strings.Join(intents, ", "))
+	}
+	for _, g := range r.Groups {
+		if !r.Routed(g.ID) {
+			continue
+		}
+		members := "every device"
+		if len(g.Devices) > 0 {
+			members = strings.Join(g.Devices, ", ")
+		}
+		fmt.Fprintf(&b, "- %-15s = %s (group: %s)\n", fmt.Sprintf("%q", g.ID), strings.Join(g.Names()[1:], ", "), members)
+	}
+	return b.String()
+}
@@ -0,0 +1,387 @@
+package nlu
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The rule matcher covers the everyday commands without a round trip to the
+// LLM: "включи лампу", "turn the night lamp off", "яркость ночника 40%".
+// Everything it cannot account for lowers the confidence, so anything
+// unusual still ends up with the LLM.
+
+const (
+	// confidence of a fully accounted for utterance
+	ruleConfidence = 0.95
+	// cost of every word the rules do not know
+	unknownPenalty = 0.15
+	// cost of a missing device or value; the LLM cannot invent them either,
+	// so the dialogue asks for them instead
+	missingPenalty = 0.1
+	// cap when the utterance names several devices, numbers or opposite
+	// intents
+	ambiguousConfidence = 0.4
+)
+
+type wordKind int
+
+const (
+	wordFiller wordKind = iota
+	wordIntent
+	wordBrightness
+	wordPercent
+	wordLevel
+)
+
+type word struct {
+	kind   wordKind
+	intent string // wordIntent
+	level  int    // wordLevel, 0–255
+}
+
+// stems match any word they prefix, which is enough for Russian endings:
+// "включи", "включите", "включить".
+var ruleStems = []struct {
+	stem string
+	word word
+}{
+	{"включ", word{kind: wordIntent, intent: "turn_on"}},
+	{"зажг", word{kind: wordIntent, intent: "turn_on"}},
+	{"зажеч", word{kind: wordIntent, intent: "turn_on"}},
+	{"вруб", word{kind: wordIntent, intent: "turn_on"}},
+	{"выключ", word{kind: wordIntent, intent: "turn_off"}},
+	{"отключ", word{kind: wordIntent, intent: "turn_off"}},
+	{"погас", word{kind: wordIntent, intent: "turn_off"}},
+	{"потуш", word{kind: wordIntent, intent: "turn_off"}},
+	{"выруб", word{kind: wordIntent, intent: "turn_off"}},
+
+	{"яркост", word{kind: wordBrightness}},
+	{"brightness", word{kind: wordBrightness}},
+	{"процент", word{kind: wordPercent}},
+	{"percent", word{kind: wordPercent}},
+
+	{"максим", word{kind: wordLevel, level: 255}},
+	{"полную", word{kind: wordLevel, level: 255}},
+	{"миним", word{kind: wordLevel, level: 1}},
+	{"половин", word{kind: wordLevel, level: 128}},
+}
+
+// ruleWords match whole words only.
+var ruleWords = map[string]word{
+	"on":         {kind: wordIntent, intent: "turn_on"},
+	"enable":     {kind: wordIntent, intent: "turn_on"},
+	"off":        {kind: wordIntent, intent: "turn_off"},
+	"disable":    {kind: wordIntent, intent: "turn_off"},
+	"dim":        {kind: wordBrightness},
+	"%":          {kind: wordPercent},
+	"max":        {kind: wordLevel, level: 255},
+	"maximum":    {kind: wordLevel, level: 255},
+	"full":       {kind: wordLevel, level: 255},
+	"min":        {kind: wordLevel, level: 1},
+	"minimum":    {kind: wordLevel, level: 1},
+	"half":       {kind: wordLevel, level: 128},
+	"please":     {},
+	"the":        {},
+	"a":          {},
+	"an":         {},
+	"to":         {},
+	"at":         {},
+	"of":         {},
+	"my":         {},
+	"set":        {},
+	"make":       {},
+	"turn":       {},
+	"switch":     {},
+	"power":      {},
+	"пожалуйста": {},
+	"на":         {},
+	"в":          {},
+	"во":         {},
+	"до":         {},
+	"мне":        {},
+	"поставь":    {},
+	"установи":   {},
+	"сделай":     {},
+	"вокс":       {},
+	"vox":        {},
+
+	// pronouns and ellipsis refer to the last device: "а теперь выключи его"
+	"it":     {},
+	"them":   {},
+	"that":   {},
+	"now":    {},
+	"too":    {},
+	"again":  {},
+	"его":    {},
+	"ее":     {},
+	"их":     {},
+	"это":    {},
+	"а":      {},
+	"теперь": {},
+	"тоже":   {},
+	"снова":  {},
+	"опять":  {},
+}
+
+// matchRules classifies text with the rule matcher. Intent is "unknown"
+// when no rule applies, though a named device is still reported; Confidence
+// says how much of the utterance the rules accounted for. A command without
+// a device goes to last when that supports it.
+func matchRules(reg *Registry, text, last string) Result {
+	out := Result{
+		Intent:   "unknown",
+		Entities: map[string]string{},
+		Query:    text,
+		Source:   SourceRules,
+	}
+
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return out
+	}
+
+	var (
+		intents    = map[string]bool{}
+		devices    []string
+		numbers    []int
+		levels     []int
+		brightness bool
+		percent    bool
+		unknown    int
+	)
+
+	for i := 0; i < len(tokens); {
+		if id, n := matchDevice(reg, tokens[i:]); n > 0 {
+			if _, group := reg.Group(id); group && quantifies(reg, tokens[i+n:]) {
+				// "все лампы", "all the lamps" are about the lamps
+				i += n
+				continue
+			}
+			if !slices.Contains(devices, id) {
+				devices = append(devices, id)
+			}
+			i += n
+			continue
+		}
+
+		tok := tokens[i]
+		i++
+
+		if v, err := strconv.Atoi(tok); err == nil {
+			numbers = append(numbers, v)
+			continue
+		}
+
+		w, ok := lookupWord(tok)
+		if !ok {
+			unknown++
+			continue
+		}
+		switch w.kind {
+		case wordIntent:
+			intents[w.intent] = true
+		case wordBrightness:
+			brightness = true
+		case wordPercent:
+			percent = true
+		case wordLevel:
+			levels = append(levels, w.level)
+		}
+	}
+
+	// a level or a percentage implies brightness: "включи лампу на 50%"
+	if brightness || percent || len(levels) > 0 {
+		out.Intent = "set_brightness"
+	} else if len(intents) == 1 {
+		for intent := range intents {
+			out.Intent = intent
+		}
+	} else {
+		// nothing to do, or "turn on ... off"; a bare device name may still
+		// answer a question
+		if len(devices) == 1 {
+			out.Entities["device"] = devices[0]
+		}
+		return out
+	}
+
+	conf := ruleConfidence
+	ambiguous := len(devices) > 1 || intents["turn_on"] && intents["turn_off"]
+
+	if len(devices) > 0 {
+		out.Entities["device"] = devices[0]
+		// the registry has no route for it, the LLM may know better
+		if !reg.Supports(devices[0], out.Intent) {
+			ambiguous = true
+		}
+	} else if reg.Supports(last, out.Intent) {
+		out.Entities["device"] = last
+	} else {
+		conf -= missingPenalty
+	}
+
+	if out.Intent == "set_brightness" {
+		value, ok := -1, false
+		switch {
+		case len(numbers)+len(levels) > 1:
+			ambiguous = true
+		case len(numbers) == 1:
+			value, ok = scaleBrightness(numbers[0], percent), true
+		case len(levels) == 1:
+			value, ok = levels[0], true
+		}
+		if ok {
+			out.Entities["brightness"] = strconv.Itoa(value)
+		} else {
+			conf -= missingPenalty
+		}
+		// "включи" in front of a brightness is fine, "выключи" is not
+		if intents["turn_off"] {
+			ambiguous = true
+		}
+	} else {
+		// numbers mean something the rules do not model, "через 5 минут"
+		unknown += len(numbers)
+	}
+
+	conf -= unknownPenalty * float64(unknown)
+	if ambiguous {
+		conf = min(conf, ambiguousConfidence)
+	}
+
+	out.Confidence = math.Round(max(conf, 0)*100) / 100
+	return out
+}
+
+// scaleBrightness maps a spoken value onto 0–255. Values up to 100 are
+// taken as a percentage, which is how people say it either way.
+func scaleBrightness(v int, percent bool) int {
+	if percent || v <= 100 {
+		v = int(math.Round(float64(min(max(v, 0), 100)) * 255 / 100))
+	}
+	return min(max(v, 0), 255)
+}
+
+func lookupWord(tok string) (word, bool) {
+	if w, ok := ruleWords[tok]; ok {
+		return w, true
+	}
+	for _, s := range ruleStems {
+		if strings.HasPrefix(tok, s.stem) {
+			return s.word, true
+		}
+	}
+	return word{}, false
+}
+
+// quantifies reports whether tokens, past any filler words, start with a
+// device name, which a group word just in front of it only counts.
+func quantifies(reg *Registry, tokens []string) bool {
+	for i, tok := range tokens {
+		if w, ok := lookupWord(tok); ok && w.kind == wordFiller {
+			continue
+		}
+		id, n := matchDevice(reg, tokens[i:])
+		if n == 0 {
+			return false
+		}
+		_, group := reg.Group(id)
+		return !group
+	}
+	return false
+}
+
+// matchDevice returns the device or group whose longest synonym starts
+// tokens and the number of tokens it covers.
+func matchDevice(reg *Registry, tokens []string) (string, int) {
+	var (
+		best string
+		n    int
+	)
+	targets := make([][]string, 0, len(reg.Devices)+len(reg.Groups))
+	for _, d := range reg.Devices {
+		targets = append(targets, d.Names())
+	}
+	for _, g := range reg.Groups {
+		targets = append(targets, g.Names())
+	}
+	for _, names := range targets {
+		for _, name := range names {
+			words := tokenize(name)
+			if len(words) <= n || len(words) > len(tokens) {
+				continue
+			}
+			match := true
+			for i, w := range words {
+				if !sameWord(tokens[i], w) {
+					match = false
+					break
+				}
+			}
+			if match {
+				best, n = names[0], len(words)
+			}
+		}
+	}
+	return best, n
+}
+
+// sameWord compares a spoken token with a registry word up to a short
+// ending: "лампу" is "лампа", "ночника" is "ночник", "lamps" is "lamp".
+// Words of three letters or less have to match exactly, or "все" would be
+// "всего" and "all" "allow".
+func sameWord(tok, w string) bool {
+	if tok == w {
+		return true
+	}
+	if utf8.RuneCountInString(w) <= 3 {
+		return false
+	}
+	stem := w
+	if r, size := utf8.DecodeLastRuneInString(w); strings.ContainsRune("аеиоуыэюяйь", r) {
+		stem = w[:len(w)-size]
+	}
+	return strings.HasPrefix(tok, stem) &&
+		utf8.RuneCountInString(tok)-utf8.RuneCountInString(stem) <= 3
+}
+
+// tokenize lowercases text and splits it into words and numbers; "%" is a
+// word of its own.
+func tokenize(text string) []string {
+	var (
+		tokens []string
+		cur    strings.Builder
+	)
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == 'ё':
+			cur.WriteRune('е')
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			// "50процентов", "lamp2" are two words
+			if cur.Len() > 0 {
+				last, _ := utf8.DecodeLastRuneInString(cur.String())
+				if unicode.IsDigit(last) != unicode.IsDigit(r) {
+					flush()
+				}
+			}
+			cur.WriteRune(r)
+		case r == '%':
+			flush()
+			tokens = append(tokens, "%")
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
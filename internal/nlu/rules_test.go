@@ -0,0 +1,138 @@
+package nlu
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+// minConfidence is the default nlu.min_confidence: rule results at or
+// above it are used as they are, the rest go to the LLM.
+const minConfidence = 0.8
+
+func TestMatchRules(t *testing.T) {
+	tests := []struct {
+		text, last string
+		intent     string
+		entities   map[string]string
+		confident  bool // at least minConfidence
+	}{
+		// the everyday commands
+		{text: "включи лампу", intent: "turn_on", entities: map[string]string{"device": "lamp"}, confident: true},
+		{text: "Включи ночник, пожалуйста", intent: "turn_on", entities: map[string]string{"device": "lamp"}, confident: true},
+		{text: "выключи подсветку", intent: "turn_off", entities: map[string]string{"device": "lamp"}, confident: true},
+		{text: "Turn the night lamp off", intent: "turn_off", entities: map[string]string{"device": "lamp"}, confident: true},
+		{text: "яркость ночника 40%", intent: "set_brightness", entities: map[string]string{"device": "lamp", "brightness": "102"}, confident: true},
+		{text: "яркость ночника 40 процентов", intent: "set_brightness", entities: map[string]string{"device": "lamp", "brightness": "102"}, confident: true},
+		{text: "set the desk lamp to 50 percent", intent: "set_brightness", entities: map[string]string{"device": "lamp", "brightness": "128"}, confident: true},
+		{text: "dim the lamp to 30%", intent: "set_brightness", entities: map[string]string{"device": "lamp", "brightness": "77"}, confident: true},
+		{text: "включи лампу на полную", intent: "set_brightness", entities: map[string]string{"device": "lamp", "brightness": "255"}, confident: true},
+
+		// groups, and group words that only count a device
+		{text: "выключи всё", intent: "turn_off", entities: map[string]string{"device": "all"}, confident: true},
+		{text: "turn off everything", intent: "turn_off", entities: map[string]string{"device": "all"}, confident: true},
+		{text: "выключи все лампы", intent: "turn_off", entities: map[string]string{"device": "lamp"}, confident: true},
+		{text: "turn off all the lamps", intent: "turn_off", entities: map[string]string{"device": "lamp"}, confident: true},
+		{text: "выключи всего одну лампу", intent: "turn_off", entities: map[string]string{"device": "lamp"}},
+
+		// the last device stands in for a missing or referred to one
+		{text: "а теперь выключи его", last: "lamp", intent: "turn_off", entities: map[string]string{"device": "lamp"}, confident: true},
+		{text: "яркость на максимум", last: "lamp", intent: "set_brightness", entities: map[string]string{"device": "lamp", "brightness": "255"}, confident: true},
+		{text: "turn it off", last: "alarm", intent: "turn_off", entities: map[string]string{}, confident: true},
+
+		// missing entities are left for the dialogue to ask, not the LLM
+		{text: "turn it off", intent: "turn_off", entities: map[string]string{}, confident: true},
+		{text: "яркость на максимум", intent: "set_brightness", entities: map[string]string{"brightness": "255"}, confident: true},
+		{text: "яркость лампы", intent: "set_brightness", entities: map[string]string{"device": "lamp"}, confident: true},
+
+		// anything unusual goes to the LLM
+		{text: "включи лампу через 5 минут", intent: "turn_on", entities: map[string]string{"device": "lamp"}},
+		{text: "выключи колонки", intent: "turn_off", entities: map[string]string{"device": "alarm"}},
+		{text: "выключи лампу на 50%", intent: "set_brightness", entities: map[string]string{"device": "lamp", "brightness": "128"}},
+		{text: "яркость лампы 40 60", intent: "set_brightness", entities: map[string]string{"device": "lamp"}},
+		{text: "включи лампу и колонки", intent: "turn_on", entities: map[string]string{"device": "lamp"}},
+		{text: "включи и выключи лампу", intent: "unknown", entities: map[string]string{"device": "lamp"}},
+		{text: "лампа", intent: "unknown", entities: map[string]string{"device": "lamp"}},
+		{text: "what's the weather like", intent: "unknown", entities: map[string]string{}},
+		{text: "allow the lamp", intent: "unknown", entities: map[string]string{"device": "lamp"}},
+		{text: "", intent: "unknown", entities: map[string]string{}},
+	}
+
+	reg := DefaultRegistry()
+	for _, tt := range tests {
+		got := matchRules(reg, tt.text, tt.last)
+		if got.Intent != tt.intent || !maps.Equal(got.Entities, tt.entities) {
+			t.Errorf("%q (last %q) = %s %v, want %s %v", tt.text, tt.last, got.Intent, got.Entities, tt.intent, tt.entities)
+		}
+		if confident := got.Confidence >= minConfidence; confident != tt.confident {
+			t.Errorf("%q (last %q): confidence %.2f, want it confident: %v", tt.text, tt.last, got.Confidence, tt.confident)
+		}
+		if got.Source != SourceRules || got.Query != tt.text {
+			t.Errorf("%q: source %q, query %q", tt.text, got.Source, got.Query)
+		}
+		if got.Intent == "unknown" && got.Confidence != 0 {
+			t.Errorf("%q: unknown with confidence %.2f", tt.text, got.Confidence)
+		}
+	}
+}
+
+// Unknown words cost confidence one by one.
+func TestMatchRulesPenalty(t *testing.T) {
+	reg := DefaultRegistry()
+	prev := matchRules(reg, "включи лампу", "").Confidence
+	for _, text := range []string{
+		"включи лампу быстро",
+		"включи лампу быстро сейчас",
+		"включи лампу быстро сейчас же",
+	} {
+		got := matchRules(reg, text, "")
+		if got.Intent != "turn_on" || got.Confidence >= prev {
+			t.Errorf("%q = %s %.2f, want turn_on below %.2f", text, got.Intent, got.Confidence, prev)
+		}
+		prev = got.Confidence
+	}
+}
+
+func TestSameWord(t *testing.T) {
+	tests := []struct {
+		tok, word string
+		want      bool
+	}{
+		{"лампу", "лампа", true},
+		{"лампы", "лампа", true},
+		{"лампочку", "лампа", false},
+		{"ночника", "ночник", true},
+		{"подсветку", "подсветка", true},
+		{"lamps", "lamp", true},
+		{"lamp", "lamp", true},
+		{"lampshade", "lamp", false},
+		{"все", "все", true},
+		{"всего", "все", false},
+		{"всех", "все", false},
+		{"all", "all", true},
+		{"allow", "all", false},
+		{"led", "led", true},
+		{"leds", "led", false},
+	}
+	for _, tt := range tests {
+		if got := sameWord(tt.tok, tt.word); got != tt.want {
+			t.Errorf("sameWord(%q, %q) = %v", tt.tok, tt.word, got)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	for text, want := range map[string][]string{
+		"Включи ЛАМПУ!":            {"включи", "лампу"},
+		"яркость 50%":              {"яркость", "50", "%"},
+		"яркость 50процентов":      {"яркость", "50", "процентов"},
+		"выключи всё, пожалуйста":  {"выключи", "все", "пожалуйста"},
+		"turn lamp2 off":           {"turn", "lamp", "2", "off"},
+		"  ":                       nil,
+		"what's the weather like?": {"what", "s", "the", "weather", "like"},
+	} {
+		if got := tokenize(text); !slices.Equal(got, want) {
+			t.Errorf("tokenize(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
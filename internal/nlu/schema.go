@@ -0,0 +1,157 @@
+package nlu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ValidationError is returned by Analyze when the model's reply still does
+// not fit the Result schema after the repair round-trip.
+type ValidationError struct {
+	Raw string // the last reply
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid NLU output: %v (raw: %s)", e.Err, e.Raw)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// wireResult is Result the way the model writes it; the schema makes every
+// key present, with null for the entities it did not hear.
+type wireResult struct {
+	Intent   string `json:"intent"`
+	Entities struct {
+		Device     *string `json:"device"`
+		Brightness *int    `json:"brightness"`
+		Mode       *string `json:"mode"`
+		Time       *string `json:"time"`
+		Date       *string `json:"date"`
+	} `json:"entities"`
+	Query string `json:"query"`
+}
+
+// resultSchema is the JSON schema of wireResult for strict structured
+// output, with the intents and the ids of routed devices taken from reg.
+func resultSchema(reg *Registry) map[string]any {
+	nullable := func(t string) map[string]any {
+		return map[string]any{"type": []string{t, "null"}}
+	}
+
+	devices := []any{nil}
+	for _, d := range reg.Devices {
+		if reg.Routed(d.ID) {
+			devices = append(devices, d.ID)
+		}
+	}
+	for _, g := range reg.Groups {
+		if reg.Routed(g.ID) {
+			devices = append(devices, g.ID)
+		}
+	}
+	device := nullable("string")
+	device["enum"] = devices
+
+	brightness := nullable("integer")
+	brightness["minimum"] = 0
+	brightness["maximum"] = 255
+
+	return map[string]any{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"intent", "entities", "query"},
+		"properties": map[string]any{
+			"intent": map[string]any{
+				"type": "string",
+				"enum": append(slices.Clone(Intents), "unknown"),
+			},
+			"entities": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"required":             []string{"device", "brightness", "mode", "time", "date"},
+				"properties": map[string]any{
+					"device":     device,
+					"brightness": brightness,
+					"mode":       nullable("string"),
+					"time":       nullable("string"),
+					"date":       nullable("string"),
+				},
+			},
+			"query": map[string]any{"type": "string"},
+		},
+	}
+}
+
+// parseResult decodes and checks a model reply. Servers that ignore the
+// response format tend to wrap JSON in a markdown fence, which is dropped.
+// A command for a device nothing can be sent to is taken as not understood
+// rather than invalid.
+func parseResult(content string, reg *Registry) (Result, error) {
+	content = strings.TrimSpace(content)
+	if body, ok := strings.CutPrefix(content, "```"); ok {
+		body = strings.TrimPrefix(body, "json")
+		content = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(body), "```"))
+	}
+
+	var w wireResult
+	dec := json.NewDecoder(bytes.NewReader([]byte(content)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&w); err != nil {
+		return Result{}, err
+	}
+	if dec.More() {
+		return Result{}, errors.New("trailing data after the JSON object")
+	}
+
+	var problems []string
+	known := slices.Contains(Intents, w.Intent)
+	if w.Intent != "unknown" && !known {
+		problems = append(problems, fmt.Sprintf("intent %q is not one of %s|unknown", w.Intent, strings.Join(Intents, "|")))
+	}
+
+	out := Result{
+		Intent:   w.Intent,
+		Entities: map[string]string{},
+		Query:    w.Query,
+	}
+
+	e := w.Entities
+	if e.Device != nil && *e.Device != "" {
+		dev := *e.Device
+		switch {
+		case !reg.Has(dev):
+			problems = append(problems, fmt.Sprintf("entities.device %q is not in the registry", dev))
+		case !reg.Routed(dev):
+			// no repair could make it dispatchable; let the dialogue ask
+			out.Intent = "unknown"
+		case known && !reg.Supports(dev, w.Intent):
+			// valid on its own, but Dispatch would have nowhere to send it
+			problems = append(problems, fmt.Sprintf("entities.device %q does not support intent %q", dev, w.Intent))
+		}
+		out.Entities["device"] = dev
+	}
+	if e.Brightness != nil {
+		if *e.Brightness < 0 || *e.Brightness > 255 {
+			problems = append(problems, fmt.Sprintf("entities.brightness %d is not within 0–255", *e.Brightness))
+		}
+		out.Entities["brightness"] = strconv.Itoa(*e.Brightness)
+	}
+	for name, v := range map[string]*string{"mode": e.Mode, "time": e.Time, "date": e.Date} {
+		if v != nil && *v != "" {
+			out.Entities[name] = *v
+		}
+	}
+
+	if len(problems) > 0 {
+		return Result{}, errors.New(strings.Join(problems, "; "))
+	}
+	return out, nil
+}
@@ -0,0 +1,218 @@
+package nlu
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	openai "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		intent   string
+		entities map[string]string
+		wantErr  string
+	}{
+		{
+			name:     "plain",
+			content:  `{"intent": "turn_on", "entities": {"device": "lamp", "brightness": null, "mode": null, "time": null, "date": null}, "query": ""}`,
+			intent:   "turn_on",
+			entities: map[string]string{"device": "lamp"},
+		},
+		{
+			name: "fenced",
+			content: "```json\n" +
+				`{"intent": "set_brightness", "entities": {"device": "lamp", "brightness": 128, "mode": null, "time": null, "date": null}, "query": ""}` +
+				"\n```",
+			intent:   "set_brightness",
+			entities: map[string]string{"device": "lamp", "brightness": "128"},
+		},
+		{
+			name:     "fenced without a language",
+			content:  "```\n" + `{"intent": "unknown", "entities": {"device": null, "brightness": null, "mode": null, "time": null, "date": null}, "query": "what time is it"}` + "\n```",
+			intent:   "unknown",
+			entities: map[string]string{},
+		},
+		{
+			name:    "unknown key",
+			content: `{"intent": "turn_on", "entities": {"device": "lamp", "color": "red"}, "query": ""}`,
+			wantErr: `unknown field "color"`,
+		},
+		{
+			name:    "trailing data",
+			content: `{"intent": "turn_on", "entities": {}, "query": ""} {}`,
+			wantErr: "trailing data",
+		},
+		{
+			name:    "brightness out of range",
+			content: `{"intent": "set_brightness", "entities": {"device": "lamp", "brightness": 300}, "query": ""}`,
+			wantErr: "entities.brightness 300 is not within 0–255",
+		},
+		{
+			name:    "intent not in the list",
+			content: `{"intent": "dim", "entities": {"device": "lamp"}, "query": ""}`,
+			wantErr: `intent "dim" is not one of`,
+		},
+		{
+			name:    "device not in the registry",
+			content: `{"intent": "turn_on", "entities": {"device": "kettle"}, "query": ""}`,
+			wantErr: `entities.device "kettle" is not in the registry`,
+		},
+		{
+			name:    "unsupported intent for the device",
+			content: `{"intent": "set_mode", "entities": {"device": "lamp", "mode": "night"}, "query": ""}`,
+			wantErr: `entities.device "lamp" does not support intent "set_mode"`,
+		},
+		{
+			name:     "device without routes",
+			content:  `{"intent": "turn_on", "entities": {"device": "led"}, "query": "включи союз печать"}`,
+			intent:   "unknown",
+			entities: map[string]string{"device": "led"},
+		},
+		{
+			name:    "unsupported intent for the group",
+			content: `{"intent": "set_mode", "entities": {"device": "all", "mode": "night"}, "query": ""}`,
+			wantErr: `entities.device "all" does not support intent "set_mode"`,
+		},
+		{
+			name:     "group with a supporting member",
+			content:  `{"intent": "turn_off", "entities": {"device": "all"}, "query": ""}`,
+			intent:   "turn_off",
+			entities: map[string]string{"device": "all"},
+		},
+		{
+			name:    "every problem at once",
+			content: `{"intent": "set_time", "entities": {"device": "lamp", "brightness": -1}, "query": ""}`,
+			wantErr: `entities.device "lamp" does not support intent "set_time"; entities.brightness -1 is not within 0–255`,
+		},
+	}
+
+	reg := DefaultRegistry()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResult(tt.content, reg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Intent != tt.intent || !maps.Equal(got.Entities, tt.entities) {
+				t.Errorf("got %s %v, want %s %v", got.Intent, got.Entities, tt.intent, tt.entities)
+			}
+		})
+	}
+}
+
+// Route-less devices are neither offered to the model nor described to it.
+func TestResultSchemaRoutedOnly(t *testing.T) {
+	reg := DefaultRegistry()
+
+	schema := resultSchema(reg)
+	entities := schema["properties"].(map[string]any)["entities"].(map[string]any)
+	device := entities["properties"].(map[string]any)["device"].(map[string]any)
+	if got, want := device["enum"], []any{nil, "lamp", "all"}; !slices.Equal(got.([]any), want) {
+		t.Errorf("device enum = %v, want %v", got, want)
+	}
+
+	prompt := reg.prompt()
+	for _, id := range []string{"led", "timer", "alarm"} {
+		if strings.Contains(prompt, `"`+id+`"`) {
+			t.Errorf("prompt lists %s:\n%s", id, prompt)
+		}
+	}
+	if !strings.Contains(prompt, `"lamp"`) || !strings.Contains(prompt, `"all"`) {
+		t.Errorf("prompt:\n%s", prompt)
+	}
+}
+
+// chatServer answers chat completions with replies in turn, the last one
+// over and over, and hands each request's messages to seen.
+func chatServer(t *testing.T, replies []string, seen func(n int, messages []chatMessage)) openai.Client {
+	t.Helper()
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []chatMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		n := requests
+		requests++
+		seen(n, req.Messages)
+
+		content, _ := json.Marshal(replies[min(n, len(replies)-1)])
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1", "object": "chat.completion", "created": 0, "model": "test", "choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": ` + string(content) + `}}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return openai.NewClient(option.WithBaseURL(srv.URL+"/v1/"), option.WithAPIKey("test"), option.WithMaxRetries(0))
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// An unsupported intent/device pair goes back to the model like any other
+// schema problem.
+func TestAnalyzeLLMRepair(t *testing.T) {
+	var requests int
+	client := chatServer(t, []string{
+		`{"intent": "set_mode", "entities": {"device": "lamp", "brightness": null, "mode": "50", "time": null, "date": null}, "query": ""}`,
+		`{"intent": "set_brightness", "entities": {"device": "lamp", "brightness": 128, "mode": null, "time": null, "date": null}, "query": ""}`,
+	}, func(n int, messages []chatMessage) {
+		requests++
+		if n == 1 {
+			last := messages[len(messages)-1]
+			if last.Role != "user" || !strings.Contains(last.Content, `"lamp" does not support intent "set_mode"`) {
+				t.Errorf("repair message = %+v", last)
+			}
+		}
+	})
+
+	got, err := analyzeLLM(context.Background(), client, Config{Registry: DefaultRegistry()}, "подсветка на 50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("%d requests, want the first and a repair", requests)
+	}
+	if got.Intent != "set_brightness" || got.Entities["brightness"] != "128" || got.Source != SourceLLM {
+		t.Errorf("got %+v", got)
+	}
+}
+
+// A device with no routes cannot be repaired into anything; the command is
+// not understood, and the dialogue asks again.
+func TestAnalyzeLLMNoRoute(t *testing.T) {
+	var requests int
+	client := chatServer(t, []string{
+		`{"intent": "turn_off", "entities": {"device": "alarm", "brightness": null, "mode": null, "time": null, "date": null}, "query": "выключи колонки"}`,
+	}, func(int, []chatMessage) { requests++ })
+
+	got, err := analyzeLLM(context.Background(), client, Config{Registry: DefaultRegistry()}, "выключи колонки")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("%d requests, want no repair", requests)
+	}
+	if got.Intent != "unknown" {
+		t.Errorf("got %+v, want unknown", got)
+	}
+}
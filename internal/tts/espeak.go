@@ -3,44 +3,115 @@ package tts
 /*
 #cgo LDFLAGS: -lespeak-ng
 #include <stdlib.h>
+#include <string.h>
 #include <espeak-ng/speak_lib.h>
 
-int
-espeak_say(const char *text)
-{
-	if (!text)
-	{ return -1; }
-
-	espeak_Initialize(AUDIO_OUTPUT_SYNCH_PLAYBACK, 500, NULL, 0);
-	espeak_VOICE specs = { .languages = "ru" };
-	espeak_SetVoiceByProperties(&specs);
-
-	espeak_Synth(text, 500, 0, 0, 0, espeakCHARS_AUTO, NULL, NULL);
-	espeak_Synchronize();
-	espeak_Terminate();
-
-	return 0;
-}
+extern int goSynthCallback(short *wav, int numsamples, espeak_EVENT *events);
 */
 import "C"
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 	"unsafe"
 )
 
-func Speak(text string) error {
-	if text == "" {
-		return nil
+type ESpeakConfig struct {
+	Voices Voices // eSpeak voice names, e.g. "ru", "en-us", "ru+f3"
+	Rate   int    // words per minute, 80-450; 0 is the normal 175
+	Pitch  int    // 0-100, 50 is normal
+}
+
+// ESpeak synthesizes with the eSpeak NG library. The library is a process
+// wide singleton, initialized once and used by one utterance at a time.
+type ESpeak struct {
+	cfg ESpeakConfig
+}
+
+var (
+	espeakOnce sync.Once
+	espeakRate int
+	espeakErr  error
+
+	// guarded by espeakMu; the callback runs inside espeak_Synth
+	espeakMu  sync.Mutex
+	espeakCtx context.Context
+	espeakBuf bytes.Buffer
+)
+
+func NewESpeak(cfg ESpeakConfig) (*ESpeak, error) {
+	espeakOnce.Do(func() {
+		rate := C.espeak_Initialize(C.AUDIO_OUTPUT_SYNCHRONOUS, 500, nil, 0)
+		if rate <= 0 {
+			espeakErr = fmt.Errorf("espeak_Initialize failed: %d", int(rate))
+			return
+		}
+		espeakRate = int(rate)
+		C.espeak_SetSynthCallback((*C.t_espeak_callback)(unsafe.Pointer(C.goSynthCallback)))
+	})
+	if espeakErr != nil {
+		return nil, espeakErr
+	}
+	if cfg.Voices.Default == "" {
+		return nil, errors.New("empty voice")
 	}
+	if cfg.Rate == 0 {
+		cfg.Rate = 175
+	}
+	return &ESpeak{cfg: cfg}, nil
+}
+
+// Synthesize renders the whole utterance up front; eSpeak is much faster
+// than real time.
+func (e *ESpeak) Synthesize(ctx context.Context, text, lang string) (*Speech, error) {
+	espeakMu.Lock()
+	defer espeakMu.Unlock()
+
+	voice := e.cfg.Voices.For(lang)
+	cvoice := C.CString(voice)
+	defer C.free(unsafe.Pointer(cvoice))
+	if rc := C.espeak_SetVoiceByName(cvoice); rc != C.EE_OK {
+		return nil, fmt.Errorf("espeak voice %q: %d", voice, int(rc))
+	}
+	// the parameters are global too, set them every time
+	C.espeak_SetParameter(C.espeakRATE, C.int(e.cfg.Rate), 0)
+	C.espeak_SetParameter(C.espeakPITCH, C.int(e.cfg.Pitch), 0)
 
 	ctext := C.CString(text)
 	defer C.free(unsafe.Pointer(ctext))
 
-	rc := C.espeak_say(ctext)
-	if rc != 0 {
-		return fmt.Errorf("espeak_say failed: %d", int(rc))
+	espeakCtx = ctx
+	espeakBuf.Reset()
+	defer func() { espeakCtx = nil }()
+
+	rc := C.espeak_Synth(unsafe.Pointer(ctext), C.strlen(ctext)+1, 0, C.POS_CHARACTER, 0, C.espeakCHARS_AUTO, nil, nil)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	if rc != C.EE_OK {
+		return nil, fmt.Errorf("espeak_Synth failed: %d", int(rc))
+	}
+
+	pcm := bytes.Clone(espeakBuf.Bytes())
+	return &Speech{
+		ReadCloser: io.NopCloser(bytes.NewReader(pcm)),
+		Rate:       espeakRate,
+	}, nil
+}
 
-	return nil
+//export goSynthCallback
+func goSynthCallback(wav *C.short, n C.int, _ *C.espeak_EVENT) C.int {
+	if wav != nil && n > 0 {
+		samples := unsafe.Slice((*int16)(unsafe.Pointer(wav)), int(n))
+		_ = binary.Write(&espeakBuf, binary.LittleEndian, samples)
+	}
+	if espeakCtx != nil && espeakCtx.Err() != nil {
+		return 1 // abort synthesis
+	}
+	return 0
 }
@@ -0,0 +1,88 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+type OpenAIConfig struct {
+	Model   string        // e.g. "tts-1", "gpt-4o-mini-tts"
+	Voices  Voices        // e.g. "alloy", "nova"
+	Timeout time.Duration // until the audio starts, 0 = no limit
+}
+
+// OpenAI synthesizes through an OpenAI-compatible /audio/speech endpoint;
+// the base URL and HTTP transport come with the client.
+type OpenAI struct {
+	client openai.Client
+	cfg    OpenAIConfig
+}
+
+// openaiRate is the sample rate of the "pcm" response format.
+const openaiRate = 24000
+
+func NewOpenAI(client openai.Client, cfg OpenAIConfig) (*OpenAI, error) {
+	if cfg.Model == "" {
+		return nil, errors.New("empty model")
+	}
+	if cfg.Voices.Default == "" {
+		return nil, errors.New("empty voice")
+	}
+	return &OpenAI{client: client, cfg: cfg}, nil
+}
+
+// Synthesize streams the reply body, so Timeout only covers the wait for
+// the response headers.
+func (o *OpenAI) Synthesize(ctx context.Context, text, lang string) (*Speech, error) {
+	rctx, cancel := context.WithCancel(ctx)
+	var timer *time.Timer
+	if o.cfg.Timeout > 0 {
+		timer = time.AfterFunc(o.cfg.Timeout, cancel)
+	}
+
+	resp, err := o.client.Audio.Speech.New(rctx, openai.AudioSpeechNewParams{
+		Input:          text,
+		Model:          openai.SpeechModel(o.cfg.Model),
+		Voice:          openai.AudioSpeechNewParamsVoice(o.cfg.Voices.For(lang)),
+		ResponseFormat: openai.AudioSpeechNewParamsResponseFormatPCM,
+	})
+	if timer != nil && !timer.Stop() && ctx.Err() == nil {
+		err = fmt.Errorf("timed out after %s", o.cfg.Timeout)
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+	if err != nil {
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("speech request: %w", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); strings.HasPrefix(ct, "application/json") {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("speech request: unexpected %s reply", ct)
+	}
+
+	return &Speech{
+		ReadCloser: &bodyCloser{ReadCloser: resp.Body, cancel: cancel},
+		Rate:       openaiRate,
+	}, nil
+}
+
+type bodyCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *bodyCloser) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
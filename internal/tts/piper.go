@@ -0,0 +1,145 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type PiperConfig struct {
+	Binary string // piper executable, looked up in PATH
+	Voices Voices // .onnx voice model paths
+}
+
+// Piper runs the piper CLI once per utterance and streams its raw output,
+// so playback starts before the whole text is synthesized.
+type Piper struct {
+	cfg   PiperConfig
+	rates map[string]int // model -> sample rate
+}
+
+// piperRate is what the medium and low quality voices use.
+const piperRate = 22050
+
+func NewPiper(cfg PiperConfig) (*Piper, error) {
+	if cfg.Binary == "" {
+		cfg.Binary = "piper"
+	}
+	if _, err := exec.LookPath(cfg.Binary); err != nil {
+		return nil, err
+	}
+
+	models := []string{cfg.Voices.Default}
+	for _, m := range cfg.Voices.ByLang {
+		models = append(models, m)
+	}
+
+	p := &Piper{cfg: cfg, rates: map[string]int{}}
+	for _, m := range models {
+		rate, err := modelRate(m)
+		if err != nil {
+			return nil, err
+		}
+		p.rates[m] = rate
+	}
+	return p, nil
+}
+
+// modelRate reads the sample rate from the .onnx.json next to model.
+func modelRate(model string) (int, error) {
+	if model == "" {
+		return 0, errors.New("empty voice model")
+	}
+	if _, err := os.Stat(model); err != nil {
+		return 0, err
+	}
+
+	data, err := os.ReadFile(model + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return piperRate, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var meta struct {
+		Audio struct {
+			SampleRate int `json:"sample_rate"`
+		} `json:"audio"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return 0, fmt.Errorf("%s.json: %w", model, err)
+	}
+	if meta.Audio.SampleRate <= 0 {
+		return piperRate, nil
+	}
+	return meta.Audio.SampleRate, nil
+}
+
+func (p *Piper) Synthesize(ctx context.Context, text, lang string) (*Speech, error) {
+	model := p.cfg.Voices.For(lang)
+
+	cmd := exec.CommandContext(ctx, p.cfg.Binary, "--model", model, "--output_raw")
+	cmd.Stdin = strings.NewReader(strings.ReplaceAll(text, "\n", " ") + "\n")
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &Speech{
+		ReadCloser: &cmdReader{r: out, cmd: cmd, stderr: stderr},
+		Rate:       p.rates[model],
+	}, nil
+}
+
+// cmdReader reads a process's stdout and reports how it exited at EOF.
+type cmdReader struct {
+	r      io.Reader
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	waited bool
+	err    error
+}
+
+func (c *cmdReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if err == io.EOF {
+		if werr := c.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Close kills the process unless it is done already.
+func (c *cmdReader) Close() error {
+	if !c.waited {
+		_ = c.cmd.Process.Kill()
+		c.wait()
+	}
+	return nil
+}
+
+func (c *cmdReader) wait() error {
+	if c.waited {
+		return c.err
+	}
+	c.waited = true
+
+	if err := c.cmd.Wait(); err != nil {
+		c.err = fmt.Errorf("%s: %w: %s", c.cmd.Path, err, strings.TrimSpace(c.stderr.String()))
+	}
+	return c.err
+}
@@ -0,0 +1,50 @@
+// Package tts turns text into speech. An Engine synthesizes raw PCM with
+// eSpeak NG, a Piper voice model or an OpenAI-compatible /audio/speech
+// endpoint, ready for an audio.Player.
+package tts
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// Engine synthesizes speech. lang is the ISO 639-1 code of text, e.g. the
+// language whisper detected, and picks the voice; "" uses the default one.
+type Engine interface {
+	Synthesize(ctx context.Context, text, lang string) (*Speech, error)
+}
+
+var (
+	_ Engine = (*ESpeak)(nil)
+	_ Engine = (*Piper)(nil)
+	_ Engine = (*OpenAI)(nil)
+)
+
+// Speech is signed 16-bit little-endian mono PCM at Rate Hz, read while it
+// is being synthesized. Close releases it early.
+type Speech struct {
+	io.ReadCloser
+	Rate int
+}
+
+// Voices picks a voice per language.
+type Voices struct {
+	Default string
+	ByLang  map[string]string // language code -> voice
+}
+
+// For returns the voice for lang; a regional code such as "en-US" falls
+// back to "en", anything unlisted to Default.
+func (v Voices) For(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if voice, ok := v.ByLang[lang]; ok {
+		return voice
+	}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		if voice, ok := v.ByLang[base]; ok {
+			return voice
+		}
+	}
+	return v.Default
+}
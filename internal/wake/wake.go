@@ -0,0 +1,142 @@
+package wake
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+
+	"vox/internal/audio"
+	"vox/pkg/stt"
+)
+
+type Config struct {
+	Phrases  []string // any of them wakes VOX, e.g. "вокс", "vox"
+	Language string   // whisper language for the short windows
+	Threads  int
+	MinRMS   float64 // windows quieter than this are not transcribed
+}
+
+// Match is a heard wake phrase. End is the sample offset into the window
+// right after the phrase, where the command starts.
+type Match struct {
+	Phrase string `json:"phrase"`
+	Text   string `json:"text"`
+	End    int    `json:"end"`
+}
+
+// Detector spots a wake phrase by running a small Whisper model over short
+// windows of audio and matching the token stream against the phrases.
+type Detector struct {
+	cfg     Config
+	tr      *stt.Transcriber
+	phrases []string
+}
+
+func NewDetector(tr *stt.Transcriber, cfg Config) (*Detector, error) {
+	if tr == nil {
+		return nil, errors.New("nil transcriber")
+	}
+
+	var phrases []string
+	for _, p := range cfg.Phrases {
+		if p = normalize(p); p != "" {
+			phrases = append(phrases, p)
+		}
+	}
+	if len(phrases) == 0 {
+		return nil, errors.New("no wake phrases")
+	}
+
+	return &Detector{
+		cfg:     cfg,
+		tr:      tr,
+		phrases: phrases,
+	}, nil
+}
+
+// Detect transcribes a window of 16 kHz audio and reports whether it holds
+// a wake phrase.
+func (d *Detector) Detect(ctx context.Context, pcm []float32) (Match, bool, error) {
+	if audio.PeakRMS(pcm, 0) < d.cfg.MinRMS {
+		return Match{}, false, nil
+	}
+
+	res, err := d.tr.TranscribePCM(ctx, pcm, stt.Options{
+		Language:        d.cfg.Language,
+		Threads:         d.cfg.Threads,
+		TokenTimestamps: true,
+	})
+	if err != nil {
+		return Match{}, false, err
+	}
+
+	// walk tokens so the match ends exactly where the phrase does
+	var heard string
+	for _, seg := range res.Segments {
+		toks := seg.Tokens
+		if len(toks) == 0 {
+			toks = []stt.Token{{Text: seg.Text, StartSec: seg.StartSec, EndSec: seg.EndSec}}
+		}
+
+		for _, tok := range toks {
+			heard += tok.Text
+			norm := normalize(heard)
+
+			for _, p := range d.phrases {
+				if containsWord(norm, p) {
+					end := int(tok.EndSec * audio.SampleRate)
+					if end > len(pcm) {
+						end = len(pcm)
+					}
+					return Match{
+						Phrase: p,
+						Text:   strings.TrimSpace(res.Text),
+						End:    end,
+					}, true, nil
+				}
+			}
+		}
+	}
+
+	return Match{}, false, nil
+}
+
+// normalize lowercases, folds ё and turns punctuation into single spaces.
+func normalize(s string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r == 'ё':
+			r = 'е'
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+		default:
+			if !space {
+				b.WriteByte(' ')
+				space = true
+			}
+			continue
+		}
+		b.WriteRune(r)
+		space = false
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// containsWord reports whether phrase occurs in text on word boundaries.
+// A match at the very end of text counts: the next token is not seen yet.
+func containsWord(text, phrase string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], phrase)
+		if j < 0 {
+			return false
+		}
+		start := i + j
+		end := start + len(phrase)
+		if (start == 0 || text[start-1] == ' ') && (end == len(text) || text[end] == ' ') {
+			return true
+		}
+		i = start + 1
+	}
+}
@@ -0,0 +1,435 @@
+// Package hubmock is a stand-in for the Monolith hub: it speaks the
+// TO:VERB:NOUN:ARGS:FROM framing over WebSocket and line-oriented TCP,
+// routes frames between connected shards, answers for scripted devices and
+// records the traffic, so VOX can be exercised without the real hub.
+package hubmock
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+
+	"vox/pkg/protocol"
+)
+
+// Rule scripts a device. A frame matching To, Verb and Noun (empty matches
+// anything) is answered by Reply after Delay instead of being routed:
+//
+//	"OK[:ARG...]"          TO:OK:NOUN:ARGS:DEVICE
+//	"ERR:REASON[:ARG...]"  TO:ERR:REASON:ARGS:DEVICE
+//	""                     no answer at all
+//
+// A SEQ<n> arg of the request is echoed back. Times limits how often the
+// rule fires, 0 is forever.
+type Rule struct {
+	To    string        `toml:"to" json:"to"`
+	Verb  string        `toml:"verb" json:"verb"`
+	Noun  string        `toml:"noun" json:"noun"`
+	Reply string        `toml:"reply" json:"reply"`
+	Delay time.Duration `toml:"delay" json:"delay"`
+	Times int           `toml:"times" json:"times"`
+}
+
+func (r *Rule) matches(m *protocol.Message) bool {
+	return (r.To == "" || strings.EqualFold(r.To, m.To)) &&
+		(r.Verb == "" || strings.EqualFold(r.Verb, m.Verb)) &&
+		(r.Noun == "" || strings.EqualFold(r.Noun, m.Noun))
+}
+
+// Direction of a recorded frame, seen from the hub.
+const (
+	In  = "in"  // received from a shard
+	Out = "out" // sent to a shard
+)
+
+// Record is one frame that passed the hub.
+type Record struct {
+	At    time.Time `json:"at"`
+	Dir   string    `json:"dir"`
+	Peer  string    `json:"peer"` // shard, or the remote address until it sent a frame
+	Frame string    `json:"frame"`
+	Note  string    `json:"note,omitempty"` // "scripted", "no route", "invalid: ..."
+}
+
+type Hub struct {
+	// OnRecord, when set, sees every record as it happens.
+	OnRecord func(Record)
+
+	mu      sync.Mutex
+	peers   map[*peer]struct{}
+	rules   []*Rule
+	traffic []Record
+	notify  chan struct{} // closed and replaced on every record
+
+	closers []io.Closer
+}
+
+type peer struct {
+	addr  string
+	shard string // guarded by Hub.mu
+	send  func(frame string) error
+	close func() error
+}
+
+func New() *Hub {
+	return &Hub{
+		peers:  map[*peer]struct{}{},
+		notify: make(chan struct{}),
+	}
+}
+
+// Start runs a hub on a free local port for the length of a test and
+// returns it with its ws:// url.
+func Start(tb testing.TB) (*Hub, string) {
+	tb.Helper()
+
+	h := New()
+	url, err := h.Listen("127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("hubmock: %v", err)
+	}
+	tb.Cleanup(func() { h.Close() })
+	return h, url
+}
+
+// Script adds rules; earlier rules win.
+func (h *Hub) Script(rules ...Rule) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range rules {
+		h.rules = append(h.rules, &r)
+	}
+}
+
+// Listen serves WebSocket clients on addr ("127.0.0.1:0" picks a port) and
+// returns the ws:// url to give them.
+func (h *Hub) Listen(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	srv := &http.Server{Handler: h}
+	go srv.Serve(ln)
+
+	h.mu.Lock()
+	h.closers = append(h.closers, srv)
+	h.mu.Unlock()
+
+	return "ws://" + ln.Addr().String(), nil
+}
+
+// ListenTCP serves line-oriented clients on addr and returns the tcp:// url.
+func (h *Hub) ListenTCP(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go h.ServeConn(conn, conn.RemoteAddr().String())
+		}
+	}()
+
+	h.mu.Lock()
+	h.closers = append(h.closers, ln)
+	h.mu.Unlock()
+
+	return "tcp://" + ln.Addr().String(), nil
+}
+
+// Close stops the listeners and drops the connected peers, as a real hub
+// going down would.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	closers := h.closers
+	h.closers = nil
+	for p := range h.peers {
+		closers = append(closers, closerFunc(p.close))
+	}
+	h.mu.Unlock()
+
+	var errs []error
+	for _, c := range closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+var upgrader = ws.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// ServeHTTP upgrades the request to a WebSocket peer.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warn("Failed to upgrade", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	defer conn.Close()
+
+	var wmu sync.Mutex
+	p := h.join(r.RemoteAddr, func(frame string) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		return conn.WriteMessage(ws.TextMessage, []byte(frame))
+	}, conn.Close)
+	defer h.leave(p)
+
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		h.receive(p, string(frame))
+	}
+}
+
+// ServeConn serves a line-oriented peer until it disconnects.
+func (h *Hub) ServeConn(rwc io.ReadWriteCloser, addr string) {
+	defer rwc.Close()
+
+	var wmu sync.Mutex
+	p := h.join(addr, func(frame string) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_, err := io.WriteString(rwc, frame+"\n")
+		return err
+	}, rwc.Close)
+	defer h.leave(p)
+
+	sc := bufio.NewScanner(rwc)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			h.receive(p, line)
+		}
+	}
+}
+
+// Send delivers frame as if a shard had sent it, e.g. "VOX:SAY:TEXT:hi:ALARM".
+func (h *Hub) Send(frame string) error {
+	m, err := protocol.Parse(frame)
+	if err != nil {
+		return err
+	}
+	if !h.route(nil, m) {
+		return fmt.Errorf("no route to %s", m.To)
+	}
+	return nil
+}
+
+// Traffic returns everything recorded so far.
+func (h *Hub) Traffic() []Record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Record(nil), h.traffic...)
+}
+
+// Wait blocks until a record matching pred passes the hub, looking at past
+// traffic first.
+func (h *Hub) Wait(ctx context.Context, pred func(Record) bool) (Record, error) {
+	seen := 0
+	for {
+		h.mu.Lock()
+		recs, notify := h.traffic[seen:], h.notify
+		seen = len(h.traffic)
+		for _, r := range recs {
+			if pred(r) {
+				h.mu.Unlock()
+				return r, nil
+			}
+		}
+		h.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return Record{}, ctx.Err()
+		}
+	}
+}
+
+// Frame matches records carrying exactly frame in dir, for Wait.
+func Frame(dir, frame string) func(Record) bool {
+	return func(r Record) bool {
+		return r.Dir == dir && r.Frame == frame
+	}
+}
+
+func (h *Hub) join(addr string, send func(string) error, close func() error) *peer {
+	p := &peer{addr: addr, send: send, close: close}
+
+	h.mu.Lock()
+	h.peers[p] = struct{}{}
+	h.mu.Unlock()
+
+	log.Info("Peer connected", "remote", addr)
+	return p
+}
+
+func (h *Hub) leave(p *peer) {
+	h.mu.Lock()
+	delete(h.peers, p)
+	h.mu.Unlock()
+
+	log.Info("Peer disconnected", "remote", p.addr, "shard", h.name(p))
+}
+
+func (h *Hub) receive(p *peer, frame string) {
+	m, err := protocol.Parse(frame)
+	if err != nil {
+		h.record(In, h.name(p), frame, "invalid: "+err.Error())
+		return
+	}
+
+	// a shard is known by the FROM of its frames
+	h.mu.Lock()
+	p.shard = m.From
+	h.mu.Unlock()
+
+	h.record(In, h.name(p), frame, "")
+	h.route(p, m)
+}
+
+// route answers m from a rule or hands it to the shards it is for: every
+// peer but the sender for ALL, else those known as TO, else the peers that
+// have not said who they are yet.
+func (h *Hub) route(from *peer, m *protocol.Message) bool {
+	if r := h.rule(m); r != nil {
+		go h.answer(from, m, r)
+		return true
+	}
+
+	h.mu.Lock()
+	var named, anon []*peer
+	for p := range h.peers {
+		switch {
+		case p == from:
+		case m.To == "ALL" || p.shard == m.To:
+			named = append(named, p)
+		case p.shard == "":
+			anon = append(anon, p)
+		}
+	}
+	h.mu.Unlock()
+
+	to := named
+	if len(to) == 0 {
+		to = anon
+	}
+	if len(to) == 0 {
+		h.record(Out, m.To, m.String(), "no route")
+		log.Warn("No route", "frame", m.String())
+		return false
+	}
+
+	for _, p := range to {
+		h.deliver(p, m.String(), "")
+	}
+	return true
+}
+
+func (h *Hub) rule(m *protocol.Message) *Rule {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, r := range h.rules {
+		if !r.matches(m) {
+			continue
+		}
+		if r.Times > 0 {
+			r.Times--
+			if r.Times == 0 {
+				h.rules = append(h.rules[:i:i], h.rules[i+1:]...)
+			}
+		}
+		return r
+	}
+	return nil
+}
+
+var seqRe = regexp.MustCompile(`^SEQ\d+$`)
+
+func (h *Hub) answer(to *peer, m *protocol.Message, r *Rule) {
+	if r.Reply == "" {
+		h.record(Out, m.To, "", "scripted: no answer to "+m.String())
+		return
+	}
+	time.Sleep(r.Delay)
+
+	reply := protocol.Message{To: m.From, From: m.To}
+	parts := strings.Split(r.Reply, ":")
+	switch strings.ToUpper(parts[0]) {
+	case "ERR":
+		reason := "FAILED"
+		if len(parts) > 1 {
+			reason = parts[1]
+			parts = parts[1:]
+		}
+		reply.Error(reason, parts[1:]...)
+	default:
+		reply.Ok(m.Noun, parts[1:]...)
+	}
+	if n := len(m.Args); n > 0 && seqRe.MatchString(m.Args[n-1]) {
+		reply.Args = append(reply.Args, m.Args[n-1])
+	}
+
+	if to == nil {
+		// asked through Send: the answer is routed like any frame
+		h.route(nil, &reply)
+		return
+	}
+	h.deliver(to, reply.String(), "scripted")
+}
+
+func (h *Hub) deliver(p *peer, frame, note string) {
+	if err := p.send(frame); err != nil {
+		note = "failed: " + err.Error()
+	}
+	h.record(Out, h.name(p), frame, note)
+}
+
+func (h *Hub) record(dir, peer, frame, note string) {
+	rec := Record{At: time.Now(), Dir: dir, Peer: peer, Frame: frame, Note: note}
+	log.Debug("Traffic", "dir", dir, "peer", peer, "frame", frame, "note", note)
+
+	h.mu.Lock()
+	h.traffic = append(h.traffic, rec)
+	close(h.notify)
+	h.notify = make(chan struct{})
+	f := h.OnRecord
+	h.mu.Unlock()
+
+	if f != nil {
+		f(rec)
+	}
+}
+
+func (h *Hub) name(p *peer) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p.shard != "" {
+		return p.shard
+	}
+	return p.addr
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
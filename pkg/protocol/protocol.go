@@ -1,45 +1,126 @@
 package protocol
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	log "log/slog"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type PtclConfig struct {
-	Shard   string
-	Url     string
-	Reconn  uint
-	Timeout time.Duration
-	EmitOut func(*Message)
+	Shard     string
+	Url       string        // ws://, wss://, tcp:// or serial:// (see dial)
+	Reconn    time.Duration // first reconnect delay
+	ReconnMax time.Duration // backoff cap
+	Ping      time.Duration // keepalive interval, 0 disables it
+	Timeout   time.Duration // default wait for a reply in TransmitReceive
+	Queue     int           // outbound messages kept while disconnected
+	EmitOut   func(*Message)
+	OnState   func(ConnState, error)
+
+	// Transport replaces the one Url names, e.g. an in-memory pipe.
+	Transport Transport
+
+	// Sequence tags every request with a SEQ<n> ARG for shards that echo it
+	// back, so replies are matched exactly.
+	Sequence bool
+
+	// Addresses maps shard names to their two-digit hex IDs. Frames from or
+	// to a known ID are reported under the name; with HexAddresses set,
+	// outgoing frames use the IDs.
+	Addresses    map[string]string
+	HexAddresses bool
+}
+
+var (
+	// ErrClosed is returned for requests that were pending on Close.
+	ErrClosed = errors.New("protocol closed")
+	// ErrNoReply wraps the context error of a request that timed out.
+	ErrNoReply = errors.New("no reply")
+)
+
+// ReplyError is returned by TransmitReceive when the shard answers ERR.
+type ReplyError struct {
+	Reply *Message
+}
+
+func (e *ReplyError) Error() string {
+	reason := strings.Join(append([]string{e.Reply.Noun}, e.Reply.Args...), ":")
+	return fmt.Sprintf("%s replied ERR: %s", e.Reply.From, reason)
 }
 
 type Protocol struct {
-	ws *WebSocket
+	tr  Transport
+	url string
+
+	shard    string
+	timeout  time.Duration
+	sequence bool
+	seq      atomic.Uint64
 
-	shard string
+	addrs map[string]string // shard -> hex ID
+	names map[string]string // hex ID -> shard
+	hex   bool
 
-	waiterMu sync.Mutex
-	waiter   chan *Message
+	pendingMu sync.Mutex
+	pending   []*request
+	done      chan struct{}
 
 	emitOut func(*Message)
+
+	closed atomic.Bool
+}
+
+// request is a TransmitReceive waiting for its reply, or a Broadcast
+// collecting them.
+type request struct {
+	to    string
+	noun  string
+	seq   string // empty unless PtclConfig.Sequence
+	multi bool   // a broadcast, answered by any shard any number of times
+	reply chan *Message
+
+	mu      sync.Mutex
+	replies []*Message // what a broadcast got so far
 }
 
 func NewProtocol(cfg PtclConfig) (*Protocol, error) {
-	ws, err := NewWebSocket(cfg.Url, cfg.Reconn, cfg.Timeout)
-	if err != nil {
-		log.Error("Failed to init ws connection")
-		return nil, err
+	tr := cfg.Transport
+	if tr == nil {
+		var err error
+		tr, err = dial(cfg)
+		if err != nil {
+			log.Error("Failed to init hub connection", "url", cfg.Url)
+			return nil, err
+		}
 	}
 
 	ptcl := &Protocol{
-		shard:   cfg.Shard,
-		ws:      ws,
-		emitOut: cfg.EmitOut,
+		shard:    cfg.Shard,
+		tr:       tr,
+		url:      cfg.Url,
+		timeout:  cfg.Timeout,
+		sequence: cfg.Sequence,
+		done:     make(chan struct{}),
+		emitOut:  cfg.EmitOut,
+		addrs:    map[string]string{},
+		names:    map[string]string{},
+		hex:      cfg.HexAddresses,
+	}
+	for name, id := range cfg.Addresses {
+		if !isHexID(id) {
+			tr.Close()
+			return nil, fmt.Errorf("address of %s: %q is not a two-digit hex ID", name, id)
+		}
+		id = strings.ToUpper(id)
+		ptcl.addrs[name] = id
+		ptcl.names[id] = name
 	}
 
 	return ptcl, nil
@@ -49,12 +130,180 @@ func (ptcl *Protocol) EmitOut(f func(*Message)) {
 	ptcl.emitOut = f
 }
 
-func (ptcl *Protocol) TransmitReceive(v any) (*Message, error) {
-	err := ptcl.Transmit(v)
-	if err != nil {
+// OnState sets the callback for hub connection state changes.
+func (ptcl *Protocol) OnState(f func(ConnState, error)) {
+	ptcl.tr.OnState(f)
+}
+
+// State reports whether the hub connection is currently up.
+func (ptcl *Protocol) State() ConnState {
+	return ptcl.tr.State()
+}
+
+// TransmitReceive sends m and waits for the reply from m.To: an OK/ERR
+// or a message about the same noun, or one carrying the request's SEQ arg.
+// Any number of requests may be in flight. Without a deadline on ctx the
+// configured timeout applies; an ERR reply is returned with a *ReplyError.
+func (ptcl *Protocol) TransmitReceive(ctx context.Context, m Message) (*Message, error) {
+	if ptcl.closed.Load() {
+		return nil, ErrClosed
+	}
+	ctx, cancel := ptcl.withTimeout(ctx)
+	defer cancel()
+
+	req, m := ptcl.newRequest(m, false)
+	ptcl.addPending(req)
+	defer ptcl.dropPending(req)
+
+	if err := ptcl.Transmit(m); err != nil {
 		return nil, err
 	}
-	return ptcl.Receive(), nil
+
+	select {
+	case msg := <-req.reply:
+		req.strip(msg)
+		if msg.Verb == "ERR" {
+			return msg, &ReplyError{Reply: msg}
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w from %s to %s:%s: %w", ErrNoReply, m.To, m.Verb, m.Noun, ctx.Err())
+	case <-ptcl.done:
+		return nil, ErrClosed
+	}
+}
+
+// Broadcast sends m to ALL and collects the answers, ERR ones included,
+// that arrive before the configured timeout or ctx's deadline. Silence is
+// not an error: the window running out returns what came, and only a
+// cancelled ctx returns its error.
+func (ptcl *Protocol) Broadcast(ctx context.Context, m Message) ([]*Message, error) {
+	if ptcl.closed.Load() {
+		return nil, ErrClosed
+	}
+	parent := ctx
+	ctx, cancel := ptcl.withTimeout(ctx)
+	defer cancel()
+
+	m.To = "ALL"
+	req, m := ptcl.newRequest(m, true)
+	ptcl.addPending(req)
+	defer ptcl.dropPending(req)
+
+	if err := ptcl.Transmit(m); err != nil {
+		return nil, err
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		if errors.Is(parent.Err(), context.Canceled) {
+			err = parent.Err()
+		}
+	case <-ptcl.done:
+		err = ErrClosed
+	}
+
+	replies := req.collected()
+	for _, msg := range replies {
+		req.strip(msg)
+	}
+	return replies, err
+}
+
+// Reply is the outcome of one message of a Multicast.
+type Reply struct {
+	To  string
+	Msg *Message
+	Err error
+}
+
+// Multicast sends every message at once and waits for all the replies,
+// returned in the order of msgs.
+func (ptcl *Protocol) Multicast(ctx context.Context, msgs []Message) []Reply {
+	replies := make([]Reply, len(msgs))
+
+	var wg sync.WaitGroup
+	for i, m := range msgs {
+		wg.Go(func() {
+			msg, err := ptcl.TransmitReceive(ctx, m)
+			replies[i] = Reply{To: m.To, Msg: msg, Err: err}
+		})
+	}
+	wg.Wait()
+
+	return replies
+}
+
+// Address is the hex ID of shard, or shard itself when it has none.
+func (ptcl *Protocol) Address(shard string) string {
+	if id, ok := ptcl.addrs[shard]; ok {
+		return id
+	}
+	return shard
+}
+
+// shardName is the shard a TO or FROM field stands for.
+func (ptcl *Protocol) shardName(addr string) string {
+	if isHexID(addr) {
+		if name, ok := ptcl.names[strings.ToUpper(addr)]; ok {
+			return name
+		}
+	}
+	return addr
+}
+
+func (ptcl *Protocol) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); !ok && ptcl.timeout > 0 {
+		return context.WithTimeout(ctx, ptcl.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (ptcl *Protocol) newRequest(m Message, multi bool) (*request, Message) {
+	req := &request{
+		to:    ptcl.shardName(m.To),
+		noun:  strings.ToUpper(m.Noun),
+		multi: multi,
+		reply: make(chan *Message, 1),
+	}
+	if ptcl.sequence {
+		req.seq = fmt.Sprintf("%s%d", seqPrefix, ptcl.seq.Add(1))
+		m.Args = append(slices.Clone(m.Args), req.seq)
+	}
+	return req, m
+}
+
+// deliver hands msg to the request. A broadcast keeps every answer, however
+// many come at once; a single request takes the first.
+func (req *request) deliver(msg *Message) bool {
+	if req.multi {
+		req.mu.Lock()
+		defer req.mu.Unlock()
+		req.replies = append(req.replies, msg)
+		return true
+	}
+	select {
+	case req.reply <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func (req *request) collected() []*Message {
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return slices.Clone(req.replies)
+}
+
+// strip drops the request's own SEQ arg from a reply.
+func (req *request) strip(msg *Message) {
+	if req.seq != "" {
+		msg.Args = slices.DeleteFunc(msg.Args, func(a string) bool {
+			return a == req.seq
+		})
+	}
 }
 
 func (ptcl *Protocol) Transmit(v any) error {
@@ -63,6 +312,9 @@ func (ptcl *Protocol) Transmit(v any) error {
 	switch m := v.(type) {
 	case Message:
 		m.From = ptcl.shard
+		if ptcl.hex {
+			m.To, m.From = ptcl.Address(m.To), ptcl.Address(m.From)
+		}
 		msg = m.String()
 	case string:
 		msg = fmt.Sprintf("%s:%s", m, ptcl.shard)
@@ -74,46 +326,56 @@ func (ptcl *Protocol) Transmit(v any) error {
 		return fmt.Errorf("Unsupported type")
 	}
 
-	err := ptcl.ws.Write([]byte(msg))
+	err := ptcl.tr.Write([]byte(msg))
 	if err != nil {
 		log.Error("Failed to transmit", "msg", msg, "err", err)
 	}
 	return err
 }
 
-func (ptcl *Protocol) Receive() *Message {
-	w := ptcl.installWaiter()
-	defer ptcl.clearWaiter()
-
-	resp, _ := <-w
-	return resp
+// Close stops Run and drops the hub connection; pending requests fail
+// with ErrClosed.
+func (ptcl *Protocol) Close() error {
+	if ptcl.closed.Swap(true) {
+		return nil
+	}
+	close(ptcl.done)
+	return ptcl.tr.Close()
 }
 
 func (ptcl *Protocol) Run() {
 	for {
-		in := ptcl.ws.Read()
-		switch in.kind {
-		case CONN_CLOSE:
-			log.Warn("Trying to reconnect on", "url", ptcl.ws.url)
-			ptcl.ws.TryReconn()
-			log.Info("Succefully reconnected")
+		in := ptcl.tr.Read()
+		if ptcl.closed.Load() {
+			return
+		}
 
-		case READ_FAILURE:
-			log.Error("Failed to read", "err", in.err)
+		switch in.Kind {
+		case CONN_CLOSE, READ_FAILURE:
+			// the next Read reconnects with backoff
+			log.Warn("Hub connection dropped, reconnecting", "url", ptcl.url, "err", in.Err)
 
 		case READ_OK:
-			if !ptcl.checkRecipient(in.msg) {
+			if !ptcl.checkRecipient(in.Msg) {
 				continue
 			}
 
-			msg, err := ptcl.Parse(string(in.msg))
+			msg, err := ptcl.Parse(string(in.Msg))
 			if err != nil {
-				log.Warn("Failed to parse", "msg", string(in.msg), "err", err)
+				log.Warn("Failed to parse", "msg", string(in.Msg), "err", err)
+				continue
+			}
+
+			msg.To, msg.From = ptcl.shardName(msg.To), ptcl.shardName(msg.From)
+			if msg.From == ptcl.shard {
+				// our own broadcast coming back
 				continue
 			}
 
-			if w := ptcl.currentWaiter(); w != nil {
-				w <- msg
+			if req := ptcl.takePending(msg); req != nil {
+				if !req.deliver(msg) {
+					log.Warn("Dropping reply, too many at once", "msg", msg.String())
+				}
 			} else {
 				if ptcl.emitOut != nil {
 					ptcl.emitOut(msg)
@@ -123,33 +385,92 @@ func (ptcl *Protocol) Run() {
 	}
 }
 
-func (ptcl *Protocol) installWaiter() chan *Message {
-	ptcl.waiterMu.Lock()
-	defer ptcl.waiterMu.Unlock()
-	ptcl.waiter = make(chan *Message, 1)
-	return ptcl.waiter
+// seqPrefix starts the sequence ARG, e.g. SEQ42.
+const seqPrefix = "SEQ"
+
+func (ptcl *Protocol) addPending(req *request) {
+	ptcl.pendingMu.Lock()
+	defer ptcl.pendingMu.Unlock()
+	ptcl.pending = append(ptcl.pending, req)
+}
+
+func (ptcl *Protocol) dropPending(req *request) {
+	ptcl.pendingMu.Lock()
+	defer ptcl.pendingMu.Unlock()
+	ptcl.pending = slices.DeleteFunc(ptcl.pending, func(r *request) bool {
+		return r == req
+	})
 }
 
-func (ptcl *Protocol) clearWaiter() {
-	ptcl.waiterMu.Lock()
-	defer ptcl.waiterMu.Unlock()
-	if ptcl.waiter != nil {
-		close(ptcl.waiter)
-		ptcl.waiter = nil
+// takePending finds the request msg answers; single requests are removed,
+// broadcasts keep collecting. A SEQ arg decides on its own; otherwise the
+// oldest request to the sender wins, preferring one about the same noun,
+// then a pending broadcast. Messages that are neither OK/ERR nor about a
+// requested noun are commands of their own and are left for emitOut.
+func (ptcl *Protocol) takePending(msg *Message) *request {
+	ptcl.pendingMu.Lock()
+	defer ptcl.pendingMu.Unlock()
+
+	pick := -1
+	for _, a := range msg.Args {
+		if !strings.HasPrefix(a, seqPrefix) {
+			continue
+		}
+		pick = slices.IndexFunc(ptcl.pending, func(r *request) bool {
+			return r.seq == a
+		})
+		if pick >= 0 {
+			break
+		}
+	}
+
+	reply := msg.Verb == "OK" || msg.Verb == "ERR"
+	if pick < 0 {
+		for i, r := range ptcl.pending {
+			if r.multi || r.to != msg.From {
+				continue
+			}
+			if r.noun == msg.Noun {
+				pick = i
+				break
+			}
+			if reply && pick < 0 {
+				pick = i
+			}
+		}
+	}
+	if pick < 0 {
+		pick = slices.IndexFunc(ptcl.pending, func(r *request) bool {
+			return r.multi && (reply || r.noun == msg.Noun)
+		})
 	}
-}
 
-func (ptcl *Protocol) currentWaiter() chan *Message {
-	ptcl.waiterMu.Lock()
-	defer ptcl.waiterMu.Unlock()
-	return ptcl.waiter
+	if pick < 0 {
+		return nil
+	}
+	req := ptcl.pending[pick]
+	if !req.multi {
+		ptcl.pending = slices.Delete(ptcl.pending, pick, pick+1)
+	}
+	return req
 }
 
+// checkRecipient accepts frames for our shard, by name or hex ID, and
+// broadcasts.
 func (ptcl *Protocol) checkRecipient(msg []byte) bool {
-	return strings.Split(string(msg), ":")[0] == ptcl.shard
+	to, _, _ := strings.Cut(string(msg), ":")
+	if id, ok := ptcl.addrs[ptcl.shard]; ok && strings.EqualFold(to, id) {
+		return true
+	}
+	return to == ptcl.shard || to == "ALL"
 }
 
 func (ptcl *Protocol) Parse(line string) (*Message, error) {
+	return Parse(line)
+}
+
+// Parse reads one TO:VERB:NOUN[:ARGS...]:FROM frame.
+func Parse(line string) (*Message, error) {
 	s := strings.TrimSpace(line)
 	if s == "" {
 		return nil, errors.New("empty message")
@@ -0,0 +1,185 @@
+package protocol_test
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"vox/pkg/hubmock"
+	"vox/pkg/protocol"
+)
+
+// connect runs a shard, VOX unless cfg names another, against hub url until
+// the test ends.
+func connect(t *testing.T, url string, cfg protocol.PtclConfig) *protocol.Protocol {
+	t.Helper()
+	if cfg.Shard == "" {
+		cfg.Shard = "VOX"
+	}
+	cfg.Url = url
+	cfg.Reconn = 10 * time.Millisecond
+	cfg.Timeout = timeout
+	cfg.Queue = cmp.Or(cfg.Queue, 16)
+	ptcl, err := protocol.NewProtocol(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ptcl.Run()
+	t.Cleanup(func() { ptcl.Close() })
+	return ptcl
+}
+
+func TestMulticast(t *testing.T) {
+	hub, url := hubmock.Start(t)
+	hub.Script(
+		hubmock.Rule{To: "VERTEX", Reply: "OK"},
+		hubmock.Rule{To: "ALARM", Reply: "ERR:MUTED"},
+		hubmock.Rule{To: "TIMER"},
+	)
+	ptcl := connect(t, url, protocol.PtclConfig{Sequence: true})
+
+	replies := ptcl.Multicast(context.Background(), []protocol.Message{
+		{To: "VERTEX", Verb: "OFF", Noun: "LAMP"},
+		{To: "ALARM", Verb: "OFF", Noun: "SOUND"},
+		{To: "TIMER", Verb: "OFF", Noun: "DISPLAY"},
+	})
+
+	if len(replies) != 3 {
+		t.Fatalf("%d replies", len(replies))
+	}
+	if r := replies[0]; r.To != "VERTEX" || r.Err != nil || r.Msg.String() != "VOX:OK:LAMP:VERTEX" {
+		t.Errorf("VERTEX: %+v", r)
+	}
+	var re *protocol.ReplyError
+	if r := replies[1]; r.To != "ALARM" || !errors.As(r.Err, &re) || r.Msg.Noun != "MUTED" {
+		t.Errorf("ALARM: %+v", r)
+	}
+	if r := replies[2]; r.To != "TIMER" || !errors.Is(r.Err, protocol.ErrNoReply) || r.Msg != nil {
+		t.Errorf("TIMER: %+v", r)
+	}
+}
+
+// answering runs a shard that answers every command with reply, n times
+// over, and has the hub know it.
+func answering(t *testing.T, hub *hubmock.Hub, url, shard, reply string, n int) {
+	t.Helper()
+	var ptcl *protocol.Protocol
+	ptcl = connect(t, url, protocol.PtclConfig{
+		Shard: shard,
+		Queue: n + 1,
+		EmitOut: func(m *protocol.Message) {
+			verb, args, _ := strings.Cut(reply, ":")
+			for range n {
+				ptcl.Transmit(protocol.Message{
+					To:   m.From,
+					Verb: verb,
+					Noun: cmp.Or(args, m.Noun),
+					Args: m.Args, // the SEQ
+				})
+			}
+		},
+	})
+	if err := ptcl.Transmit(protocol.Message{To: "HUB", Verb: "HELLO", Noun: shard}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.Wait(ctx(t), hubmock.Frame(hubmock.In, "HUB:HELLO:"+shard+":"+shard)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	hub, url := hubmock.Start(t)
+	answering(t, hub, url, "VERTEX", "OK", 1)
+	answering(t, hub, url, "ALARM", "ERR:MUTED", 1)
+	answering(t, hub, url, "TIMER", "", 0)
+	// more in one burst than any buffer the read loop could fill
+	answering(t, hub, url, "PANEL", "OK", 100)
+	ptcl := connect(t, url, protocol.PtclConfig{Sequence: true})
+
+	// the window is the configured timeout, or a caller's deadline instead
+	for name, window := range map[string]time.Duration{
+		"configured timeout": 0,
+		"caller deadline":    timeout / 2,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if window > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, window)
+				defer cancel()
+			}
+			replies, err := ptcl.Broadcast(ctx, protocol.Message{Verb: "OFF", Noun: "LAMP"})
+			if err != nil {
+				t.Fatalf("err = %v, want none when the window ends", err)
+			}
+
+			count := map[string]int{}
+			for _, r := range replies {
+				count[r.String()]++
+			}
+			want := map[string]int{
+				"VOX:OK:LAMP:VERTEX":  1,
+				"VOX:ERR:MUTED:ALARM": 1,
+				"VOX:OK:LAMP:PANEL":   100,
+			}
+			if !maps.Equal(count, want) {
+				t.Errorf("replies %v, want %v", count, want)
+			}
+		})
+	}
+}
+
+func TestBroadcastCancelled(t *testing.T) {
+	hub, url := hubmock.Start(t)
+	answering(t, hub, url, "VERTEX", "OK", 1)
+	ptcl := connect(t, url, protocol.PtclConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		hub.Wait(ctx, hubmock.Frame(hubmock.In, "VOX:OK:LAMP:VERTEX"))
+		cancel()
+	}()
+	replies, err := ptcl.Broadcast(ctx, protocol.Message{Verb: "OFF", Noun: "LAMP"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want the cancellation", err)
+	}
+	if len(replies) > 1 {
+		t.Errorf("replies %v", replies)
+	}
+}
+
+func TestEmitOut(t *testing.T) {
+	hub, url := hubmock.Start(t)
+	got := make(chan *protocol.Message, 1)
+	ptcl := connect(t, url, protocol.PtclConfig{
+		EmitOut: func(m *protocol.Message) { got <- m },
+	})
+
+	// the hub only learns who we are from a frame
+	hub.Script(hubmock.Rule{To: "ALARM", Reply: "OK"})
+	if _, err := ptcl.TransmitReceive(context.Background(), protocol.Message{To: "ALARM", Verb: "PING", Noun: "HUB"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hub.Send("VOX:SAY:TEXT:hello:ALARM"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if m.String() != "VOX:SAY:TEXT:hello:ALARM" {
+			t.Errorf("emitted %s", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("command not emitted")
+	}
+}
+
+func ctx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+}
+
+// OpenSerial talks frames over a UART at baud, 8N1 in raw mode; a board
+// that was unplugged is reopened once it shows up again.
+func OpenSerial(path string, baud int, b Backoff) (*Stream, error) {
+	rate, ok := baudRates[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	return NewStream("serial://"+path, func() (io.ReadWriteCloser, error) {
+		return openSerial(path, rate)
+	}, b)
+}
+
+func openSerial(path string, rate uint32) (*os.File, error) {
+	// non-blocking so that the runtime poller serves reads and Close
+	// interrupts them
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var ioctlErr error
+	err = raw.Control(func(fd uintptr) {
+		t, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		if err != nil {
+			ioctlErr = err
+			return
+		}
+
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+			unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CBAUD
+		t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | rate
+		t.Ispeed, t.Ospeed = rate, rate
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+
+		ioctlErr = unix.IoctlSetTermios(int(fd), unix.TCSETS, t)
+	})
+	if err == nil {
+		err = ioctlErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("configure %s: %w", path, err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPTY returns the master of a new pseudo-terminal and the path of its
+// slave, which stands in for a UART.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	var n, unlock uint32
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); e != 0 {
+		t.Skipf("unlock pty: %v", e)
+	}
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); e != 0 {
+		t.Skipf("pty number: %v", e)
+	}
+	path := fmt.Sprintf("/dev/pts/%d", n)
+	if _, err := os.Stat(path); err != nil {
+		t.Skipf("pty slave: %v", err)
+	}
+	return m, path
+}
+
+func TestOpenSerial(t *testing.T) {
+	m, path := openPTY(t)
+
+	s, err := OpenSerial(path, 115200, Backoff{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// raw mode: no echo and the CR gets through to be stripped by Stream
+	if _, err := m.Write([]byte("VOX:ON:LAMP:VERTEX\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	in := s.Read()
+	if in.Kind != READ_OK || string(in.Msg) != "VOX:ON:LAMP:VERTEX" {
+		t.Fatalf("Read() = %v %q %v", in.Kind, in.Msg, in.Err)
+	}
+
+	if err := s.Write([]byte("VERTEX:OK:LAMP:VOX")); err != nil {
+		t.Fatal(err)
+	}
+	line := make(chan string, 1)
+	go func() {
+		l, _ := bufio.NewReader(m).ReadString('\n')
+		line <- l
+	}()
+	select {
+	case l := <-line:
+		// no OPOST, so the '\n' is not turned into "\r\n"
+		if l != "VERTEX:OK:LAMP:VOX\n" {
+			t.Errorf("board read %q", l)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing reached the board")
+	}
+}
+
+func TestOpenSerialCloseInterruptsRead(t *testing.T) {
+	_, path := openPTY(t)
+
+	s, err := OpenSerial(path, 9600, Backoff{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan Income)
+	go func() { done <- s.Read() }()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+
+	select {
+	case in := <-done:
+		if in.Kind == READ_OK {
+			t.Errorf("Read() = %q after Close", in.Msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not interrupt Read")
+	}
+}
+
+func TestOpenSerialBadBaud(t *testing.T) {
+	if _, err := OpenSerial("/dev/null", 12345, Backoff{}); err == nil {
+		t.Error("baud 12345 accepted")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package protocol
+
+import "errors"
+
+// OpenSerial is only implemented for Linux.
+func OpenSerial(path string, baud int, b Backoff) (*Stream, error) {
+	return nil, errors.New("serial transport is only supported on Linux")
+}
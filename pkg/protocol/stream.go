@@ -0,0 +1,209 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	log "log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrNotConnected is returned by Stream.Write while the link is down.
+var ErrNotConnected = errors.New("not connected")
+
+// Stream is a line-oriented Transport over any io.ReadWriteCloser: a TCP
+// connection, a UART or an in-memory pipe. Frames end with '\n', a '\r'
+// before it is dropped. A lost link is reopened by the next Read.
+type Stream struct {
+	name    string
+	open    func() (io.ReadWriteCloser, error)
+	backoff Backoff
+
+	mu      sync.Mutex
+	rwc     io.ReadWriteCloser
+	r       *bufio.Reader
+	onState func(ConnState, error)
+
+	wmu  sync.Mutex
+	done chan struct{}
+	once sync.Once
+}
+
+// NewStream opens the link once, failing fast, and keeps open for the
+// reconnects. name only shows up in logs.
+func NewStream(name string, open func() (io.ReadWriteCloser, error), b Backoff) (*Stream, error) {
+	log.Debug("init stream protocol", "name", name)
+
+	s := &Stream{
+		name:    name,
+		open:    open,
+		backoff: b,
+		onState: b.OnState,
+		done:    make(chan struct{}),
+	}
+
+	rwc, err := open()
+	if err != nil {
+		log.Error("Failed to open stream", "name", name, "err", err)
+		return nil, err
+	}
+	s.set(rwc)
+
+	return s, nil
+}
+
+// DialTCP connects to a board or bridge speaking frames over plain TCP.
+func DialTCP(addr string, timeout time.Duration, b Backoff) (*Stream, error) {
+	return NewStream("tcp://"+addr, func() (io.ReadWriteCloser, error) {
+		return net.DialTimeout("tcp", addr, timeout)
+	}, b)
+}
+
+func (s *Stream) OnState(f func(ConnState, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onState = f
+}
+
+func (s *Stream) State() ConnState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rwc == nil {
+		return StateDisconnected
+	}
+	return StateConnected
+}
+
+// Write sends one frame; unlike the WebSocket nothing is queued while the
+// link is down.
+func (s *Stream) Write(frame []byte) error {
+	log.Debug("Write stream", "name", s.name, "msg", string(frame))
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.mu.Lock()
+	rwc := s.rwc
+	s.mu.Unlock()
+	if rwc == nil {
+		select {
+		case <-s.done:
+			return ErrClosed
+		default:
+			return ErrNotConnected
+		}
+	}
+
+	// a fresh buffer: frame may have spare capacity the caller still uses
+	f := bytes.TrimRight(frame, "\r\n")
+	line := make([]byte, 0, len(f)+1)
+	line = append(append(line, f...), '\n')
+	if _, err := rwc.Write(line); err != nil {
+		s.drop(rwc, err)
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) Read() Income {
+	s.mu.Lock()
+	rwc, r := s.rwc, s.r
+	s.mu.Unlock()
+	if rwc == nil {
+		if !s.TryReconn() {
+			return Income{Kind: CONN_CLOSE, Err: ErrClosed}
+		}
+		s.mu.Lock()
+		rwc, r = s.rwc, s.r
+		s.mu.Unlock()
+		if rwc == nil {
+			return Income{Kind: CONN_CLOSE, Err: ErrClosed}
+		}
+	}
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			s.drop(rwc, err)
+			if errors.Is(err, io.EOF) {
+				return Income{Kind: CONN_CLOSE, Err: err}
+			}
+			return Income{Kind: READ_FAILURE, Err: err}
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+		log.Debug("Read stream", "name", s.name, "msg", string(line))
+		return Income{Kind: READ_OK, Msg: line}
+	}
+}
+
+// TryReconn reopens the link with backoff; false once closed.
+func (s *Stream) TryReconn() bool {
+	return retry(s.done, s.backoff.Reconn, s.backoff.ReconnMax, func() error {
+		rwc, err := s.open()
+		if err != nil {
+			return err
+		}
+		s.set(rwc)
+		return nil
+	})
+}
+
+func (s *Stream) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		rwc := s.rwc
+		s.rwc = nil
+		s.mu.Unlock()
+
+		if rwc != nil {
+			err = rwc.Close()
+		}
+	})
+	return err
+}
+
+func (s *Stream) set(rwc io.ReadWriteCloser) {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		rwc.Close()
+		return
+	default:
+	}
+	s.rwc = rwc
+	s.r = bufio.NewReader(rwc)
+	f := s.onState
+	s.mu.Unlock()
+
+	if f != nil {
+		f(StateConnected, nil)
+	}
+}
+
+func (s *Stream) drop(rwc io.ReadWriteCloser, cause error) {
+	s.mu.Lock()
+	if s.rwc != rwc {
+		s.mu.Unlock()
+		return
+	}
+	s.rwc = nil
+	f := s.onState
+	s.mu.Unlock()
+
+	rwc.Close()
+	log.Warn("Stream lost", "name", s.name, "err", cause)
+	if f != nil {
+		f(StateDisconnected, cause)
+	}
+}
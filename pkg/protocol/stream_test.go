@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeOpener hands out one end of a fresh net.Pipe per open and keeps the
+// other end for the test; fail makes the next opens fail.
+type pipeOpener struct {
+	mu    sync.Mutex
+	peers chan net.Conn
+	fail  int
+	opens []time.Time
+}
+
+func newPipeOpener() *pipeOpener {
+	return &pipeOpener{peers: make(chan net.Conn, 4)}
+}
+
+func (p *pipeOpener) open() (io.ReadWriteCloser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opens = append(p.opens, time.Now())
+	if p.fail > 0 {
+		p.fail--
+		return nil, errors.New("no such device")
+	}
+	ours, theirs := net.Pipe()
+	p.peers <- theirs
+	return ours, nil
+}
+
+func (p *pipeOpener) peer(t *testing.T) net.Conn {
+	t.Helper()
+	select {
+	case c := <-p.peers:
+		t.Cleanup(func() { c.Close() })
+		return c
+	case <-time.After(time.Second):
+		t.Fatal("stream did not open")
+		return nil
+	}
+}
+
+func TestStreamFraming(t *testing.T) {
+	p := newPipeOpener()
+	s, err := NewStream("pipe", p.open, Backoff{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	peer := p.peer(t)
+
+	go func() {
+		// a frame split over writes, CRLF, and empty lines in between
+		for _, chunk := range []string{"VOX:ON:LA", "MP:VERTEX\r\n", "\r\n\n", "VOX:OK:LAMP:VERTEX\nVOX:SET:LAMP:", "128:VERTEX\n"} {
+			peer.Write([]byte(chunk))
+		}
+	}()
+
+	for _, want := range []string{"VOX:ON:LAMP:VERTEX", "VOX:OK:LAMP:VERTEX", "VOX:SET:LAMP:128:VERTEX"} {
+		in := s.Read()
+		if in.Kind != READ_OK || string(in.Msg) != want {
+			t.Fatalf("Read() = %v %q %v, want %q", in.Kind, in.Msg, in.Err, want)
+		}
+	}
+
+	r := bufio.NewReader(peer)
+	for frame, want := range map[string]string{
+		"VERTEX:ON:LAMP:VOX":      "VERTEX:ON:LAMP:VOX\n",
+		"VERTEX:OFF:LAMP:VOX\r\n": "VERTEX:OFF:LAMP:VOX\n",
+	} {
+		go s.Write([]byte(frame))
+		line, err := r.ReadString('\n')
+		if err != nil || line != want {
+			t.Errorf("peer read %q, %v, want %q", line, err, want)
+		}
+	}
+
+	// spare capacity past the frame is the caller's, not ours to write into
+	buf := []byte("VERTEX:ON:LAMP:VOX|")
+	done := make(chan error, 1)
+	go func() { done <- s.Write(buf[:len(buf)-1]) }()
+	if line, err := r.ReadString('\n'); err != nil || line != "VERTEX:ON:LAMP:VOX\n" {
+		t.Errorf("peer read %q, %v", line, err)
+	}
+	if err := <-done; err != nil || buf[len(buf)-1] != '|' {
+		t.Errorf("Write: %v, buffer now %q", err, buf)
+	}
+}
+
+func TestStreamReconnect(t *testing.T) {
+	states := make(chan ConnState, 8)
+	p := newPipeOpener()
+	s, err := NewStream("pipe", p.open, Backoff{
+		Reconn:    20 * time.Millisecond,
+		ReconnMax: 30 * time.Millisecond,
+		OnState:   func(st ConnState, _ error) { states <- st },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	expect := func(want ConnState) {
+		t.Helper()
+		select {
+		case got := <-states:
+			if got != want {
+				t.Fatalf("state %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s state", want)
+		}
+	}
+	expect(StateConnected)
+
+	// the board goes away and is back after three failed opens
+	p.peer(t).Close()
+	p.mu.Lock()
+	p.fail = 3
+	p.mu.Unlock()
+
+	if in := s.Read(); in.Kind != CONN_CLOSE || !errors.Is(in.Err, io.EOF) {
+		t.Fatalf("Read() = %v %v, want CONN_CLOSE EOF", in.Kind, in.Err)
+	}
+	expect(StateDisconnected)
+	if s.State() != StateDisconnected {
+		t.Errorf("State() = %s", s.State())
+	}
+	if err := s.Write([]byte("VERTEX:ON:LAMP:VOX")); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Write while down = %v, want ErrNotConnected", err)
+	}
+
+	go func() {
+		peer := <-p.peers
+		defer peer.Close()
+		peer.Write([]byte("VOX:OK:LAMP:VERTEX\n"))
+	}()
+	in := s.Read()
+	if in.Kind != READ_OK || string(in.Msg) != "VOX:OK:LAMP:VERTEX" {
+		t.Fatalf("Read() after reconnect = %v %q %v", in.Kind, in.Msg, in.Err)
+	}
+	expect(StateConnected)
+
+	p.mu.Lock()
+	opens := p.opens
+	p.mu.Unlock()
+	if len(opens) != 5 {
+		t.Fatalf("%d opens, want the first, three failures and a success", len(opens))
+	}
+	// 20ms, then 40ms capped to 30ms, each ±20%
+	for i, min := range []time.Duration{16 * time.Millisecond, 24 * time.Millisecond, 24 * time.Millisecond} {
+		if d := opens[i+2].Sub(opens[i+1]); d < min {
+			t.Errorf("retry %d after %s, want at least %s", i+1, d, min)
+		}
+	}
+
+	s.Close()
+	if in := s.Read(); in.Kind != CONN_CLOSE || !errors.Is(in.Err, ErrClosed) {
+		t.Errorf("Read() after Close = %v %v, want ErrClosed", in.Kind, in.Err)
+	}
+	if err := s.Write([]byte("VERTEX:ON:LAMP:VOX")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Write after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestStreamCloseStopsReconnect(t *testing.T) {
+	p := newPipeOpener()
+	s, err := NewStream("pipe", p.open, Backoff{Reconn: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.peer(t).Close()
+	p.mu.Lock()
+	p.fail = 1
+	p.mu.Unlock()
+
+	s.Read() // EOF
+	done := make(chan Income)
+	go func() { done <- s.Read() }()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+
+	select {
+	case in := <-done:
+		if in.Kind != CONN_CLOSE || !errors.Is(in.Err, ErrClosed) {
+			t.Errorf("Read() = %v %v, want ErrClosed", in.Kind, in.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not stop the backoff")
+	}
+}
+
+func TestNewStreamFailsFast(t *testing.T) {
+	p := newPipeOpener()
+	p.fail = 1
+	if _, err := NewStream("pipe", p.open, Backoff{Reconn: time.Hour}); err == nil {
+		t.Fatal("NewStream succeeded without a link")
+	}
+}
@@ -0,0 +1,254 @@
+package protocol_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+
+	"vox/pkg/protocol"
+)
+
+// timeout is the configured wait for a reply.
+const timeout = 200 * time.Millisecond
+
+// answerFunc decides what the far end of the hub connection sends back for
+// a frame, and after how long; "" is silence.
+type answerFunc func(m *protocol.Message) (reply string, delay time.Duration)
+
+// serveShards stands in for the hub and every shard behind it with answer,
+// each frame answered on its own so that slow replies can be overtaken.
+// Frames VOX sent go to the returned channel.
+func serveShards(t *testing.T, answer answerFunc) (string, <-chan string) {
+	t.Helper()
+	seen := make(chan string, 16)
+	upgrader := ws.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		var (
+			wmu sync.Mutex
+			wg  sync.WaitGroup
+		)
+		defer wg.Wait()
+		for {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case seen <- string(frame):
+			default:
+			}
+			m, err := protocol.Parse(string(frame))
+			if err != nil {
+				t.Errorf("VOX sent %q: %v", frame, err)
+				continue
+			}
+			reply, delay := answer(m)
+			if reply == "" {
+				continue
+			}
+			wg.Go(func() {
+				time.Sleep(delay)
+				wmu.Lock()
+				defer wmu.Unlock()
+				conn.WriteMessage(ws.TextMessage, []byte(reply))
+			})
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), seen
+}
+
+func dialShards(t *testing.T, url string, sequence bool) *protocol.Protocol {
+	t.Helper()
+	ptcl, err := protocol.NewProtocol(protocol.PtclConfig{
+		Shard:    "VOX",
+		Url:      url,
+		Timeout:  timeout,
+		Sequence: sequence,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ptcl.Run()
+	t.Cleanup(func() { ptcl.Close() })
+	return ptcl
+}
+
+func TestTransmitReceive(t *testing.T) {
+	tests := []struct {
+		name     string
+		answer   string // sent back from VERTEX
+		delay    time.Duration
+		reply    string
+		replyErr bool // an ERR reply
+		noReply  bool // ErrNoReply after the timeout
+	}{
+		{
+			name:   "ok",
+			answer: "VOX:OK:LAMP:VERTEX",
+			reply:  "VOX:OK:LAMP:VERTEX",
+		},
+		{
+			name:   "ok with args",
+			answer: "VOX:OK:LAMP:128:VERTEX",
+			reply:  "VOX:OK:LAMP:128:VERTEX",
+		},
+		{
+			name:   "a message about the noun",
+			answer: "VOX:STATE:LAMP:ON:VERTEX",
+			reply:  "VOX:STATE:LAMP:ON:VERTEX",
+		},
+		{
+			name:     "err",
+			answer:   "VOX:ERR:BUSY:2:VERTEX",
+			reply:    "VOX:ERR:BUSY:2:VERTEX",
+			replyErr: true,
+		},
+		{
+			name:    "silence",
+			noReply: true,
+		},
+		{
+			name:    "another shard answering",
+			answer:  "VOX:OK:LAMP:ALARM",
+			noReply: true,
+		},
+		{
+			name:   "delay within the timeout",
+			answer: "VOX:OK:LAMP:VERTEX",
+			delay:  timeout / 4,
+			reply:  "VOX:OK:LAMP:VERTEX",
+		},
+		{
+			name:    "delay past the timeout",
+			answer:  "VOX:OK:LAMP:VERTEX",
+			delay:   2 * timeout,
+			noReply: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, seen := serveShards(t, func(*protocol.Message) (string, time.Duration) {
+				return tt.answer, tt.delay
+			})
+			ptcl := dialShards(t, url, false)
+
+			start := time.Now()
+			msg, err := ptcl.TransmitReceive(context.Background(), protocol.Message{To: "VERTEX", Verb: "ON", Noun: "LAMP"})
+			elapsed := time.Since(start)
+
+			var re *protocol.ReplyError
+			switch {
+			case tt.replyErr:
+				if !errors.As(err, &re) || re.Reply != msg {
+					t.Fatalf("err = %v, want a ReplyError", err)
+				}
+				if want := "VERTEX replied ERR: BUSY:2"; err.Error() != want {
+					t.Errorf("err = %q, want %q", err, want)
+				}
+			case tt.noReply:
+				if !errors.Is(err, protocol.ErrNoReply) || !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("err = %v, want ErrNoReply on the deadline", err)
+				}
+				if elapsed < timeout {
+					t.Errorf("gave up after %s, before the %s timeout", elapsed, timeout)
+				}
+			case err != nil:
+				t.Fatalf("err = %v", err)
+			case elapsed < tt.delay:
+				t.Errorf("replied after %s, before the %s delay", elapsed, tt.delay)
+			}
+
+			if tt.reply != "" && (msg == nil || msg.String() != tt.reply) {
+				t.Errorf("reply = %v, want %s", msg, tt.reply)
+			}
+			if frame := <-seen; frame != "VERTEX:ON:LAMP:VOX" {
+				t.Errorf("sent %q", frame)
+			}
+		})
+	}
+}
+
+// A deadline on ctx replaces the configured timeout.
+func TestTransmitReceiveDeadline(t *testing.T) {
+	url, _ := serveShards(t, func(*protocol.Message) (string, time.Duration) {
+		return "", 0
+	})
+	ptcl := dialShards(t, url, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout/4)
+	defer cancel()
+	start := time.Now()
+	_, err := ptcl.TransmitReceive(ctx, protocol.Message{To: "VERTEX", Verb: "ON", Noun: "LAMP"})
+	if !errors.Is(err, protocol.ErrNoReply) {
+		t.Fatalf("err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= timeout {
+		t.Errorf("waited %s, past the caller's deadline", elapsed)
+	}
+
+	ptcl.Close()
+	if _, err := ptcl.TransmitReceive(context.Background(), protocol.Message{To: "VERTEX", Verb: "ON", Noun: "LAMP"}); !errors.Is(err, protocol.ErrClosed) {
+		t.Errorf("after Close: %v, want ErrClosed", err)
+	}
+}
+
+// Two requests about the same noun answered in reverse order get their
+// own replies when they carry a SEQ arg.
+func TestTransmitReceiveSequence(t *testing.T) {
+	url, seen := serveShards(t, func(m *protocol.Message) (string, time.Duration) {
+		seq := m.Args[len(m.Args)-1]
+		if m.Verb == "ON" {
+			return "VOX:OK:LAMP:SLOW:" + seq + ":VERTEX", 150 * time.Millisecond
+		}
+		return "VOX:OK:LAMP:FAST:" + seq + ":VERTEX", 0
+	})
+	ptcl := dialShards(t, url, true)
+
+	type result struct {
+		msg *protocol.Message
+		err error
+		at  time.Time
+	}
+	slow, fast := make(chan result, 1), make(chan result, 1)
+	ask := func(out chan<- result, verb string) {
+		msg, err := ptcl.TransmitReceive(context.Background(), protocol.Message{To: "VERTEX", Verb: verb, Noun: "LAMP"})
+		out <- result{msg, err, time.Now()}
+	}
+
+	go ask(slow, "ON")
+	if frame := <-seen; frame != "VERTEX:ON:LAMP:SEQ1:VOX" {
+		t.Fatalf("sent %q", frame)
+	}
+	go ask(fast, "SET")
+
+	s, f := <-slow, <-fast
+	if s.err != nil || f.err != nil {
+		t.Fatalf("errors %v, %v", s.err, f.err)
+	}
+	// the SEQ arg is ours, not part of the reply
+	if !slices.Equal(s.msg.Args, []string{"SLOW"}) || !slices.Equal(f.msg.Args, []string{"FAST"}) {
+		t.Errorf("slow got %v, fast got %v", s.msg, f.msg)
+	}
+	if !f.at.Before(s.at) {
+		t.Error("replies were not out of order")
+	}
+}
@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"fmt"
+	log "log/slog"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport carries single-line frames between VOX and the hub, or a device
+// board directly. Implementations reconnect on their own: Read brings a
+// dropped link back before reading, and only one goroutine reads.
+type Transport interface {
+	Read() Income
+	Write(frame []byte) error
+	State() ConnState
+	OnState(func(ConnState, error))
+	Close() error
+}
+
+type IncomeKind uint
+
+const (
+	CONN_CLOSE IncomeKind = iota
+	READ_FAILURE
+	READ_OK
+)
+
+type Income struct {
+	Kind IncomeKind
+	Msg  []byte
+	Err  error
+}
+
+// Backoff is the reconnect policy shared by the transports.
+type Backoff struct {
+	Reconn    time.Duration // first delay, doubled up to ReconnMax
+	ReconnMax time.Duration
+	OnState   func(ConnState, error)
+}
+
+// dial opens the transport cfg.Url names:
+//
+//	ws://host:port/path, wss://...     WebSocket hub
+//	tcp://host:port                    line-oriented TCP
+//	serial:///dev/ttyUSB0?baud=115200  line-oriented UART
+func dial(cfg PtclConfig) (Transport, error) {
+	u, err := url.Parse(cfg.Url)
+	if err != nil {
+		return nil, err
+	}
+	b := Backoff{Reconn: cfg.Reconn, ReconnMax: cfg.ReconnMax, OnState: cfg.OnState}
+
+	switch u.Scheme {
+	case "ws", "wss":
+		return NewWebSocket(WsConfig{
+			Url:       cfg.Url,
+			Reconn:    cfg.Reconn,
+			ReconnMax: cfg.ReconnMax,
+			Ping:      cfg.Ping,
+			Timeout:   cfg.Timeout,
+			Queue:     cfg.Queue,
+			OnState:   cfg.OnState,
+		})
+
+	case "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("tcp url %q has no host:port", cfg.Url)
+		}
+		return DialTCP(u.Host, cfg.Timeout, b)
+
+	case "serial":
+		baud := 115200
+		if v := u.Query().Get("baud"); v != "" {
+			baud, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("serial url %q: bad baud %q", cfg.Url, v)
+			}
+		}
+		if u.Path == "" {
+			return nil, fmt.Errorf("serial url %q has no device path", cfg.Url)
+		}
+		return OpenSerial(u.Path, baud, b)
+	}
+
+	return nil, fmt.Errorf("unsupported transport %q, want one of %s", u.Scheme, strings.Join(Schemes, ", "))
+}
+
+// Schemes are the url schemes Dial understands.
+var Schemes = []string{"ws", "wss", "tcp", "serial"}
+
+// retry calls dial until it succeeds, waiting base after the first failure
+// and doubling up to max, with ±20% jitter so that shards do not come back
+// in lockstep. It reports false once done is closed.
+func retry(done <-chan struct{}, base, max time.Duration, dial func() error) bool {
+	delay := base
+	for {
+		select {
+		case <-done:
+			return false
+		default:
+		}
+
+		err := dial()
+		if err == nil {
+			return true
+		}
+
+		wait := jitter(delay)
+		log.Debug("Reconnect failed", "retry_in", wait, "err", err)
+		select {
+		case <-done:
+			return false
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+		if max > 0 && delay > max {
+			delay = max
+		}
+	}
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d + time.Duration((rand.Float64()*0.4-0.2)*float64(d))
+}
@@ -1,94 +1,329 @@
 package protocol
 
 import (
+	"errors"
 	log "log/slog"
+	"sync"
 	"time"
 
 	ws "github.com/gorilla/websocket"
 )
 
+// ConnState is reported to PtclConfig.OnState whenever the hub connection
+// comes up or goes down.
+type ConnState string
+
+const (
+	StateConnected    ConnState = "connected"
+	StateDisconnected ConnState = "disconnected"
+)
+
+// ErrQueueFull is returned by Write when the outbound queue is full, which
+// only happens while the hub is unreachable.
+var ErrQueueFull = errors.New("outbound queue full")
+
+type WsConfig struct {
+	Url       string
+	Reconn    time.Duration // first reconnect delay, doubled up to ReconnMax
+	ReconnMax time.Duration
+	Ping      time.Duration // keepalive interval, 0 disables it
+	Timeout   time.Duration // write deadline and pong wait on top of Ping
+	Queue     int           // messages buffered while disconnected
+	OnState   func(ConnState, error)
+}
+
+// WebSocket is a hub connection that survives drops: writes go through a
+// single writer goroutine and wait in a queue while Run reconnects with
+// exponential backoff; pings detect a silently dead peer.
 type WebSocket struct {
+	cfg WsConfig
+	url string
+
+	mu      sync.Mutex
 	conn    *ws.Conn
-	url     string
-	reconn  uint
-	timeout time.Duration
+	up      chan struct{} // closed while conn is set
+	onState func(ConnState, error)
+
+	out  chan queued
+	done chan struct{}
+	once sync.Once
+}
+
+type queued struct {
+	payload []byte
+	at      time.Time
 }
 
-func NewWebSocket(url string, reconn uint, timeout time.Duration) (*WebSocket, error) {
-	log.Debug("init websocket protocol", "url", url)
+func NewWebSocket(cfg WsConfig) (*WebSocket, error) {
+	log.Debug("init websocket protocol", "url", cfg.Url)
 
+	if cfg.Queue < 1 {
+		cfg.Queue = 1
+	}
 	web := &WebSocket{
-		url:     url,
-		reconn:  reconn,
-		timeout: timeout,
+		cfg:     cfg,
+		url:     cfg.Url,
+		up:      make(chan struct{}),
+		onState: cfg.OnState,
+		out:     make(chan queued, cfg.Queue),
+		done:    make(chan struct{}),
 	}
 
-	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	conn, _, err := ws.DefaultDialer.Dial(cfg.Url, nil)
 	if err != nil {
 		log.Error("Failed to dial url", "err", err)
 		return nil, err
 	}
-	web.conn = conn
+	web.setConn(conn)
+
+	go web.writer()
+	if cfg.Ping > 0 {
+		go web.keepalive()
+	}
 
 	return web, nil
 }
 
+// OnState replaces the connection state callback.
+func (web *WebSocket) OnState(f func(ConnState, error)) {
+	web.mu.Lock()
+	defer web.mu.Unlock()
+	web.onState = f
+}
+
+// State is the current connection state.
+func (web *WebSocket) State() ConnState {
+	web.mu.Lock()
+	defer web.mu.Unlock()
+	if web.conn == nil {
+		return StateDisconnected
+	}
+	return StateConnected
+}
+
+// Write queues payload for the writer. While the hub is down it waits in
+// the queue; messages older than the timeout by the time the connection is
+// back are dropped, whoever sent them has given up on the reply.
 func (web *WebSocket) Write(payload []byte) error {
 	log.Debug("Write ws", "msg", string(payload))
-	err := web.conn.WriteMessage(ws.TextMessage, payload)
-	return err
+	select {
+	case <-web.done:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case web.out <- queued{payload: payload, at: time.Now()}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
 }
 
-type WsIncomeKind uint
+func (web *WebSocket) Close() error {
+	var err error
+	web.once.Do(func() {
+		close(web.done)
 
-const (
-	CONN_CLOSE WsIncomeKind = iota
-	READ_FAILURE
-	READ_OK
-)
+		web.mu.Lock()
+		conn := web.conn
+		web.conn = nil
+		web.mu.Unlock()
 
-type Income struct {
-	kind WsIncomeKind
-	msg  []byte
-	err  error
+		if conn != nil {
+			_ = conn.WriteControl(ws.CloseMessage,
+				ws.FormatCloseMessage(ws.CloseNormalClosure, ""),
+				time.Now().Add(time.Second))
+			err = conn.Close()
+		}
+	})
+	return err
 }
 
+// Read returns the next message, reconnecting first when the connection
+// is down. A failure drops the connection; the next Read brings it back.
+// Only one goroutine may read.
 func (web *WebSocket) Read() Income {
-	/*
-			if web.timeout > 0 {
-		        _ = web.conn.SetReadDeadline(time.Now().Add(web.timeout))
-		    }
-	*/
-	_, msg, err := web.conn.ReadMessage()
+	web.mu.Lock()
+	conn := web.conn
+	web.mu.Unlock()
+	if conn == nil {
+		if !web.TryReconn() {
+			return Income{Kind: CONN_CLOSE, Err: ErrClosed}
+		}
+		if conn = web.waitConn(); conn == nil {
+			return Income{Kind: CONN_CLOSE, Err: ErrClosed}
+		}
+	}
+
+	_, msg, err := conn.ReadMessage()
 	if err != nil {
+		web.drop(conn, err)
 		if WsIsClosed(err) {
 			return Income{
-				kind: CONN_CLOSE,
-				err:  err,
+				Kind: CONN_CLOSE,
+				Err:  err,
 			}
 		}
 		return Income{
-			kind: READ_FAILURE,
-			err:  err,
+			Kind: READ_FAILURE,
+			Err:  err,
 		}
 	}
+	web.extendRead(conn)
 
 	log.Debug("Read ws", "msg", string(msg))
 	return Income{
-		kind: READ_OK,
-		msg:  msg,
+		Kind: READ_OK,
+		Msg:  msg,
 	}
 }
 
-func (web *WebSocket) TryReconn() {
-	for {
+// TryReconn dials until it succeeds, backing off between attempts. It
+// reports false when the socket was closed meanwhile.
+func (web *WebSocket) TryReconn() bool {
+	return retry(web.done, web.cfg.Reconn, web.cfg.ReconnMax, func() error {
 		conn, _, err := ws.DefaultDialer.Dial(web.url, nil)
-		if err == nil {
-			web.conn = conn
-			break
+		if err != nil {
+			return err
+		}
+		web.setConn(conn)
+		return nil
+	})
+}
+
+// writer is the only goroutine writing data frames.
+func (web *WebSocket) writer() {
+	for {
+		var q queued
+		select {
+		case <-web.done:
+			return
+		case q = <-web.out:
+		}
+
+		for {
+			conn := web.waitConn()
+			if conn == nil {
+				return
+			}
+			if web.cfg.Timeout > 0 && time.Since(q.at) > web.cfg.Timeout {
+				log.Warn("Dropping stale message", "msg", string(q.payload), "age", time.Since(q.at).Round(time.Millisecond))
+				break
+			}
+
+			if web.cfg.Timeout > 0 {
+				_ = conn.SetWriteDeadline(time.Now().Add(web.cfg.Timeout))
+			}
+			err := conn.WriteMessage(ws.TextMessage, q.payload)
+			if err == nil {
+				break
+			}
+			log.Error("Failed to write, waiting for reconnect", "msg", string(q.payload), "err", err)
+			web.drop(conn, err)
+		}
+	}
+}
+
+// keepalive pings the hub; a missing pong lets the read deadline expire.
+func (web *WebSocket) keepalive() {
+	tick := time.NewTicker(web.cfg.Ping)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-web.done:
+			return
+		case <-tick.C:
+		}
+
+		web.mu.Lock()
+		conn := web.conn
+		web.mu.Unlock()
+		if conn == nil {
+			continue
+		}
+
+		err := conn.WriteControl(ws.PingMessage, nil, time.Now().Add(web.pongWait()))
+		if err != nil {
+			log.Warn("Ping failed", "err", err)
+			web.drop(conn, err)
 		}
+	}
+}
+
+func (web *WebSocket) pongWait() time.Duration {
+	if web.cfg.Timeout > 0 {
+		return web.cfg.Timeout
+	}
+	return web.cfg.Ping
+}
 
-		time.Sleep(time.Second * time.Duration(web.reconn))
+func (web *WebSocket) extendRead(conn *ws.Conn) {
+	if web.cfg.Ping > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(web.cfg.Ping + web.pongWait()))
+	}
+}
+
+func (web *WebSocket) setConn(conn *ws.Conn) {
+	web.extendRead(conn)
+	conn.SetPongHandler(func(string) error {
+		web.extendRead(conn)
+		return nil
+	})
+
+	web.mu.Lock()
+	select {
+	case <-web.done:
+		web.mu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	web.conn = conn
+	close(web.up)
+	f := web.onState
+	web.mu.Unlock()
+
+	if f != nil {
+		f(StateConnected, nil)
+	}
+}
+
+// drop forgets conn after a failure, unless it was already replaced.
+func (web *WebSocket) drop(conn *ws.Conn, cause error) {
+	web.mu.Lock()
+	if web.conn != conn {
+		web.mu.Unlock()
+		return
+	}
+	web.conn = nil
+	web.up = make(chan struct{})
+	f := web.onState
+	web.mu.Unlock()
+
+	conn.Close()
+	log.Warn("Hub connection lost", "url", web.url, "err", cause)
+	if f != nil {
+		f(StateDisconnected, cause)
+	}
+}
+
+// waitConn blocks until there is a connection; nil once closed.
+func (web *WebSocket) waitConn() *ws.Conn {
+	for {
+		web.mu.Lock()
+		conn, up := web.conn, web.up
+		web.mu.Unlock()
+		if conn != nil {
+			return conn
+		}
+
+		select {
+		case <-up:
+		case <-web.done:
+			return nil
+		}
 	}
 }
 
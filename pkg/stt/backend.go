@@ -0,0 +1,69 @@
+package stt
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Backend turns 16 kHz mono PCM into text. Transcriber runs whisper.cpp
+// locally, OpenAI sends the audio to a transcription endpoint.
+type Backend interface {
+	TranscribePCM(ctx context.Context, pcm16k []float32, opt Options) (Result, error)
+}
+
+var (
+	_ Backend = (*Transcriber)(nil)
+	_ Backend = (*OpenAI)(nil)
+	_ Backend = (*Fallback)(nil)
+)
+
+// Fallback transcribes with Primary and, when that fails or runs past
+// Timeout, retries the same audio with Secondary. A nil Secondary only
+// applies the timeout.
+type Fallback struct {
+	Primary   Backend
+	Secondary Backend
+	Timeout   time.Duration // 0 = no limit for Primary
+
+	// OnFallback is called with the primary error before Secondary runs.
+	OnFallback func(err error)
+}
+
+func (f *Fallback) TranscribePCM(ctx context.Context, pcm16k []float32, opt Options) (Result, error) {
+	pctx := ctx
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		pctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	res, err := f.Primary.TranscribePCM(pctx, pcm16k, opt)
+	if err == nil {
+		return res, nil
+	}
+	if pctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = fmt.Errorf("timed out after %s: %w", f.Timeout, err)
+	}
+
+	return f.Recover(ctx, pcm16k, opt, err)
+}
+
+// Recover runs Secondary after the primary attempt failed with err, which
+// is returned as is when there is no Secondary or ctx itself is done.
+// Callers that ran the primary backend some other way, e.g. as a Stream,
+// use it directly.
+func (f *Fallback) Recover(ctx context.Context, pcm16k []float32, opt Options, err error) (Result, error) {
+	if f.Secondary == nil || ctx.Err() != nil {
+		return Result{}, err
+	}
+	if f.OnFallback != nil {
+		f.OnFallback(err)
+	}
+
+	res, ferr := f.Secondary.TranscribePCM(ctx, pcm16k, opt)
+	if ferr != nil {
+		return Result{}, fmt.Errorf("%w; fallback: %w", err, ferr)
+	}
+	return res, nil
+}
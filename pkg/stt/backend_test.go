@@ -0,0 +1,156 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBackend answers with res or err, or blocks until ctx is done when
+// block is set.
+type fakeBackend struct {
+	res   Result
+	err   error
+	block bool
+	calls int
+}
+
+func (f *fakeBackend) TranscribePCM(ctx context.Context, pcm16k []float32, opt Options) (Result, error) {
+	f.calls++
+	if f.block {
+		<-ctx.Done()
+		return Result{}, ctx.Err()
+	}
+	return f.res, f.err
+}
+
+func TestFallback(t *testing.T) {
+	errPrimary := errors.New("primary down")
+	tests := []struct {
+		name      string
+		primary   *fakeBackend
+		secondary *fakeBackend
+		timeout   time.Duration
+		want      string
+		wantErr   string
+		fellBack  string // error given to OnFallback, "" = not called
+	}{
+		{
+			name:      "primary works",
+			primary:   &fakeBackend{res: Result{Text: "primary"}},
+			secondary: &fakeBackend{res: Result{Text: "secondary"}},
+			want:      "primary",
+		},
+		{
+			name:      "primary fails",
+			primary:   &fakeBackend{err: errPrimary},
+			secondary: &fakeBackend{res: Result{Text: "secondary"}},
+			want:      "secondary",
+			fellBack:  "primary down",
+		},
+		{
+			name:      "primary times out",
+			primary:   &fakeBackend{block: true},
+			secondary: &fakeBackend{res: Result{Text: "secondary"}},
+			timeout:   20 * time.Millisecond,
+			want:      "secondary",
+			fellBack:  "timed out after 20ms",
+		},
+		{
+			name:     "no secondary",
+			primary:  &fakeBackend{err: errPrimary},
+			wantErr:  "primary down",
+			fellBack: "",
+		},
+		{
+			name:      "both fail",
+			primary:   &fakeBackend{err: errPrimary},
+			secondary: &fakeBackend{err: errors.New("secondary down")},
+			wantErr:   "primary down; fallback: secondary down",
+			fellBack:  "primary down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fellBack error
+			f := &Fallback{
+				Primary:    tt.primary,
+				Timeout:    tt.timeout,
+				OnFallback: func(err error) { fellBack = err },
+			}
+			if tt.secondary != nil {
+				f.Secondary = tt.secondary
+			}
+
+			res, err := f.TranscribePCM(context.Background(), []float32{0.1}, Options{})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil || res.Text != tt.want {
+				t.Errorf("res, err = %+v, %v, want %q", res, err, tt.want)
+			}
+
+			switch {
+			case tt.fellBack == "" && fellBack != nil:
+				t.Errorf("OnFallback called with %v", fellBack)
+			case tt.fellBack != "" && (fellBack == nil || !strings.Contains(fellBack.Error(), tt.fellBack)):
+				t.Errorf("OnFallback got %v, want %q", fellBack, tt.fellBack)
+			}
+		})
+	}
+}
+
+func TestFallbackCancelled(t *testing.T) {
+	secondary := &fakeBackend{res: Result{Text: "secondary"}}
+	f := &Fallback{
+		Primary:    &fakeBackend{block: true},
+		Secondary:  secondary,
+		OnFallback: func(err error) { t.Errorf("OnFallback called with %v", err) },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := f.TranscribePCM(ctx, []float32{0.1}, Options{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the caller's deadline", err)
+	}
+	if secondary.calls != 0 {
+		t.Error("secondary ran for a cancelled session")
+	}
+}
+
+func TestFallbackFromOpenAI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"message": "overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	primary, _ := NewOpenAI(testClient(srv.URL), OpenAIConfig{Model: "whisper-1"})
+	var fellBack error
+	f := &Fallback{
+		Primary:    primary,
+		Secondary:  &fakeBackend{res: Result{Text: "local"}},
+		OnFallback: func(err error) { fellBack = err },
+	}
+
+	res, err := f.TranscribePCM(context.Background(), []float32{0.1}, Options{})
+	if err != nil || res.Text != "local" {
+		t.Fatalf("res, err = %+v, %v", res, err)
+	}
+	if fellBack == nil || !strings.Contains(fellBack.Error(), "503") {
+		t.Errorf("OnFallback got %v, want the 503", fellBack)
+	}
+}
+
+func TestFallbackRecover(t *testing.T) {
+	f := &Fallback{Secondary: &fakeBackend{res: Result{Text: "secondary"}}}
+	res, err := f.Recover(context.Background(), []float32{0.1}, Options{}, errors.New("stream failed"))
+	if err != nil || res.Text != "secondary" {
+		t.Errorf("res, err = %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,155 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+type OpenAIConfig struct {
+	Model   string        // e.g. "whisper-1", "gpt-4o-mini-transcribe"
+	Timeout time.Duration // per request, 0 = no limit
+}
+
+// OpenAI transcribes through an OpenAI-compatible /audio/transcriptions
+// endpoint; the base URL and HTTP transport come with the client.
+type OpenAI struct {
+	client openai.Client
+	cfg    OpenAIConfig
+}
+
+func NewOpenAI(client openai.Client, cfg OpenAIConfig) (*OpenAI, error) {
+	if cfg.Model == "" {
+		return nil, errors.New("empty model")
+	}
+	return &OpenAI{client: client, cfg: cfg}, nil
+}
+
+// TranscribePCM uploads pcm16k as a WAV file. Only Language,
+// InitialPrompt, Temperature and OnSegment are honoured; segments are
+// reported once the whole reply has arrived.
+func (o *OpenAI) TranscribePCM(ctx context.Context, pcm16k []float32, opt Options) (Result, error) {
+	if len(pcm16k) == 0 {
+		return Result{}, errors.New("no audio samples provided")
+	}
+
+	if o.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.cfg.Timeout)
+		defer cancel()
+	}
+
+	params := openai.AudioTranscriptionNewParams{
+		File:  openai.File(bytes.NewReader(encodeWAV(pcm16k)), "audio.wav", "audio/wav"),
+		Model: openai.AudioModel(o.cfg.Model),
+	}
+	// the gpt-4o transcribe models only answer in plain json, without
+	// segments or language
+	params.ResponseFormat = openai.AudioResponseFormatVerboseJSON
+	if strings.HasPrefix(o.cfg.Model, "gpt-") {
+		params.ResponseFormat = openai.AudioResponseFormatJSON
+	}
+	if opt.Language != "" && opt.Language != "auto" {
+		params.Language = openai.String(opt.Language)
+	}
+	if opt.InitialPrompt != "" {
+		params.Prompt = openai.String(opt.InitialPrompt)
+	}
+	if opt.Temperature != 0 {
+		params.Temperature = openai.Float(float64(opt.Temperature))
+	}
+
+	resp, err := o.client.Audio.Transcriptions.New(ctx, params)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
+		return Result{}, fmt.Errorf("transcription request: %w", err)
+	}
+
+	res := Result{
+		Text:     strings.TrimSpace(resp.Text),
+		Language: languageCode(resp.Language),
+	}
+	if res.Language == "" {
+		res.Language = opt.Language
+	}
+	for _, s := range resp.Segments {
+		seg := Segment{
+			Text:     s.Text,
+			StartSec: s.Start,
+			EndSec:   s.End,
+		}
+		res.Segments = append(res.Segments, seg)
+		if opt.OnSegment != nil {
+			opt.OnSegment(seg)
+		}
+	}
+
+	return res, nil
+}
+
+// languageCode maps the language names whisper-1 replies with onto the
+// ISO 639-1 codes the local model reports.
+func languageCode(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if code, ok := languageCodes[lang]; ok {
+		return code
+	}
+	return lang
+}
+
+var languageCodes = map[string]string{
+	"english":   "en",
+	"russian":   "ru",
+	"ukrainian": "uk",
+	"german":    "de",
+	"french":    "fr",
+	"spanish":   "es",
+	"italian":   "it",
+	"polish":    "pl",
+	"chinese":   "zh",
+	"japanese":  "ja",
+}
+
+// encodeWAV wraps pcm16k as a 16-bit mono RIFF file.
+func encodeWAV(pcm16k []float32) []byte {
+	const (
+		channels = 1
+		bits     = 16
+	)
+	size := len(pcm16k) * bits / 8
+
+	var buf bytes.Buffer
+	buf.Grow(44 + size)
+
+	le := func(v any) { binary.Write(&buf, binary.LittleEndian, v) }
+
+	buf.WriteString("RIFF")
+	le(uint32(36 + size))
+	buf.WriteString("WAVEfmt ")
+	le(uint32(16))
+	le(uint16(1)) // PCM
+	le(uint16(channels))
+	le(uint32(sampleRate))
+	le(uint32(sampleRate * channels * bits / 8))
+	le(uint16(channels * bits / 8))
+	le(uint16(bits))
+	buf.WriteString("data")
+	le(uint32(size))
+
+	samples := make([]int16, len(pcm16k))
+	for i, s := range pcm16k {
+		samples[i] = int16(math.Round(float64(max(-1, min(1, s))) * math.MaxInt16))
+	}
+	le(samples)
+
+	return buf.Bytes()
+}
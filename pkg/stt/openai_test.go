@@ -0,0 +1,179 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	openai "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+const verboseReply = `{
+	"text": " Включи ночник. ",
+	"language": "russian",
+	"duration": 1.5,
+	"segments": [
+		{"id": 0, "seek": 0, "start": 0.0, "end": 0.8, "text": " Включи", "tokens": [], "temperature": 0, "avg_logprob": -0.2, "compression_ratio": 1, "no_speech_prob": 0.01},
+		{"id": 1, "seek": 0, "start": 0.8, "end": 1.5, "text": " ночник.", "tokens": [], "temperature": 0, "avg_logprob": -0.2, "compression_ratio": 1, "no_speech_prob": 0.01}
+	]
+}`
+
+// upload is what the fake endpoint received.
+type upload struct {
+	path   string
+	fields map[string]string
+	name   string
+	wav    []byte
+}
+
+func newTranscriptionServer(t *testing.T, reply string) (*httptest.Server, <-chan upload) {
+	t.Helper()
+	got := make(chan upload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse multipart: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u := upload{path: r.URL.Path, fields: map[string]string{}}
+		for k, v := range r.MultipartForm.Value {
+			u.fields[k] = v[0]
+		}
+		if fh := r.MultipartForm.File["file"]; len(fh) > 0 {
+			u.name = fh[0].Filename
+			f, _ := fh[0].Open()
+			u.wav, _ = io.ReadAll(f)
+			f.Close()
+		}
+		got <- u
+
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, reply)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func testClient(url string) openai.Client {
+	return openai.NewClient(
+		option.WithBaseURL(url+"/v1/"),
+		option.WithAPIKey("test"),
+		option.WithMaxRetries(0),
+	)
+}
+
+func TestOpenAITranscribe(t *testing.T) {
+	srv, got := newTranscriptionServer(t, verboseReply)
+	o, err := NewOpenAI(testClient(srv.URL), OpenAIConfig{Model: "whisper-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pcm := []float32{0, 0.5, -0.5, 1, -1}
+	var segs []Segment
+	res, err := o.TranscribePCM(context.Background(), pcm, Options{
+		Language:      "ru",
+		InitialPrompt: "ночник",
+		OnSegment:     func(s Segment) { segs = append(segs, s) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := <-got
+	if u.path != "/v1/audio/transcriptions" {
+		t.Errorf("path = %q", u.path)
+	}
+	for field, want := range map[string]string{
+		"model":           "whisper-1",
+		"language":        "ru",
+		"prompt":          "ночник",
+		"response_format": "verbose_json",
+	} {
+		if u.fields[field] != want {
+			t.Errorf("field %s = %q, want %q", field, u.fields[field], want)
+		}
+	}
+	if u.name != "audio.wav" {
+		t.Errorf("file name = %q", u.name)
+	}
+	if !bytes.Equal(u.wav, encodeWAV(pcm)) {
+		t.Errorf("uploaded %d bytes, not the encoded WAV", len(u.wav))
+	}
+
+	if res.Text != "Включи ночник." {
+		t.Errorf("Text = %q", res.Text)
+	}
+	if res.Language != "ru" {
+		t.Errorf("Language = %q, want ru", res.Language)
+	}
+	if len(res.Segments) != 2 || res.Segments[1].StartSec != 0.8 || res.Segments[1].EndSec != 1.5 {
+		t.Errorf("Segments = %+v", res.Segments)
+	}
+	if len(segs) != 2 || segs[0].Text != " Включи" {
+		t.Errorf("OnSegment got %+v", segs)
+	}
+}
+
+func TestOpenAIPlainJSON(t *testing.T) {
+	srv, got := newTranscriptionServer(t, `{"text": "turn on the lamp"}`)
+	o, _ := NewOpenAI(testClient(srv.URL), OpenAIConfig{Model: "gpt-4o-mini-transcribe"})
+
+	res, err := o.TranscribePCM(context.Background(), []float32{0.1}, Options{Language: "auto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := <-got
+	if u.fields["response_format"] != "json" {
+		t.Errorf("response_format = %q, want json", u.fields["response_format"])
+	}
+	if _, ok := u.fields["language"]; ok {
+		t.Errorf("language %q sent for auto", u.fields["language"])
+	}
+	if res.Text != "turn on the lamp" || res.Language != "auto" || res.Segments != nil {
+		t.Errorf("res = %+v", res)
+	}
+}
+
+func TestOpenAITimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server only notices the client going away once the body
+		// is read
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	o, _ := NewOpenAI(testClient(srv.URL), OpenAIConfig{Model: "whisper-1", Timeout: 50 * time.Millisecond})
+	_, err := o.TranscribePCM(context.Background(), []float32{0.1}, Options{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestEncodeWAV(t *testing.T) {
+	wav := encodeWAV([]float32{0, 1, -1, 2})
+	if len(wav) != 44+8 || string(wav[:4]) != "RIFF" || string(wav[8:16]) != "WAVEfmt " {
+		t.Fatalf("bad header % x", wav[:16])
+	}
+	if rate := binary.LittleEndian.Uint32(wav[24:]); rate != sampleRate {
+		t.Errorf("rate = %d", rate)
+	}
+
+	samples := make([]int16, 4)
+	binary.Read(bytes.NewReader(wav[44:]), binary.LittleEndian, samples)
+	want := []int16{0, 32767, -32767, 32767} // clipped
+	for i := range want {
+		if samples[i] != want[i] {
+			t.Errorf("sample %d = %d, want %d", i, samples[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sampleRate = 16000
+
+type StreamOptions struct {
+	Options
+
+	Interval time.Duration // how often the open tail is re-decoded; 0 = 700ms
+	MinAudio time.Duration // new audio needed before a pass; 0 = 1s
+	Holdback time.Duration // audio near the edge never committed; 0 = 1.5s
+
+	// OnPartial receives the running transcript after every pass and
+	// every decoded segment, and once more with Final set.
+	OnPartial func(Partial)
+}
+
+// Partial is the transcript so far: Stable will not change any more,
+// Text is Stable plus the tentative tail.
+type Partial struct {
+	Text   string `json:"text"`
+	Stable string `json:"stable"`
+	Final  bool   `json:"final"`
+}
+
+// Stream transcribes audio while it is still being recorded. Audio that
+// whisper has decoded with enough lookahead is committed, so the final
+// pass after recording only has to decode the short uncommitted tail.
+type Stream struct {
+	t   *Transcriber
+	opt StreamOptions
+
+	mu        sync.Mutex
+	pcm       []float32
+	gen       int // bumped on Reset so stale passes are dropped
+	committed int // samples of pcm already in stable
+	stable    string
+	segs      []Segment
+	lang      string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewStream starts decoding in the background until Finish or ctx ends.
+func (t *Transcriber) NewStream(ctx context.Context, opt StreamOptions) *Stream {
+	if opt.Interval <= 0 {
+		opt.Interval = 700 * time.Millisecond
+	}
+	if opt.MinAudio <= 0 {
+		opt.MinAudio = time.Second
+	}
+	if opt.Holdback <= 0 {
+		opt.Holdback = 1500 * time.Millisecond
+	}
+	opt.TokenTimestamps = true
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Stream{
+		t:      t,
+		opt:    opt,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go s.run(ctx)
+
+	return s
+}
+
+// Write appends freshly recorded audio.
+func (s *Stream) Write(pcm []float32) {
+	s.mu.Lock()
+	s.pcm = append(s.pcm, pcm...)
+	s.mu.Unlock()
+}
+
+// Reset drops everything written and committed so far.
+func (s *Stream) Reset() {
+	s.mu.Lock()
+	s.reset()
+	s.mu.Unlock()
+}
+
+func (s *Stream) reset() {
+	s.pcm = s.pcm[:0]
+	s.gen++
+	s.committed = 0
+	s.stable = ""
+	s.segs = nil
+}
+
+func (s *Stream) run(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.opt.Interval)
+	defer ticker.Stop()
+
+	decoded := 0 // pcm length at the last pass
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		gen, from := s.gen, s.committed
+		if len(s.pcm) < decoded {
+			decoded = 0
+		}
+		fresh := len(s.pcm) - decoded
+		tail := slices.Clone(s.pcm[from:])
+		s.mu.Unlock()
+
+		if fresh < samples(s.opt.MinAudio) {
+			continue
+		}
+		decoded += fresh
+
+		res, err := s.pass(ctx, tail)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		if gen == s.gen {
+			s.commit(from, len(tail), res)
+			s.emit(res.Text, false)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// pass decodes one tail, forwarding segments as they appear.
+func (s *Stream) pass(ctx context.Context, tail []float32) (Result, error) {
+	opt := s.opt.Options
+
+	var text strings.Builder
+	opt.OnSegment = func(seg Segment) {
+		if s.opt.OnSegment != nil {
+			s.opt.OnSegment(seg)
+		}
+		text.WriteString(seg.Text)
+
+		s.mu.Lock()
+		s.emit(text.String(), false)
+		s.mu.Unlock()
+	}
+
+	return s.t.TranscribePCM(ctx, tail, opt)
+}
+
+// commit moves the words that ended well before the edge of the tail into
+// the stable transcript. s.mu must be held.
+func (s *Stream) commit(from, n int, res Result) {
+	if res.Language != "" {
+		s.lang = res.Language
+	}
+
+	var toks []Token
+	for _, seg := range res.Segments {
+		toks = append(toks, seg.Tokens...)
+	}
+
+	limit := float64(n-samples(s.opt.Holdback)) / sampleRate
+	cut := -1
+	for i := 0; i+1 < len(toks); i++ {
+		if toks[i].EndSec > limit {
+			break
+		}
+		// only cut where the next token starts a new word
+		if strings.HasPrefix(toks[i+1].Text, " ") {
+			cut = i
+		}
+	}
+	if cut < 0 {
+		return
+	}
+
+	var text strings.Builder
+	for _, tok := range toks[:cut+1] {
+		text.WriteString(tok.Text)
+	}
+
+	offset := float64(from) / sampleRate
+	s.segs = append(s.segs, Segment{
+		Text:     strings.TrimSpace(text.String()),
+		StartSec: offset + toks[0].StartSec,
+		EndSec:   offset + toks[cut].EndSec,
+		Tokens:   shiftTokens(toks[:cut+1], offset),
+	})
+	s.stable = joinText(s.stable, text.String())
+	s.committed = from + int(toks[cut].EndSec*sampleRate)
+}
+
+// emit reports the stable text followed by tail. s.mu must be held.
+func (s *Stream) emit(tail string, final bool) {
+	if s.opt.OnPartial == nil {
+		return
+	}
+	s.opt.OnPartial(Partial{
+		Text:   joinText(s.stable, tail),
+		Stable: s.stable,
+		Final:  final,
+	})
+}
+
+// Close stops background decoding without a final pass.
+func (s *Stream) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// Finish stops background decoding and transcribes what is left. pcm is
+// the recording as finally kept; if the streamed audio is not a prefix of
+// it, everything is decoded from scratch.
+func (s *Stream) Finish(ctx context.Context, pcm []float32) (Result, error) {
+	s.Close()
+
+	s.mu.Lock()
+	if len(s.pcm) > len(pcm) || !slices.Equal(s.pcm[:s.committed], pcm[:s.committed]) {
+		s.reset()
+	}
+	s.pcm = append(s.pcm[:0], pcm...)
+	tail := s.pcm[s.committed:]
+	empty := len(tail) == 0 && s.stable == ""
+	s.mu.Unlock()
+
+	if empty {
+		return Result{}, errors.New("no audio samples provided")
+	}
+
+	var res Result
+	if len(tail) > 0 {
+		opt := s.opt.Options
+		opt.OnSegment = s.opt.OnSegment
+
+		var err error
+		res, err = s.t.TranscribePCM(ctx, tail, opt)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset := float64(s.committed) / sampleRate
+	segs := slices.Clone(s.segs)
+	for _, seg := range res.Segments {
+		seg.StartSec += offset
+		seg.EndSec += offset
+		seg.Tokens = shiftTokens(seg.Tokens, offset)
+		segs = append(segs, seg)
+	}
+
+	lang := res.Language
+	if lang == "" {
+		lang = s.lang
+	}
+
+	s.emit(res.Text, true)
+
+	return Result{
+		Text:     joinText(s.stable, res.Text),
+		Segments: segs,
+		Language: lang,
+	}, nil
+}
+
+func samples(d time.Duration) int {
+	return int(d * sampleRate / time.Second)
+}
+
+func shiftTokens(toks []Token, offset float64) []Token {
+	out := make([]Token, len(toks))
+	for i, tok := range toks {
+		tok.StartSec += offset
+		tok.EndSec += offset
+		out[i] = tok
+	}
+	return out
+}
+
+func joinText(a, b string) string {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	return a + " " + b
+}
@@ -29,12 +29,24 @@ type Options struct {
 	TemperatureStep float32       // 0 = default
 	Offset          time.Duration // start offset (optional)
 	Duration        time.Duration // max duration (optional)
+
+	// Live callbacks, called from the decoding thread. Setting OnSegment
+	// makes whisper emit one segment per call (single segment mode).
+	OnSegment  func(Segment)
+	OnProgress func(percent int)
 }
 
 type Segment struct {
 	Text     string
 	StartSec float64
 	EndSec   float64
+	Tokens   []Token // only with Options.TokenTimestamps
+}
+
+type Token struct {
+	Text     string
+	StartSec float64
+	EndSec   float64
 }
 
 type Result struct {
@@ -136,8 +148,27 @@ func (t *Transcriber) TranscribePCM(ctx context.Context, pcm16k []float32, opt O
 	}
 
 	// ---- run transcription ----
-	// We pass nil for callbacks here; you can add live segment/progress later.
-	if err := wctx.Process(pcm16k, nil, nil, nil); err != nil {
+	// checked before each encoder run, so cancelling ctx aborts decoding
+	encoderBegin := func() bool {
+		return ctx.Err() == nil
+	}
+
+	var onSegment whisper.SegmentCallback
+	if opt.OnSegment != nil {
+		onSegment = func(s whisper.Segment) {
+			opt.OnSegment(toSegment(wctx, s, opt.TokenTimestamps))
+		}
+	}
+
+	var onProgress whisper.ProgressCallback
+	if opt.OnProgress != nil {
+		onProgress = whisper.ProgressCallback(opt.OnProgress)
+	}
+
+	if err := wctx.Process(pcm16k, encoderBegin, onSegment, onProgress); err != nil {
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
 		return Result{}, fmt.Errorf("process: %w", err)
 	}
 
@@ -159,11 +190,8 @@ func (t *Transcriber) TranscribePCM(ctx context.Context, pcm16k []float32, opt O
 		if err != nil {
 			return Result{}, fmt.Errorf("next segment: %w", err)
 		}
-		segs = append(segs, Segment{
-			Text:     s.Text,
-			StartSec: s.Start.Seconds(),
-			EndSec:   s.End.Seconds(),
-		})
+		seg := toSegment(wctx, s, opt.TokenTimestamps)
+		segs = append(segs, seg)
 		if fullText == "" {
 			fullText = s.Text
 		} else {
@@ -182,3 +210,26 @@ func (t *Transcriber) TranscribePCM(ctx context.Context, pcm16k []float32, opt O
 		Language: lang,
 	}, nil
 }
+
+func toSegment(wctx whisper.Context, s whisper.Segment, withTokens bool) Segment {
+	seg := Segment{
+		Text:     s.Text,
+		StartSec: s.Start.Seconds(),
+		EndSec:   s.End.Seconds(),
+	}
+	if !withTokens {
+		return seg
+	}
+
+	for _, tok := range s.Tokens {
+		if !wctx.IsText(tok) {
+			continue
+		}
+		seg.Tokens = append(seg.Tokens, Token{
+			Text:     tok.Text,
+			StartSec: tok.Start.Seconds(),
+			EndSec:   tok.End.Seconds(),
+		})
+	}
+	return seg
+}